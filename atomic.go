@@ -0,0 +1,88 @@
+package lww
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*AtomicSet is an in-memory TimedSet which never takes a lock, neither to read nor to write.
+This implementation uses a sync.Map from element to an atomic UnixNano timestamp.
+Set updates the timestamp with a compare-and-swap loop that only ever moves it forward,
+so concurrent writers of the same element never block each other and the greatest timestamp wins.
+Get, Len and List never block either, which makes it a good fit for read mostly hot paths.
+
+Timestamps are kept as UnixNano. Get returns them in local time and without a monotonic clock reading.
+
+Note: Elements of set type must be usable as a hash key. Any comparable in Go type can be used.
+*/
+type AtomicSet struct {
+	members sync.Map
+	n       atomic.Int64
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+func (s *AtomicSet) Init() {
+	s.members.Clear()
+	s.n.Store(0)
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *AtomicSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t.
+func (s *AtomicSet) Update(e interface{}, t time.Time) bool {
+	n := t.UnixNano()
+	v, ok := s.members.Load(e)
+	if !ok {
+		ts := new(atomic.Int64)
+		ts.Store(n)
+		if v, ok = s.members.LoadOrStore(e, ts); !ok {
+			s.n.Add(1)
+			return true
+		}
+	}
+	ts := v.(*atomic.Int64)
+	for {
+		c := ts.Load()
+		if n <= c {
+			return false
+		}
+		if ts.CompareAndSwap(c, n) {
+			return true
+		}
+	}
+}
+
+//Len must return the number of members in the set
+func (s *AtomicSet) Len() int {
+	return int(s.n.Load())
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *AtomicSet) Get(e interface{}) (time.Time, bool) {
+	v, ok := s.members.Load(e)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, v.(*atomic.Int64).Load()), true
+}
+
+//List returns list of all elements in the set
+func (s *AtomicSet) List() []interface{} {
+	l := make([]interface{}, 0, s.Len())
+	s.members.Range(func(k, _ interface{}) bool {
+		l = append(l, k)
+		return true
+	})
+	return l
+}
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+func (s *AtomicSet) Range(f func(interface{}, time.Time) bool) {
+	s.members.Range(func(k, v interface{}) bool {
+		return f(k, time.Unix(0, v.(*atomic.Int64).Load()))
+	})
+}
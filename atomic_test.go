@@ -0,0 +1,118 @@
+package lww
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAtomicSet(t *testing.T) {
+	s := AtomicSet{}
+	s.Init()
+	a := customType{name: "John", age: 18}
+
+	if _, ok := s.Get(a); ok || s.Len() != 0 {
+		t.Error("After init get is finding elements")
+	}
+
+	ts := time.Now()
+	s.Set(a, ts)
+	if v, ok := s.Get(a); !ok || !v.Equal(ts) {
+		t.Error("Element was not added correctly", ok, v, ts)
+	}
+
+	ts = ts.Add(time.Second * 10)
+	s.Set(a, ts)
+	if v, ok := s.Get(a); !ok || !v.Equal(ts) {
+		t.Error("Element was not changed correctly if timestamp is different", ok, v, ts)
+	}
+
+	s.Set(a, time.Unix(1, 0))
+	if v, ok := s.Get(a); !ok || !v.Equal(ts) {
+		t.Error("Element with older timestamp is not ignored corretly")
+	}
+
+	s.Set(customType{name: "Frank", age: 20}, ts)
+	if s.Len() != 2 || len(s.List()) != 2 {
+		t.Error("len is wrong after add", s.Len(), s.List())
+	}
+
+	s.Init()
+	if s.Len() != 0 || len(s.List()) != 0 {
+		t.Error("Init did not reset the set")
+	}
+}
+
+func TestAtomicSet_range(t *testing.T) {
+	s := AtomicSet{}
+	s.Init()
+	for i := 0; i < 10; i++ {
+		s.Set(i, time.Unix(int64(i), 0))
+	}
+
+	n := 0
+	s.Range(func(e interface{}, t0 time.Time) bool {
+		if !t0.Equal(time.Unix(int64(e.(int)), 0)) {
+			t.Error("Range did not return correct timestamp", e, t0)
+		}
+		n++
+		return true
+	})
+	if n != 10 {
+		t.Error("Range did not visit all members", n)
+	}
+}
+
+// TestAtomicSet_concurrent is meant to be run with -race. Many writers race on the same
+// elements and the greatest timestamp of all of them must win.
+func TestAtomicSet_concurrent(t *testing.T) {
+	s := AtomicSet{}
+	s.Init()
+	base := time.Now()
+	const writers, elements = 32, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < elements; i++ {
+				s.Set(i, base.Add(time.Duration(w)*time.Millisecond))
+				s.Get(i)
+				s.List()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if s.Len() != elements {
+		t.Error("len is wrong after concurrent add", s.Len())
+	}
+	for i := 0; i < elements; i++ {
+		if v, _ := s.Get(i); !v.Equal(base.Add((writers - 1) * time.Millisecond)) {
+			t.Fatal("concurrent writers did not keep the greatest timestamp", i, v)
+		}
+	}
+}
+
+func BenchmarkAtomicSet_add_different(b *testing.B) {
+	s := AtomicSet{}
+	s.Init()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Set(i, time.Now())
+	}
+}
+
+func BenchmarkAtomicSet_get(b *testing.B) {
+	s := AtomicSet{}
+	s.Init()
+	for i := 0; i < b.N; i++ {
+		s.Set(i, time.Now())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Get(i)
+	}
+}
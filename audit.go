@@ -0,0 +1,388 @@
+package lww
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Ops of AuditRecord.
+const (
+	AuditAdd    = "add"
+	AuditRemove = "remove"
+)
+
+// AuditRecord is one Add or Remove call of an AuditedLWW.
+type AuditRecord struct {
+	// Op is AuditAdd or AuditRemove.
+	Op string
+	// Element is the element which was written.
+	Element interface{}
+	// Time is the timestamp of the write.
+	Time time.Time
+	// TTL is the ttl of AddWithTTL. It is zero for other writes.
+	TTL time.Duration
+	// Replica is the ReplicaID of the LWW which took the write.
+	Replica string
+	// Accepted is true if the set took Time as the newest timestamp of the element.
+	// It is false for writes which lost the race against a newer one, or which failed.
+	Accepted bool
+	// Logged is when the write was done, by the clock of the LWW.
+	Logged time.Time
+}
+
+// AuditSink keeps AuditRecords. It must be safe for concurrent use.
+type AuditSink interface {
+	//Append adds a record to the end of the log.
+	Append(AuditRecord) error
+}
+
+// AuditSource reads AuditRecords in the order they were appended.
+type AuditSource interface {
+	//Next returns the next record. It returns io.EOF after the last one.
+	Next() (AuditRecord, error)
+}
+
+/*AuditedLWW is an LWW which appends every Add and Remove, accepted or not, to Sink.
+
+AddMany and RemoveMany write elements one by one, as each of them needs its own record.
+Writes which do not go through AuditedLWW, like Sweep or Merge of the embedded LWW, are not recorded.
+
+Accepted is what the write returned if the set implements Updater, or ProvenanceUpdater if ReplicaID is set, which
+all underlyings in this package do. Otherwise the timestamp of the element is read before and after the write,
+so a concurrent write to the same element can make Accepted inexact.
+Errors of Sink are reported by LastState. The write to the set is done anyway.
+*/
+type AuditedLWW struct {
+	*LWW
+	Sink AuditSink
+	lastState
+}
+
+// Add works like LWW.Add and records the write.
+func (a *AuditedLWW) Add(e interface{}, t time.Time) {
+	a.record(AuditAdd, e, t, 0, a.update(a.AddSet, e, t))
+}
+
+// Remove works like LWW.Remove and records the write.
+func (a *AuditedLWW) Remove(e interface{}, t time.Time) {
+	a.record(AuditRemove, e, t, 0, a.update(a.RemoveSet, e, t))
+}
+
+// AddWithTTL works like LWW.AddWithTTL and records the write with its ttl.
+func (a *AuditedLWW) AddWithTTL(e interface{}, t time.Time, ttl time.Duration) {
+	if a.ExpireSet == nil {
+		a.checkErr(errNoExpireSet)
+		return
+	}
+	a.ExpireSet.Set(e, t.Add(ttl))
+	a.record(AuditAdd, e, t, ttl, a.update(a.AddSet, e, t))
+}
+
+// AddMany works like calling Add for each element.
+func (a *AuditedLWW) AddMany(es []TimedElement) {
+	for _, e := range es {
+		a.Add(e.Element, e.Time)
+	}
+}
+
+// RemoveMany works like calling Remove for each element.
+func (a *AuditedLWW) RemoveMany(es []TimedElement) {
+	for _, e := range es {
+		a.Remove(e.Element, e.Time)
+	}
+}
+
+func (a *AuditedLWW) record(op string, e interface{}, t time.Time, ttl time.Duration, accepted bool) {
+	a.checkErr(a.Sink.Append(AuditRecord{Op: op, Element: e, Time: t, TTL: ttl, Replica: a.ReplicaID, Accepted: accepted, Logged: a.now()}))
+}
+
+// update writes like set and returns true if s took t as the newest timestamp of e.
+func (lww *LWW) update(s TimedSet, e interface{}, t time.Time) bool {
+	if p, ok := s.(ProvenanceSet); ok && lww.ReplicaID != "" {
+		if u, ok := p.(ProvenanceUpdater); ok {
+			return u.UpdateFrom(e, t, lww.ReplicaID)
+		}
+		old, oldBy, found := p.GetFrom(e)
+		p.SetFrom(e, t, lww.ReplicaID)
+		now, by, ok := p.GetFrom(e)
+		return ok && by == lww.ReplicaID && (!found || now.UnixNano() != old.UnixNano() || by != oldBy)
+	}
+	if u, ok := s.(Updater); ok {
+		return u.Update(e, t)
+	}
+	old, found := s.Get(e)
+	s.Set(e, t)
+	now, ok := s.Get(e)
+	return ok && (!found || now.UnixNano() != old.UnixNano())
+}
+
+// Replay applies the records of src to lww in order and returns how many it applied.
+// Records which were not accepted are applied too. They do not change the state, except for the deadline of an
+// AddWithTTL, which the original write kept as well. Replaying into a fresh LWW builds the state the writes left.
+// The replica of each record is kept if the set implements ProvenanceSet.
+func Replay(src AuditSource, lww *LWW) (int, error) {
+	n := 0
+	for {
+		r, err := src.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		switch r.Op {
+		case AuditAdd:
+			setFrom(lww.AddSet, r.Element, r.Time, r.Replica)
+			if r.TTL != 0 && lww.ExpireSet != nil {
+				lww.ExpireSet.Set(r.Element, r.Time.Add(r.TTL))
+			}
+		case AuditRemove:
+			setFrom(lww.RemoveSet, r.Element, r.Time, r.Replica)
+		default:
+			return n, errors.New("unknown audit op " + strconv.Quote(r.Op))
+		}
+		n++
+	}
+}
+
+// setFrom uses SetFrom of s if replica is set and s implements ProvenanceSet. Otherwise it uses Set.
+func setFrom(s TimedSet, e interface{}, t time.Time, replica string) {
+	if p, ok := s.(ProvenanceSet); ok && replica != "" {
+		p.SetFrom(e, t, replica)
+		return
+	}
+	s.Set(e, t)
+}
+
+// ChanSink sends records to a channel. Append blocks until the record is received.
+type ChanSink chan<- AuditRecord
+
+// Append sends r to the channel.
+func (c ChanSink) Append(r AuditRecord) error {
+	c <- r
+	return nil
+}
+
+// ChanSource reads records from a channel until it is closed.
+type ChanSource <-chan AuditRecord
+
+// Next receives the next record. It returns io.EOF if the channel is closed.
+func (c ChanSource) Next() (AuditRecord, error) {
+	r, ok := <-c
+	if !ok {
+		return r, io.EOF
+	}
+	return r, nil
+}
+
+// auditLine is the JSON form of an AuditRecord. Times are in nanoseconds, so they are kept exactly.
+// The marshalled element is kept as bytes, which JSON encodes in base64, as it does not have to be valid UTF-8.
+type auditLine struct {
+	Op       string `json:"op"`
+	Element  []byte `json:"element"`
+	Time     int64  `json:"time"`
+	TTL      int64  `json:"ttl,omitempty"`
+	Replica  string `json:"replica,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Logged   int64  `json:"logged"`
+}
+
+// FileSink writes records to W as JSON, one per line. W is usually a file opened with os.O_APPEND.
+type FileSink struct {
+	// W is where records are written. Each record is written by a single Write.
+	W io.Writer
+	// Marshal function needs to convert the element to string.
+	Marshal func(interface{}) string
+	mu      sync.Mutex
+}
+
+// Append writes r as a line of JSON.
+func (f *FileSink) Append(r AuditRecord) error {
+	b, err := json.Marshal(auditLine{
+		Op: r.Op, Element: []byte(f.Marshal(r.Element)), Time: r.Time.UnixNano(), TTL: int64(r.TTL),
+		Replica: r.Replica, Accepted: r.Accepted, Logged: r.Logged.UnixNano(),
+	})
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.W.Write(append(b, '\n'))
+	return err
+}
+
+// FileSource reads records written by FileSink from R.
+type FileSource struct {
+	// R is where records are read from.
+	R io.Reader
+	// UnMarshal function needs to be able to convert a Marshalled string back to the element.
+	UnMarshal func(string) interface{}
+	r         *bufio.Reader
+}
+
+// Next reads the next line. It returns io.EOF at the end of R. A last line without a newline is read too.
+func (f *FileSource) Next() (AuditRecord, error) {
+	if f.r == nil {
+		f.r = bufio.NewReader(f.R)
+	}
+	for {
+		b, err := f.r.ReadBytes('\n')
+		if len(strings.TrimSpace(string(b))) == 0 {
+			if err == nil {
+				continue
+			}
+			return AuditRecord{}, err
+		}
+		var l auditLine
+		if jerr := json.Unmarshal(b, &l); jerr != nil {
+			return AuditRecord{}, jerr
+		}
+		return AuditRecord{
+			Op: l.Op, Element: f.UnMarshal(string(l.Element)), Time: time.Unix(0, l.Time), TTL: time.Duration(l.TTL),
+			Replica: l.Replica, Accepted: l.Accepted, Logged: time.Unix(0, l.Logged),
+		}, nil
+	}
+}
+
+// RedisStreamSink appends records to a redis stream with XADD.
+type RedisStreamSink struct {
+	// Pool provides the redis connections to be used. A *redis.Pool can be used directly.
+	Pool ConnProvider
+	// Key is the key of the stream.
+	Key string
+	// Marshal function needs to convert the element to string.
+	Marshal func(interface{}) string
+	// MaxLen trims the stream to about this many records if it is greater than zero.
+	MaxLen int
+}
+
+// Append adds r to the stream with an id generated by redis.
+func (s *RedisStreamSink) Append(r AuditRecord) error {
+	args := redis.Args{s.Key}
+	if s.MaxLen > 0 {
+		args = args.Add("MAXLEN", "~", s.MaxLen)
+	}
+	accepted := "0"
+	if r.Accepted {
+		accepted = "1"
+	}
+	args = args.Add("*",
+		"op", r.Op, "element", s.Marshal(r.Element), "time", r.Time.UnixNano(), "ttl", int64(r.TTL),
+		"replica", r.Replica, "accepted", accepted, "logged", r.Logged.UnixNano())
+	c := s.Pool.Get()
+	defer c.Close()
+	_, err := c.Do("XADD", args...)
+	return err
+}
+
+// RedisStreamSource reads records of a RedisStreamSink with XRANGE, a page at a time.
+type RedisStreamSource struct {
+	// Pool provides the redis connections to be used. A *redis.Pool can be used directly.
+	Pool ConnProvider
+	// Key is the key of the stream.
+	Key string
+	// UnMarshal function needs to be able to convert a Marshalled string back to the element.
+	UnMarshal func(string) interface{}
+	// After is the id of the entry to start after. It is empty to read from the first entry.
+	// Next updates it, so a source can be used again later to read new records.
+	After string
+	page  []AuditRecord
+	ids   []string
+}
+
+// Next returns the next record of the stream. It returns io.EOF if there are no more records now.
+func (s *RedisStreamSource) Next() (AuditRecord, error) {
+	if len(s.page) == 0 {
+		if err := s.fetch(); err != nil {
+			return AuditRecord{}, err
+		}
+		if len(s.page) == 0 {
+			return AuditRecord{}, io.EOF
+		}
+	}
+	r := s.page[0]
+	s.After = s.ids[0]
+	s.page, s.ids = s.page[1:], s.ids[1:]
+	return r, nil
+}
+
+// fetch reads the page after s.After.
+func (s *RedisStreamSource) fetch() error {
+	start := "-"
+	if s.After != "" {
+		next, err := nextStreamID(s.After)
+		if err != nil {
+			return err
+		}
+		start = next
+	}
+	c := s.Pool.Get()
+	defer c.Close()
+	entries, err := redis.Values(c.Do("XRANGE", s.Key, start, "+", "COUNT", redisBatchSize))
+	if err != nil {
+		return err
+	}
+	for _, v := range entries {
+		e, err := redis.Values(v, nil)
+		if err != nil || len(e) != 2 {
+			return errors.New("unexpected XRANGE entry")
+		}
+		id, err := redis.String(e[0], nil)
+		if err != nil {
+			return err
+		}
+		fields, err := redis.StringMap(e[1], nil)
+		if err != nil {
+			return err
+		}
+		r, err := s.record(fields)
+		if err != nil {
+			return errors.New("bad audit record " + id + ": " + err.Error())
+		}
+		s.page = append(s.page, r)
+		s.ids = append(s.ids, id)
+	}
+	return nil
+}
+
+func (s *RedisStreamSource) record(f map[string]string) (AuditRecord, error) {
+	var n [3]int64
+	for i, k := range []string{"time", "ttl", "logged"} {
+		var err error
+		if n[i], err = strconv.ParseInt(f[k], 10, 64); err != nil {
+			return AuditRecord{}, err
+		}
+	}
+	return AuditRecord{
+		Op: f["op"], Element: s.UnMarshal(f["element"]), Time: time.Unix(0, n[0]), TTL: time.Duration(n[1]),
+		Replica: f["replica"], Accepted: f["accepted"] == "1", Logged: time.Unix(0, n[2]),
+	}, nil
+}
+
+// nextStreamID returns the smallest stream id greater than id, so ranges can start after it without an exclusive range.
+func nextStreamID(id string) (string, error) {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return "", errors.New("bad stream id " + strconv.Quote(id))
+	}
+	m, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	q, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	if q == ^uint64(0) {
+		return strconv.FormatUint(m+1, 10) + "-0", nil
+	}
+	return ms + "-" + strconv.FormatUint(q+1, 10), nil
+}
@@ -0,0 +1,257 @@
+package lww
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func str(e interface{}) string   { return e.(string) }
+func unstr(e string) interface{} { return e }
+
+func readAll(t *testing.T, src AuditSource) []AuditRecord {
+	var l []AuditRecord
+	for {
+		r, err := src.Next()
+		if err == io.EOF {
+			return l
+		}
+		if err != nil {
+			t.Fatal("Can't read audit records", err)
+		}
+		l = append(l, r)
+	}
+}
+
+func TestAuditedLWW(t *testing.T) {
+	var buf bytes.Buffer
+	l := LWW{ReplicaID: "a"}
+	a := AuditedLWW{LWW: &l, Sink: &FileSink{W: &buf, Marshal: str}}
+	a.Init()
+	ts := time.Now()
+	a.Add("x", ts)
+	a.Add("x", ts.Add(-time.Second))
+	a.Remove("x", ts.Add(time.Second))
+	a.AddWithTTL("y", ts, time.Hour)
+	a.AddMany([]TimedElement{{"z", ts}, {"y", ts.Add(-time.Second)}})
+	a.RemoveMany([]TimedElement{{"z", ts.Add(time.Second)}})
+	if a.LastState() != nil {
+		t.Fatal("Writing to the sink failed", a.LastState())
+	}
+
+	log := append([]byte(nil), buf.Bytes()...)
+	l2 := readAll(t, &FileSource{R: bytes.NewReader(log), UnMarshal: unstr})
+	want := []struct {
+		op, e    string
+		accepted bool
+	}{{AuditAdd, "x", true}, {AuditAdd, "x", false}, {AuditRemove, "x", true}, {AuditAdd, "y", true}, {AuditAdd, "z", true}, {AuditAdd, "y", false}, {AuditRemove, "z", true}}
+	if len(l2) != len(want) {
+		t.Fatal("Not all writes were recorded", l2)
+	}
+	for i, w := range want {
+		r := l2[i]
+		if r.Op != w.op || r.Element != w.e || r.Accepted != w.accepted || r.Replica != "a" || r.Logged.IsZero() {
+			t.Error("Record is not correct", i, r)
+		}
+	}
+	if !l2[0].Time.Equal(ts) || l2[3].TTL != time.Hour || l2[0].TTL != 0 {
+		t.Error("Record does not keep the timestamp and ttl of the write", l2[0], l2[3])
+	}
+
+	// Replaying the log builds the same state.
+	r := LWW{}
+	r.Init()
+	if n, err := Replay(&FileSource{R: bytes.NewReader(log), UnMarshal: unstr}, &r); err != nil || n != len(want) {
+		t.Fatal("Replay failed", n, err)
+	}
+	for _, e := range []string{"x", "y", "z"} {
+		if got, want := r.State(e), l.State(e); !sameState(got, want) {
+			t.Error("Replayed state is not the same", e, got, want)
+		}
+	}
+}
+
+func TestAuditedLWW_sinks(t *testing.T) {
+	var buf bytes.Buffer
+	ch := make(chan AuditRecord, 100)
+	p := newPool()
+	c := p.Get()
+	c.Do("DEL", "TESTAUDIT")
+	c.Close()
+	for name, s := range map[string]struct {
+		sink AuditSink
+		src  func() AuditSource
+	}{
+		"file":   {&FileSink{W: &buf, Marshal: str}, func() AuditSource { return &FileSource{R: &buf, UnMarshal: unstr} }},
+		"chan":   {ChanSink(ch), func() AuditSource { close(ch); return ChanSource(ch) }},
+		"stream": {&RedisStreamSink{Pool: p, Key: "TESTAUDIT", Marshal: str}, func() AuditSource { return &RedisStreamSource{Pool: p, Key: "TESTAUDIT", UnMarshal: unstr} }},
+	} {
+		// Two replicas share the sets and the log, and one of them loses the race.
+		add := setupProvenanceSet(t, "TESTAUDITADD", true)
+		remove := setupProvenanceSet(t, "TESTAUDITREMOVE", true)
+		a := AuditedLWW{LWW: &LWW{AddSet: &add, RemoveSet: &remove, ReplicaID: "a"}, Sink: s.sink}
+		b := AuditedLWW{LWW: &LWW{AddSet: &add, RemoveSet: &remove, ReplicaID: "b"}, Sink: s.sink}
+		a.Init()
+		b.Init()
+		ts := time.Now()
+		a.Add("x", ts)
+		b.Remove("x", ts.Add(time.Second))
+		a.Remove("x", ts)
+		b.Add("y", ts)
+		a.Add("y", ts)
+
+		l := readAll(t, s.src())
+		if len(l) != 5 {
+			t.Fatal("Not all writes were recorded", name, l)
+		}
+		if r := l[1]; r.Op != AuditRemove || r.Replica != "b" || !r.Accepted || !r.Time.Equal(ts.Add(time.Second)) {
+			t.Error("Record does not tell who removed the element", name, r)
+		}
+		if l[2].Accepted || l[2].Replica != "a" {
+			t.Error("A remove which lost the race was accepted", name, l[2])
+		}
+		if !l[3].Accepted || l[4].Accepted {
+			t.Error("An equal timestamp of a greater replica was not accepted", name, l[3], l[4])
+		}
+
+		r := LWW{}
+		r.Init()
+		if n, err := Replay(&sliceSource{l}, &r); err != nil || n != 5 {
+			t.Fatal("Replay failed", name, n, err)
+		}
+		for _, e := range []string{"x", "y"} {
+			if got, want := r.State(e), a.State(e); !sameState(got, want) {
+				t.Error("Replayed state is not the same", name, e, got, want)
+			}
+		}
+	}
+}
+
+// sameState compares states by the instants of their times.
+func sameState(a, b ElementState) bool {
+	return a.Exists == b.Exists && a.Added.Equal(b.Added) && a.Removed.Equal(b.Removed) && a.Expires.Equal(b.Expires) &&
+		a.AddedBy == b.AddedBy && a.RemovedBy == b.RemovedBy
+}
+
+// sliceSource reads records from a slice.
+type sliceSource struct {
+	l []AuditRecord
+}
+
+func (s *sliceSource) Next() (AuditRecord, error) {
+	if len(s.l) == 0 {
+		return AuditRecord{}, io.EOF
+	}
+	r := s.l[0]
+	s.l = s.l[1:]
+	return r, nil
+}
+
+func TestAuditedLWW_accepted(t *testing.T) {
+	// Sets without Updater are read before and after the write.
+	for _, c := range []struct {
+		s       TimedSet
+		replica string
+	}{{listOnly{&Set{}}, ""}, {&Set{}, ""}, {listOnly{&Set{}}, "a"}, {&Set{}, "a"}} {
+		ch := make(chan AuditRecord, 10)
+		a := AuditedLWW{LWW: &LWW{AddSet: c.s, ReplicaID: c.replica}, Sink: ChanSink(ch)}
+		a.Init()
+		ts := time.Now()
+		a.Add("x", ts)
+		a.Add("x", ts)
+		a.Add("x", ts.Add(time.Second))
+		if r := (<-ch); !r.Accepted || r.Replica != c.replica {
+			t.Error("A new element was not accepted", r)
+		}
+		if r := (<-ch); r.Accepted {
+			t.Error("An equal timestamp was accepted", r)
+		}
+		if r := (<-ch); !r.Accepted {
+			t.Error("A newer timestamp was not accepted", r)
+		}
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Append(AuditRecord) error { return errors.New("disk full") }
+
+func TestAuditedLWW_sinkError(t *testing.T) {
+	a := AuditedLWW{LWW: &LWW{}, Sink: failingSink{}}
+	a.Init()
+	a.Add("x", time.Now())
+	if a.LastState() == nil || !a.Exists("x") {
+		t.Error("An error of the sink was not reported or stopped the write", a.LastState())
+	}
+}
+
+func TestReplay_errors(t *testing.T) {
+	l := LWW{}
+	l.Init()
+	if _, err := Replay(&sliceSource{[]AuditRecord{{Op: "merge"}}}, &l); err == nil {
+		t.Error("Replay did not fail for an unknown op")
+	}
+	if _, err := Replay(&FileSource{R: strings.NewReader("{\n"), UnMarshal: unstr}, &l); err == nil {
+		t.Error("Replay did not fail for a broken line")
+	}
+	n, err := Replay(&FileSource{R: strings.NewReader("\n{\"op\":\"add\",\"element\":\"eA==\",\"time\":1}"), UnMarshal: unstr}, &l)
+	if err != nil || n != 1 || !l.Exists("x") {
+		t.Error("Replay did not read a last line without a newline", n, err)
+	}
+}
+
+func TestFileSink_binary(t *testing.T) {
+	// Marshal may return bytes which are not valid UTF-8.
+	var buf bytes.Buffer
+	a := AuditedLWW{LWW: &LWW{}, Sink: &FileSink{W: &buf, Marshal: str}}
+	a.Init()
+	a.Add("\xff\xfe", time.Now())
+	l := LWW{}
+	l.Init()
+	if n, err := Replay(&FileSource{R: &buf, UnMarshal: unstr}, &l); err != nil || n != 1 {
+		t.Fatal("Replay failed", n, err)
+	}
+	if g := l.Get(); len(g) != 1 || g[0] != "\xff\xfe" {
+		t.Error("Element was not kept exactly", g)
+	}
+}
+
+func TestRedisStreamSource_resume(t *testing.T) {
+	p := newPool()
+	c := p.Get()
+	c.Do("DEL", "TESTAUDITRESUME")
+	c.Close()
+	sink := &RedisStreamSink{Pool: p, Key: "TESTAUDITRESUME", Marshal: str, MaxLen: 1000}
+	src := &RedisStreamSource{Pool: p, Key: "TESTAUDITRESUME", UnMarshal: unstr}
+	defer func(n int) { redisBatchSize = n }(redisBatchSize)
+	redisBatchSize = 2
+	for i := 0; i < 5; i++ {
+		sink.Append(AuditRecord{Op: AuditAdd, Element: "x", Time: time.Unix(0, int64(i))})
+	}
+	if l := readAll(t, src); len(l) != 5 || l[4].Time.UnixNano() != 4 {
+		t.Fatal("Not all records were read over pages", l)
+	}
+	sink.Append(AuditRecord{Op: AuditRemove, Element: "x", Time: time.Unix(0, 5)})
+	if l := readAll(t, src); len(l) != 1 || l[0].Op != AuditRemove {
+		t.Error("Source did not resume after the records it read", l)
+	}
+
+	if id, _ := nextStreamID("5-18446744073709551615"); id != "6-0" {
+		t.Error("nextStreamID did not carry to the next millisecond", id)
+	}
+	if _, err := nextStreamID("5"); err == nil {
+		t.Error("nextStreamID accepted a bad id")
+	}
+}
+
+func BenchmarkAuditedLWW_Add(b *testing.B) {
+	a := AuditedLWW{LWW: &LWW{}, Sink: &FileSink{W: io.Discard, Marshal: func(e interface{}) string { return "e" }}}
+	a.Init()
+	ts := time.Now()
+	for i := 0; i < b.N; i++ {
+		a.Add(i%1000, ts.Add(time.Duration(i)))
+	}
+}
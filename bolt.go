@@ -0,0 +1,189 @@
+package lww
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*BoltSet is a TimedSet kept on disk in a bbolt bucket. It is safe for concurrent use.
+This implementation uses bbolt, a pure Go embedded B+tree key/value store, so a single node
+can keep its sets on disk without running a server.
+Elements are stored as keys of a bucket and their timestamps as 8 byte big-endian UnixNano values.
+That keeps the full nano-second precision of time.Time.
+Each Set runs inside a single read-write transaction, so comparing and updating the timestamp is atomic.
+Add and remove sets can share one DB file by using different buckets.
+*/
+type BoltSet struct {
+	// DB is the bolt database to be used. It can be shared between several BoltSets.
+	DB *bolt.DB
+	// Bucket sets which bucket will be used in DB for the set.
+	Bucket string
+	// Marshal function needs to convert the element to string. Bolt can only store and retrieve byte slices.
+	Marshal func(interface{}) string
+	// UnMarshal function needs to be able to convert a Marshalled string back to a readable structure for consumer of library.
+	UnMarshal func(string) interface{}
+	lastState
+}
+
+func encodeNano(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func decodeNano(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+func (s *BoltSet) Init() {
+	if s.DB == nil {
+		s.checkErr(errors.New("DB must be set"))
+		return
+	}
+	if s.Marshal == nil {
+		s.checkErr(errors.New("Marshal must be set"))
+		return
+	}
+	if s.UnMarshal == nil {
+		s.checkErr(errors.New("UnMarshal must be set"))
+		return
+	}
+	if s.Bucket == "" {
+		s.checkErr(errors.New("Bucket must be set"))
+		return
+	}
+
+	s.checkErr(s.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(s.Bucket))
+		return err
+	}))
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *BoltSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t or it failed.
+func (s *BoltSet) Update(e interface{}, t time.Time) bool {
+	accepted := false
+	s.checkErr(s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		k := []byte(s.Marshal(e))
+		if v := b.Get(k); v != nil && t.UnixNano() <= decodeNano(v).UnixNano() {
+			return nil
+		}
+		accepted = true
+		return b.Put(k, encodeNano(t))
+	}))
+	return accepted && s.LastState() == nil
+}
+
+//SetMany works like calling Set for each element in order, but in a single read-write transaction.
+func (s *BoltSet) SetMany(es []TimedElement) {
+	s.checkErr(s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		for _, e := range es {
+			k := []byte(s.Marshal(e.Element))
+			if v := b.Get(k); v != nil && e.Time.UnixNano() <= decodeNano(v).UnixNano() {
+				continue
+			}
+			if err := b.Put(k, encodeNano(e.Time)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+//Len must return the number of members in the set
+func (s *BoltSet) Len() int {
+	var n int
+	s.checkErr(s.DB.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte(s.Bucket)).Stats().KeyN
+		return nil
+	}))
+	return n
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *BoltSet) Get(e interface{}) (val time.Time, ok bool) {
+	s.checkErr(s.DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(s.Bucket)).Get([]byte(s.Marshal(e))); v != nil {
+			val, ok = decodeNano(v), true
+		}
+		return nil
+	}))
+	return val, ok
+}
+
+//GetMany works like calling Get for each element, but in a single read transaction.
+func (s *BoltSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	ts, oks := make([]time.Time, len(es)), make([]bool, len(es))
+	s.checkErr(s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		for i, e := range es {
+			if v := b.Get([]byte(s.Marshal(e))); v != nil {
+				ts[i], oks[i] = decodeNano(v), true
+			}
+		}
+		return nil
+	}))
+	return ts, oks
+}
+
+//List returns list of all elements in the set
+func (s *BoltSet) List() []interface{} {
+	var l []interface{}
+	s.checkErr(s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(s.Bucket)).ForEach(func(k, _ []byte) error {
+			l = append(l, s.UnMarshal(string(k)))
+			return nil
+		})
+	}))
+	return l
+}
+
+// boltRangePage is how many keys BoltSet.Range reads in one transaction.
+var boltRangePage = 1000
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//It reads the bucket in pages, each in its own short read transaction, and calls f outside of them.
+//So f can use other sets of the same DB, and writers are not blocked for the whole iteration.
+func (s *BoltSet) Range(f func(interface{}, time.Time) bool) {
+	var after []byte
+	for {
+		var keys []string
+		var vals []time.Time
+		err := s.DB.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(s.Bucket)).Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if k != nil && bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(keys) < boltRangePage; k, v = c.Next() {
+				keys = append(keys, string(k))
+				vals = append(vals, decodeNano(v))
+			}
+			return nil
+		})
+		s.checkErr(err)
+		if err != nil || len(keys) == 0 {
+			return
+		}
+		for i := range keys {
+			if !f(s.UnMarshal(keys[i]), vals[i]) {
+				return
+			}
+		}
+		after = []byte(keys[len(keys)-1])
+	}
+}
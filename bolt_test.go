@@ -0,0 +1,203 @@
+package lww
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openBolt(t testing.TB) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "lww.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open bolt for tests", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func setupBoltSet(db *bolt.DB, bucket string) BoltSet {
+	s := BoltSet{DB: db, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, Bucket: bucket}
+	s.Init()
+	return s
+}
+
+func TestBoltSet_init(t *testing.T) {
+	db := openBolt(t)
+	s := BoltSet{}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	s = BoltSet{DB: db, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	s = setupBoltSet(db, "add")
+	if s.LastState() != nil {
+		t.Error("Error raised when all params are present and correct", s.LastState())
+	}
+}
+
+func TestBoltSet(t *testing.T) {
+	s := setupBoltSet(openBolt(t), "add")
+
+	if s.Len() != 0 {
+		t.Error("New set if not empty")
+	}
+
+	a := "data"
+	ts := time.Now()
+	s.Set(a, ts)
+	if s.Len() != 1 {
+		t.Error("Adding element to set failed")
+	}
+	if ts0, ok := s.Get(a); !ok || !ts0.Equal(ts) {
+		t.Error("interface{} is not saved corretly", ts0, ok, ts)
+	}
+
+	ts = ts.Add(time.Second * 10)
+	s.Set(a, ts)
+	if ts0, ok := s.Get(a); !ok || !ts0.Equal(ts) {
+		t.Error("interface{} is not updated corretly")
+	}
+
+	s.Set(a, time.Unix(1, 0))
+	if ts0, ok := s.Get(a); !ok || !ts0.Equal(ts) {
+		t.Error("interface{} with older timestamp is not ignored corretly")
+	}
+
+	s.Set("new data", ts)
+	l := s.List()
+	if len(l) != 2 {
+		t.Error("List is not returning all elements of the set correctly")
+	}
+	if l[0] != "data" || l[1] != "new data" {
+		t.Error("List elements are not correct", l)
+	}
+}
+
+func TestBoltSet_range(t *testing.T) {
+	defer func(n int) { boltRangePage = n }(boltRangePage)
+	boltRangePage = 3
+	db := openBolt(t)
+	add := setupBoltSet(db, "add")
+	remove := setupBoltSet(db, "remove")
+	for i := 0; i < 10; i++ {
+		add.Set(strconv.Itoa(i), time.Unix(int64(i), 0))
+	}
+	remove.Set("5", time.Unix(6, 0))
+
+	var l []interface{}
+	add.Range(func(e interface{}, t0 time.Time) bool {
+		if i, _ := strconv.Atoi(e.(string)); !t0.Equal(time.Unix(int64(i), 0)) {
+			t.Error("Range did not return correct timestamp", e, t0)
+		}
+		l = append(l, e)
+		return true
+	})
+	if len(l) != 10 || l[0] != "0" || l[9] != "9" {
+		t.Error("Range did not visit all members across pages", l)
+	}
+
+	lww := LWW{AddSet: &add, RemoveSet: &remove}
+	if g := lww.Get(); len(g) != 9 {
+		t.Error("LWW over BoltSets did not stream existing elements", g)
+	}
+
+	n := 0
+	add.Range(func(interface{}, time.Time) bool {
+		n++
+		return n < 4
+	})
+	if n != 4 {
+		t.Error("Range did not stop when f returned false", n)
+	}
+}
+
+func TestBoltSet_batch(t *testing.T) {
+	s := setupBoltSet(openBolt(t), "add")
+	ts := time.Now()
+	s.SetMany([]TimedElement{{"a", ts}, {"b", ts}, {"a", ts.Add(-time.Second)}, {"b", ts.Add(time.Second)}})
+
+	v, ok := s.GetMany([]interface{}{"a", "b", "c"})
+	if s.LastState() != nil || !ok[0] || !v[0].Equal(ts) || !ok[1] || !v[1].Equal(ts.Add(time.Second)) || ok[2] {
+		t.Error("SetMany and GetMany did not work like Set and Get", v, ok, s.LastState())
+	}
+}
+
+func TestBoltSet_sharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lww.db")
+	db, _ := bolt.Open(path, 0600, nil)
+	add := setupBoltSet(db, "add")
+	remove := setupBoltSet(db, "remove")
+
+	l := LWW{AddSet: &add, RemoveSet: &remove}
+	l.Init()
+	l.Add("e", time.Unix(10, 0))
+	l.Remove("e", time.Unix(20, 0))
+	l.Add("f", time.Unix(10, 0))
+	db.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal("bolt file is missing", err)
+	}
+	db, _ = bolt.Open(path, 0600, nil)
+	defer db.Close()
+	add = setupBoltSet(db, "add")
+	remove = setupBoltSet(db, "remove")
+	l = LWW{AddSet: &add, RemoveSet: &remove}
+	l.Init()
+	if l.Exists("e") || !l.Exists("f") {
+		t.Error("State was not persisted correctly in separate buckets")
+	}
+}
+
+func BenchmarkBoltSet_add_different(b *testing.B) {
+	s := setupBoltSet(openBolt(b), "add")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Set(strconv.Itoa(i), time.Now())
+	}
+}
+
+func BenchmarkBoltSet_get(b *testing.B) {
+	s := setupBoltSet(openBolt(b), "add")
+	for i := 0; i < b.N; i++ {
+		s.Set(strconv.Itoa(i), time.Now())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Get(strconv.Itoa(i))
+	}
+}
+
+func ExampleBoltSet() {
+	dir, _ := os.MkdirTemp("", "lww")
+	defer os.RemoveAll(dir)
+	db, _ := bolt.Open(filepath.Join(dir, "lww.db"), 0600, nil)
+	defer db.Close()
+
+	add := BoltSet{DB: db, Bucket: "add", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	remove := BoltSet{DB: db, Bucket: "remove", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	l := LWW{AddSet: &add, RemoveSet: &remove}
+	l.Init()
+	l.Add("Data", time.Unix(1451606400, 0))
+	ts, ok := add.Get("Data")
+	fmt.Println(ok)
+	fmt.Println(ts.Unix())
+	fmt.Println(l.Exists("Data"))
+	// Output:
+	// true
+	// 1451606400
+	// true
+}
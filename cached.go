@@ -0,0 +1,253 @@
+package lww
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+/*CachedSet is a TimedSet which keeps a RedisSet and an in-memory copy of it in sync.
+It serves Get, Len and List from a local Set and writes through to a RedisSet.
+That makes reads as fast as Set while the data stays persistent and sharable through a redis server.
+
+Every accepted write to a RedisSet is published by redis on a channel named after its SetKey.
+CachedSet subscribes to that channel and applies the notifications to its local Set, so writes
+from other processes sharing the same SetKey show up locally. After each (re)subscription the local
+Set is replaced by a full copy of the ZSET, so notifications missed while disconnected are not lost.
+
+Timestamps in the local Set have the same microsecond precision as RedisSet.
+Call Close to stop following the notifications.
+*/
+type CachedSet struct {
+	// Remote is the RedisSet that all writes go through to. CachedSet calls its Init.
+	Remote *RedisSet
+	// Dial opens a new connection to the redis server of Remote. It is used for the subscription which
+	// holds its connection as long as it lasts, so it should not borrow from Remote.Pool.
+	Dial func() (redis.Conn, error)
+	// RetryInterval is how long to wait before reconnecting after the subscription is lost. Default is one second.
+	RetryInterval time.Duration
+
+	local atomic.Pointer[Set]
+	mu    sync.Mutex
+	conn  redis.Conn
+	done  chan struct{}
+	wg    sync.WaitGroup
+	lastState
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+//It waits for the first subscription and full copy of the remote set before returning.
+//Calling it again stops following with the previous subscription first, like Close.
+func (s *CachedSet) Init() {
+	s.Close()
+	local := &Set{}
+	local.Init()
+	s.local.Store(local)
+	if s.Remote == nil {
+		s.checkErr(errors.New("Remote must be set"))
+		return
+	}
+	if s.Dial == nil {
+		s.checkErr(errors.New("Dial must be set"))
+		return
+	}
+	s.Remote.Init()
+	if err := s.Remote.LastState(); err != nil {
+		s.checkErr(err)
+		return
+	}
+	if s.RetryInterval == 0 {
+		s.RetryInterval = time.Second
+	}
+
+	s.done = make(chan struct{})
+	ready := make(chan struct{})
+	s.wg.Add(1)
+	go s.follow(ready)
+	<-ready
+}
+
+// Close stops following the remote set. The local copy stays readable but will not be updated anymore.
+func (s *CachedSet) Close() {
+	if s.done == nil {
+		return
+	}
+	close(s.done)
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.done = nil
+}
+
+func (s *CachedSet) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *CachedSet) follow(ready chan struct{}) {
+	defer s.wg.Done()
+	var once sync.Once
+	synced := func() { once.Do(func() { close(ready) }) }
+	for !s.closed() {
+		s.subscribe(synced)
+		synced()
+		select {
+		case <-s.done:
+		case <-time.After(s.RetryInterval):
+		}
+	}
+}
+
+// subscribe follows the notifications until the connection fails. synced is called after each resync.
+func (s *CachedSet) subscribe(synced func()) {
+	c, err := s.Dial()
+	if err != nil {
+		s.checkErr(err)
+		return
+	}
+	s.mu.Lock()
+	if s.closed() {
+		s.mu.Unlock()
+		c.Close()
+		return
+	}
+	s.conn = c
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.Subscribe(s.Remote.SetKey); err != nil {
+		s.checkErr(err)
+		return
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Subscription:
+			if v.Kind == "subscribe" {
+				s.checkErr(s.resync())
+				synced()
+			}
+		case redis.Message:
+			if score, member, err := parseNotification(v.Data); err == nil {
+				s.local.Load().Set(s.Remote.UnMarshal(member), fromMicro(score))
+			}
+		case error:
+			if !s.closed() {
+				s.checkErr(v)
+			}
+			return
+		}
+	}
+}
+
+// resync replaces the local set with a full copy of the remote one.
+func (s *CachedSet) resync() error {
+	c, err := s.Dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	local := &Set{}
+	local.Init()
+	// ZSCAN may miss elements written during the copy, but their notifications are applied after it.
+	err = scanZSet(SingleConn(c), s.Remote.SetKey, func(m string, score int64) error {
+		local.Set(s.Remote.UnMarshal(m), fromMicro(score))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.local.Store(local)
+	return nil
+}
+
+// parseNotification splits a message published by updateToLatest into its score and member.
+func parseNotification(b []byte) (int64, string, error) {
+	i := bytes.IndexByte(b, ':')
+	if i < 0 {
+		return 0, "", errors.New("malformed notification")
+	}
+	score, err := strconv.ParseInt(string(b[:i]), 10, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return score, string(b[i+1:]), nil
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+//The write goes to the remote set first and is then applied to the local one.
+func (s *CachedSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the remote set accepted the write.
+func (s *CachedSet) Update(e interface{}, t time.Time) bool {
+	accepted := s.Remote.Update(e, t)
+	err := s.Remote.LastState()
+	s.checkErr(err)
+	if err == nil {
+		s.local.Load().Set(e, fromMicro(roundToMicro(t)))
+	}
+	return accepted
+}
+
+//SetMany works like calling Set for each element in order, using RedisSet.SetMany for the remote set.
+func (s *CachedSet) SetMany(es []TimedElement) {
+	s.Remote.SetMany(es)
+	err := s.Remote.LastState()
+	s.checkErr(err)
+	if err == nil {
+		rounded := make([]TimedElement, len(es))
+		for i, e := range es {
+			rounded[i] = TimedElement{Element: e.Element, Time: fromMicro(roundToMicro(e.Time))}
+		}
+		s.local.Load().SetMany(rounded)
+	}
+}
+
+//Len must return the number of members in the set
+func (s *CachedSet) Len() int {
+	return s.local.Load().Len()
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *CachedSet) Get(e interface{}) (time.Time, bool) {
+	return s.local.Load().Get(e)
+}
+
+//GetMany works like calling Get for each element. It reads the local set only.
+func (s *CachedSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	return s.local.Load().GetMany(es)
+}
+
+//List returns list of all elements in the set
+func (s *CachedSet) List() []interface{} {
+	return s.local.Load().List()
+}
+
+//Range calls f for each element in the local set and its timestamp. If f returns false Range stops.
+func (s *CachedSet) Range(f func(interface{}, time.Time) bool) {
+	s.local.Load().Range(f)
+}
+
+//RangeByTime returns elements of the local set with a timestamp in [from, to). If to is zero there is no upper bound.
+func (s *CachedSet) RangeByTime(from, to time.Time) []interface{} {
+	return s.local.Load().RangeByTime(from, to)
+}
@@ -0,0 +1,201 @@
+package lww
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func setupCachedSet(t testing.TB, key string) *CachedSet {
+	r := setupSet(t, key)
+	s := &CachedSet{Remote: &r, Dial: dialRedis, RetryInterval: 10 * time.Millisecond}
+	s.Init()
+	if s.LastState() != nil {
+		t.Fatal("Can't setup cached set for tests", s.LastState())
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func eventually(t testing.TB, msg string, cond func() bool) {
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+	}
+}
+
+func TestCachedSet_init(t *testing.T) {
+	s := CachedSet{}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+	if s.Len() != 0 {
+		t.Error("Local set is not usable after a failed init")
+	}
+
+	s = CachedSet{Remote: &RedisSet{}, Dial: dialRedis}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for invalid remote set")
+	}
+}
+
+// countedConn is a connection which keeps count of open connections in open.
+type countedConn struct {
+	redis.Conn
+	open *atomic.Int32
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+func TestCachedSet_initTwice(t *testing.T) {
+	r := setupSet(t, "TESTCACHEDINIT")
+	var open atomic.Int32
+	s := &CachedSet{Remote: &r, RetryInterval: 10 * time.Millisecond, Dial: func() (redis.Conn, error) {
+		c, err := dialRedis()
+		if err != nil {
+			return nil, err
+		}
+		open.Add(1)
+		return &countedConn{Conn: c, open: &open}, nil
+	}}
+	s.Init()
+	s.Init()
+	if s.LastState() != nil {
+		t.Fatal("Init failed", s.LastState())
+	}
+	if n := open.Load(); n != 1 {
+		t.Error("Init again did not stop the previous subscription", n)
+	}
+	ts := time.Now().Round(time.Microsecond)
+	r.Set("x", ts)
+	eventually(t, "Set is not following after Init again", func() bool { _, ok := s.Get("x"); return ok })
+	s.Close()
+	if n := open.Load(); n != 0 {
+		t.Error("Close did not stop the subscription", n)
+	}
+}
+
+func TestCachedSet(t *testing.T) {
+	r := setupSet(t, "TESTCACHED")
+	r.Set("before", time.Unix(100, 0))
+
+	s := &CachedSet{Remote: &r, Dial: dialRedis}
+	s.Init()
+	defer s.Close()
+	if ts, ok := s.Get("before"); !ok || !ts.Equal(time.Unix(100, 0)) {
+		t.Error("Init did not copy the remote set", ts, ok)
+	}
+
+	ts := time.Now().Round(time.Microsecond)
+	s.Set("data", ts)
+	if ts0, ok := s.Get("data"); !ok || !ts0.Equal(ts) {
+		t.Error("Set is not written through to local set", ts0, ok)
+	}
+	if ts0, ok := s.Remote.Get("data"); !ok || !ts0.Equal(ts) {
+		t.Error("Set is not written through to remote set", ts0, ok)
+	}
+	s.Set("data", time.Unix(1, 0))
+	if ts0, _ := s.Get("data"); !ts0.Equal(ts) {
+		t.Error("interface{} with older timestamp is not ignored corretly")
+	}
+	if s.Len() != 2 || len(s.List()) != 2 {
+		t.Error("Len and List are not served correctly", s.Len(), s.List())
+	}
+}
+
+func TestCachedSet_batch(t *testing.T) {
+	s := setupCachedSet(t, "TESTCACHED")
+	ts := time.Now().Round(time.Microsecond)
+	s.SetMany([]TimedElement{{"a", ts}, {"b", ts.Add(time.Second)}})
+
+	v, ok := s.GetMany([]interface{}{"a", "b", "c"})
+	if !ok[0] || !v[0].Equal(ts) || !ok[1] || !v[1].Equal(ts.Add(time.Second)) || ok[2] {
+		t.Error("SetMany is not written through to local set", v, ok)
+	}
+	if ts0, ok := s.Remote.Get("b"); !ok || !ts0.Equal(ts.Add(time.Second)) {
+		t.Error("SetMany is not written through to remote set", ts0, ok)
+	}
+}
+
+func TestCachedSet_notifications(t *testing.T) {
+	a := setupCachedSet(t, "TESTCACHED")
+	b := setupCachedSet(t, "TESTCACHED")
+
+	ts := time.Now().Round(time.Microsecond)
+	a.Set("shared", ts)
+	eventually(t, "Write from another process was not applied", func() bool {
+		ts0, ok := b.Get("shared")
+		return ok && ts0.Equal(ts)
+	})
+}
+
+func TestCachedSet_resyncOnReconnect(t *testing.T) {
+	r := setupSet(t, "TESTCACHED")
+	var mu sync.Mutex
+	var conns []redis.Conn
+	s := &CachedSet{Remote: &r, RetryInterval: 10 * time.Millisecond, Dial: func() (redis.Conn, error) {
+		c, err := dialRedis()
+		mu.Lock()
+		conns = append(conns, c)
+		mu.Unlock()
+		return c, err
+	}}
+	s.Init()
+	defer s.Close()
+
+	mu.Lock()
+	for _, c := range conns {
+		c.Close()
+	}
+	mu.Unlock()
+
+	other := RedisSet{Pool: newPool(), Marshal: r.Marshal, UnMarshal: r.UnMarshal, SetKey: "TESTCACHED"}
+	other.Init()
+	ts := time.Now().Round(time.Microsecond)
+	other.Set("missed", ts)
+	eventually(t, "Write while disconnected was not resynced", func() bool {
+		ts0, ok := s.Get("missed")
+		return ok && ts0.Equal(ts)
+	})
+}
+
+func BenchmarkCachedSet_get(b *testing.B) {
+	s := setupCachedSet(b, "TESTCACHED")
+	s.Set("test", time.Now())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Get("test")
+	}
+}
+
+func FuzzParseNotification(f *testing.F) {
+	f.Add([]byte("1500000000000000:e"), int64(1), "e")
+	f.Add([]byte("-1:a:b"), int64(-1), "a:b")
+	f.Add([]byte(":"), int64(0), "")
+	f.Add([]byte("9223372036854775808:e"), int64(-9223372036854775808), "\x00\xff")
+	f.Fuzz(func(t *testing.T, b []byte, score int64, member string) {
+		if s, m, err := parseNotification(b); err == nil {
+			s2, m2, err := parseNotification([]byte(strconv.FormatInt(s, 10) + ":" + m))
+			if err != nil || s2 != s || m2 != m {
+				t.Errorf("Notification %q parsed as %d, %q which does not parse back: %d, %q, %v", b, s, m, s2, m2, err)
+			}
+		}
+		// This is how updateToLatest publishes a notification.
+		s, m, err := parseNotification([]byte(strconv.FormatInt(score, 10) + ":" + member))
+		if err != nil || s != score || m != member {
+			t.Errorf("Notification of %d, %q parsed as %d, %q, %v", score, member, s, m, err)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package lww
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ClusterSlots is the number of hash slots in a redis cluster.
+const ClusterSlots = 16384
+
+// maxRedirections bounds how many MOVED or ASK replies a single command follows.
+const maxRedirections = 16
+
+var errNoClusterAddrs = errors.New("Addrs of Cluster must have at least one node")
+
+// HashTagKey returns a key for name which redis cluster will hash only by tag.
+// All keys made with the same tag are stored in the same slot, so scripts can use them together.
+//
+//  add := RedisSet{SetKey: HashTagKey("users", "add")}        // {users}:add
+//  remove := RedisSet{SetKey: HashTagKey("users", "remove")}  // {users}:remove
+func HashTagKey(tag, name string) string {
+	return "{" + tag + "}:" + name
+}
+
+// KeySlot returns the redis cluster hash slot of key. If key has a non empty {tag} only the tag is hashed.
+func KeySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key) % ClusterSlots)
+}
+
+// crc16 is the CRC16/XMODEM checksum redis cluster uses for key slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+/*Cluster is a ConnProvider for a redis cluster. It can be used as Pool of RedisSet and RedisLWW.
+
+Each command is sent to the node which owns the slot of its key. Owners are learned from the MOVED
+redirections of the cluster, and ASK redirections of slots being migrated are followed with ASKING.
+Connections returned by Get only support Do. Send, Flush and Receive, and so subscriptions, are not supported.
+
+Scripts of RedisLWW use both AddKey and RemoveKey, so they must be in the same slot. Use HashTagKey or Tag for that.
+*/
+type Cluster struct {
+	// Addrs are the addresses of one or more nodes of the cluster. Commands are sent to the first one until
+	// the owner of their slot is known. Init of RedisSet and RedisLWW reports an error if it is empty.
+	Addrs []string
+	// Dial opens a connection to the node at addr.
+	Dial func(addr string) (redis.Conn, error)
+	// MaxIdle is the maximum number of idle connections kept for each node.
+	MaxIdle int
+
+	mu    sync.Mutex
+	pools map[string]*redis.Pool
+	slots [ClusterSlots]string
+}
+
+//Get returns a connection which routes each command to the right node of the cluster.
+func (c *Cluster) Get() redis.Conn {
+	return &clusterConn{cluster: c}
+}
+
+func (c *Cluster) pool(addr string) *redis.Pool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pools == nil {
+		c.pools = make(map[string]*redis.Pool)
+	}
+	p, ok := c.pools[addr]
+	if !ok {
+		p = &redis.Pool{MaxIdle: c.MaxIdle, Dial: func() (redis.Conn, error) { return c.Dial(addr) }}
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// owner returns the node which owns slot, or the first of Addrs if it is not known yet. It returns false if
+// there is no node to send to.
+func (c *Cluster) owner(slot int) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slot >= 0 && c.slots[slot] != "" {
+		return c.slots[slot], true
+	}
+	if len(c.Addrs) == 0 {
+		return "", false
+	}
+	return c.Addrs[0], true
+}
+
+func (c *Cluster) moved(slot int, addr string) {
+	c.mu.Lock()
+	c.slots[slot] = addr
+	c.mu.Unlock()
+}
+
+// Close closes the connections of all nodes.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for _, p := range c.pools {
+		if e := p.Close(); e != nil {
+			err = e
+		}
+	}
+	c.pools = nil
+	return err
+}
+
+// commandKey returns the first key of a command, or false if it has none.
+func commandKey(cmd string, args []interface{}) (string, bool) {
+	switch strings.ToUpper(cmd) {
+	case "PING", "SCRIPT", "ASKING", "PUBLISH", "CLUSTER", "INFO":
+		return "", false
+	case "EVAL", "EVALSHA":
+		if len(args) < 3 {
+			return "", false
+		}
+		if n, err := strconv.Atoi(fmt.Sprint(args[1])); err != nil || n < 1 {
+			return "", false
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return "", false
+	}
+	return fmt.Sprint(args[0]), true
+}
+
+// redirection parses MOVED and ASK errors, which look like "MOVED 3999 127.0.0.1:6381".
+func redirection(err error) (kind string, slot int, addr string, ok bool) {
+	e, isRedis := err.(redis.Error)
+	if !isRedis {
+		return "", 0, "", false
+	}
+	f := strings.Fields(string(e))
+	if len(f) != 3 || (f[0] != "MOVED" && f[0] != "ASK") {
+		return "", 0, "", false
+	}
+	slot, convErr := strconv.Atoi(f[1])
+	if convErr != nil {
+		return "", 0, "", false
+	}
+	return f[0], slot, f[2], true
+}
+
+type clusterConn struct {
+	cluster *Cluster
+}
+
+func (c *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return nil, nil
+	}
+	slot := -1
+	if key, ok := commandKey(cmd, args); ok {
+		slot = KeySlot(key)
+	}
+	addr, ok := c.cluster.owner(slot)
+	if !ok {
+		return nil, errNoClusterAddrs
+	}
+	asking := false
+	for i := 0; i < maxRedirections; i++ {
+		reply, err := c.do(addr, asking, cmd, args)
+		kind, s, to, ok := redirection(err)
+		if !ok {
+			return reply, err
+		}
+		if kind == "MOVED" {
+			c.cluster.moved(s, to)
+		}
+		addr, asking = to, kind == "ASK"
+	}
+	return nil, errors.New("too many cluster redirections")
+}
+
+func (c *clusterConn) do(addr string, asking bool, cmd string, args []interface{}) (interface{}, error) {
+	conn := c.cluster.pool(addr).Get()
+	defer conn.Close()
+	if asking {
+		if _, err := conn.Do("ASKING"); err != nil {
+			return nil, err
+		}
+	}
+	return conn.Do(cmd, args...)
+}
+
+var errClusterPipeline = errors.New("cluster connections do not support pipelining")
+
+func (c *clusterConn) Send(string, ...interface{}) error { return errClusterPipeline }
+func (c *clusterConn) Flush() error                      { return errClusterPipeline }
+func (c *clusterConn) Receive() (interface{}, error)     { return nil, errClusterPipeline }
+func (c *clusterConn) Err() error                        { return nil }
+func (c *clusterConn) Close() error                      { return nil }
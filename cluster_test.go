@@ -0,0 +1,187 @@
+package lww
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// clusterStandIn fakes a redis cluster of several nodes on top of the single redis server used by tests.
+// Each node owns a range of slots and answers MOVED for keys of other nodes. A slot which is being
+// migrated is answered by ASK from its owner and only served by the importing node after ASKING.
+type clusterStandIn struct {
+	mu        sync.Mutex
+	owners    map[string][2]int
+	migrating map[int]string
+	served    map[string]int
+	redirects int
+}
+
+func newClusterStandIn() *clusterStandIn {
+	return &clusterStandIn{
+		owners:    map[string][2]int{"node1": {0, 5461}, "node2": {5461, 10922}, "node3": {10922, ClusterSlots}},
+		migrating: make(map[int]string),
+		served:    make(map[string]int),
+	}
+}
+
+func (cs *clusterStandIn) dial(addr string) (redis.Conn, error) {
+	c, err := dialRedis()
+	return &standInNode{Conn: c, addr: addr, cs: cs}, err
+}
+
+func (cs *clusterStandIn) owner(slot int) string {
+	for addr, r := range cs.owners {
+		if slot >= r[0] && slot < r[1] {
+			return addr
+		}
+	}
+	return ""
+}
+
+type standInNode struct {
+	redis.Conn
+	addr   string
+	cs     *clusterStandIn
+	asking bool
+}
+
+func (n *standInNode) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "ASKING" {
+		n.asking = true
+		return "OK", nil
+	}
+	asking := n.asking
+	n.asking = false
+	if key, ok := commandKey(cmd, args); ok {
+		slot := KeySlot(key)
+		n.cs.mu.Lock()
+		owner, importer := n.cs.owner(slot), n.cs.migrating[slot]
+		switch {
+		case n.addr == owner && importer != "":
+			n.cs.redirects++
+			n.cs.mu.Unlock()
+			return nil, redis.Error(fmt.Sprintf("ASK %d %s", slot, importer))
+		case n.addr != owner && !(n.addr == importer && asking):
+			n.cs.redirects++
+			n.cs.mu.Unlock()
+			return nil, redis.Error(fmt.Sprintf("MOVED %d %s", slot, owner))
+		}
+		n.cs.served[n.addr]++
+		n.cs.mu.Unlock()
+	}
+	return n.Conn.Do(cmd, args...)
+}
+
+func TestKeySlot(t *testing.T) {
+	for key, slot := range map[string]int{"123456789": 12739, "foo": 12182, "bar": 5061, "{user1000}.following": KeySlot("user1000")} {
+		if KeySlot(key) != slot {
+			t.Error("Wrong slot for key", key, KeySlot(key), slot)
+		}
+	}
+	if KeySlot(HashTagKey("users", "add")) != KeySlot(HashTagKey("users", "remove")) {
+		t.Error("Hash tagged keys are not in the same slot")
+	}
+	if HashTagKey("users", "add") != "{users}:add" {
+		t.Error("Wrong hash tagged key", HashTagKey("users", "add"))
+	}
+	if KeySlot("foo{}{bar}") != int(crc16("foo{}{bar}")%ClusterSlots) {
+		t.Error("Empty hash tag must be ignored")
+	}
+}
+
+func TestCluster_RedisLWW(t *testing.T) {
+	cs := newClusterStandIn()
+	cluster := &Cluster{Addrs: []string{"node1"}, Dial: cs.dial}
+	defer cluster.Close()
+
+	l := RedisLWW{Pool: cluster, AddKey: "TESTCLUSTERADD", RemoveKey: "TESTCLUSTERREMOVE", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	l.Init()
+	if l.LastState() == nil {
+		t.Error("No error for keys in different slots")
+	}
+
+	l = RedisLWW{Pool: cluster, Tag: "TESTCLUSTER", Marshal: l.Marshal, UnMarshal: l.UnMarshal}
+	l.Init()
+	if l.LastState() != nil || l.AddKey != "{TESTCLUSTER}:add" || l.RemoveKey != "{TESTCLUSTER}:remove" {
+		t.Fatal("Keys are not generated from Tag", l.AddKey, l.RemoveKey, l.LastState())
+	}
+	c := cluster.Get()
+	c.Do("DEL", l.AddKey)
+	c.Do("DEL", l.RemoveKey)
+
+	ts := time.Now()
+	l.Add("John", ts)
+	l.Add("Frank", ts)
+	l.Remove("Frank", ts.Add(time.Second))
+	if !l.Exists("John") || l.Exists("Frank") {
+		t.Error("RedisLWW over cluster is not working", l.LastState())
+	}
+	if a := l.Get(); len(a) != 1 || a[0] != "John" {
+		t.Error("Get over cluster is not working", a, l.LastState())
+	}
+
+	owner := cs.owner(KeySlot(l.AddKey))
+	if cs.redirects > 1 || cs.served[owner] == 0 {
+		t.Error("Owner of slot was not learned from MOVED", cs.redirects, cs.served)
+	}
+}
+
+func TestCluster_noAddrs(t *testing.T) {
+	cluster := &Cluster{Dial: newClusterStandIn().dial}
+	s := RedisSet{Pool: cluster, SetKey: "TESTCLUSTER", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	if s.LastState() != errNoClusterAddrs {
+		t.Error("No error for a cluster without Addrs", s.LastState())
+	}
+	l := RedisLWW{Pool: cluster, Tag: "TESTCLUSTER", Marshal: s.Marshal, UnMarshal: s.UnMarshal}
+	l.Init()
+	if l.LastState() != errNoClusterAddrs {
+		t.Error("No error for a cluster without Addrs", l.LastState())
+	}
+	if _, err := cluster.Get().Do("PING"); err != errNoClusterAddrs {
+		t.Error("Command without a node did not fail", err)
+	}
+}
+
+func TestCluster_ask(t *testing.T) {
+	cs := newClusterStandIn()
+	cluster := &Cluster{Addrs: []string{"node1", "node2"}, Dial: cs.dial}
+	defer cluster.Close()
+	key := HashTagKey("TESTCLUSTER", "ask")
+	slot := KeySlot(key)
+	importer := "node1"
+	if cs.owner(slot) == importer {
+		importer = "node2"
+	}
+	cs.migrating[slot] = importer
+
+	s := RedisSet{Pool: cluster, SetKey: key, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	c := cluster.Get()
+	if _, err := c.Do("DEL", key); err != nil {
+		t.Fatal("Can't setup redis for tests", err)
+	}
+
+	s.Set("a", time.Now())
+	s.Set("b", time.Now())
+	l := s.List()
+	sort.Slice(l, func(i, j int) bool { return l[i].(string) < l[j].(string) })
+	if len(l) != 2 || l[0] != "a" || l[1] != "b" || s.LastState() != nil {
+		t.Error("RedisSet over a migrating slot is not working", l, s.LastState())
+	}
+	if cs.served[importer] == 0 || cs.served[cs.owner(slot)] != 0 {
+		t.Error("ASK redirections were not followed with ASKING", cs.served)
+	}
+	if addr, _ := cluster.owner(slot); addr != cs.owner(slot) {
+		t.Error("ASK must not change the owner of a slot", addr)
+	}
+
+	if err := c.Send("PING"); err == nil {
+		t.Error("Pipelining must not be supported by cluster connections")
+	}
+}
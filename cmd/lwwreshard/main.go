@@ -0,0 +1,51 @@
+/*
+Command lwwreshard moves a lww.ShardedRedisSet to a different number of shards.
+
+It is an offline tool. Stop the writers of the set, run it, and point the application to the new key:
+
+  lwwreshard -addr localhost:6379 -from users -from-shards 16 -to users-v2 -to-shards 64
+
+Members are copied with their timestamps. Keys of the old set are left in place.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/kavehmz/lww"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:6379", "address of the redis server")
+	fromKey := flag.String("from", "", "SetKey of the current set")
+	fromShards := flag.Int("from-shards", 0, "number of shards of the current set")
+	toKey := flag.String("to", "", "SetKey of the new set")
+	toShards := flag.Int("to-shards", 0, "number of shards of the new set")
+	flag.Parse()
+
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", *addr) }}
+	defer pool.Close()
+
+	raw := func(e interface{}) string { return e.(string) }
+	unraw := func(m string) interface{} { return m }
+	from := lww.ShardedRedisSet{Pool: pool, SetKey: *fromKey, Shards: *fromShards, Marshal: raw, UnMarshal: unraw}
+	to := lww.ShardedRedisSet{Pool: pool, SetKey: *toKey, Shards: *toShards, Marshal: raw, UnMarshal: unraw}
+	from.Init()
+	to.Init()
+	for _, err := range []error{from.LastState(), to.LastState()} {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "lwwreshard:", err)
+			os.Exit(2)
+		}
+	}
+
+	n, err := lww.Reshard(&from, &to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "lwwreshard:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("copied %d members from %d to %d shards\n", n, *fromShards, *toShards)
+}
@@ -0,0 +1,46 @@
+package lww
+
+import "time"
+
+// ExistsAt returns true if the element existed at t, judged by the adds and removes with a timestamp up to t.
+// AddSet and RemoveSet need to implement HistorySet for an exact answer about any past moment. For other sets
+// only the greatest timestamps are known, so it is exact only if the element was not written after t.
+//
+// An element added by AddWithTTL does not exist at or after its deadline. ExpireSet only keeps the greatest deadline,
+// so if the element was added again with a ttl after t, that later deadline is used.
+func (lww *LWW) ExistsAt(e interface{}, t time.Time) bool {
+	a, aok := getAt(lww.AddSet, e, t)
+	r, rok := getAt(lww.RemoveSet, e, t)
+	if !aok || (rok && a.UnixNano() <= r.UnixNano()) {
+		return false
+	}
+	d, ok := lww.deadline(e)
+	return !ok || !isExpired(a, d, t)
+}
+
+// GetAt returns slice of elements that "Exist" at t, like Get would have returned at t.
+// It visits all elements of AddSet, including those which were added only after t.
+// Elements are collected before they are checked, so the sets are not read while AddSet is ranged over.
+func (lww *LWW) GetAt(t time.Time) []interface{} {
+	var es []interface{}
+	rangeSet(lww.AddSet, func(e interface{}, _ time.Time) bool {
+		es = append(es, e)
+		return true
+	})
+	var l []interface{}
+	for _, e := range es {
+		if lww.ExistsAt(e, t) {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// getAt uses GetAt of s if it implements HistorySet. Otherwise it uses Get and reports no timestamp if it is after t.
+func getAt(s TimedSet, e interface{}, t time.Time) (time.Time, bool) {
+	if h, ok := s.(HistorySet); ok {
+		return h.GetAt(e, t)
+	}
+	val, ok := s.Get(e)
+	return val, ok && val.UnixNano() <= t.UnixNano()
+}
@@ -0,0 +1,171 @@
+package lww
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedHistorySet holds a lock while the f of Range runs and while GetAt runs, like a set of a user may do.
+type lockedHistorySet struct {
+	TimedSet
+	mu *sync.Mutex
+}
+
+func (s lockedHistorySet) Range(f func(interface{}, time.Time) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TimedSet.(Ranger).Range(f)
+}
+
+func (s lockedHistorySet) GetAt(e interface{}, t time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TimedSet.(HistorySet).GetAt(e, t)
+}
+
+func TestSet_GetAt(t *testing.T) {
+	s := Set{History: true}
+	s.Init()
+	ts := time.Now()
+	s.Set("x", ts)
+	s.Set("x", ts.Add(2*time.Second))
+	s.Set("x", ts.Add(time.Second))
+	s.SetFrom("x", ts.Add(3*time.Second), "a")
+	s.SetMany([]TimedElement{{"x", ts.Add(4 * time.Second)}})
+	for d, want := range map[time.Duration]time.Duration{
+		time.Second / 2:                 0,
+		time.Second:                     time.Second,
+		time.Second + time.Second/2:     time.Second,
+		2*time.Second + time.Nanosecond: 2 * time.Second,
+		3 * time.Second:                 3 * time.Second,
+		time.Hour:                       4 * time.Second,
+	} {
+		if got, ok := s.GetAt("x", ts.Add(d)); !ok || !got.Equal(ts.Add(want)) {
+			t.Error("GetAt is not correct", d, got, ok)
+		}
+	}
+	if _, ok := s.GetAt("x", ts.Add(-time.Nanosecond)); ok {
+		t.Error("GetAt returned a timestamp before the first one")
+	}
+	if _, ok := s.GetAt("y", ts.Add(time.Hour)); ok {
+		t.Error("GetAt returned a timestamp of a missing element")
+	}
+	if got, _ := s.Get("x"); !got.Equal(ts.Add(4 * time.Second)) {
+		t.Error("History changed what Get returns", got)
+	}
+
+	// Without History only the current timestamp is known.
+	s = Set{}
+	s.Init()
+	s.Set("x", ts)
+	s.Set("x", ts.Add(time.Second))
+	if _, ok := s.GetAt("x", ts); ok {
+		t.Error("GetAt without History returned a timestamp after t")
+	}
+	if got, ok := s.GetAt("x", ts.Add(time.Hour)); !ok || !got.Equal(ts.Add(time.Second)) {
+		t.Error("GetAt without History did not return the current timestamp", got, ok)
+	}
+}
+
+func TestSet_retention(t *testing.T) {
+	s := Set{History: true, Retention: time.Hour}
+	s.Init()
+	now := time.Now()
+	for _, d := range []time.Duration{-4 * time.Hour, -3 * time.Hour, -2 * time.Hour, -time.Minute, time.Minute} {
+		s.Set("x", now.Add(d))
+	}
+	if l := len(s.history["x"]); l != 3 {
+		t.Error("Retention did not drop old timestamps", s.history["x"])
+	}
+	// The newest timestamp before the window is kept, so moments in the window are still exact.
+	if got, ok := s.GetAt("x", now.Add(-30*time.Minute)); !ok || !got.Equal(now.Add(-2*time.Hour)) {
+		t.Error("GetAt in the retention window is not correct", got, ok)
+	}
+}
+
+func TestSet_retentionNotWritten(t *testing.T) {
+	s := Set{History: true, Retention: 20 * time.Millisecond}
+	s.Init()
+	now := time.Now()
+	s.Set("x", now)
+	s.Set("x", now.Add(time.Millisecond))
+	time.Sleep(30 * time.Millisecond)
+	s.Set("y", time.Now())
+	if h, ok := s.history["x"]; ok {
+		t.Error("History of an element which was not written again was kept", h)
+	}
+	if got, ok := s.GetAt("x", now.Add(time.Second)); !ok || !got.Equal(now.Add(time.Millisecond)) {
+		t.Error("GetAt without history did not return the current timestamp", got, ok)
+	}
+	if _, ok := s.history["y"]; !ok {
+		t.Error("History in the retention window was dropped")
+	}
+}
+
+func TestLWW_ExistsAt(t *testing.T) {
+	l := LWW{AddSet: &Set{History: true}, RemoveSet: &Set{History: true}}
+	l.Init()
+	ts := time.Now()
+	l.Add("x", ts)
+	l.Remove("x", ts.Add(time.Second))
+	l.Add("x", ts.Add(2*time.Second))
+	l.Remove("y", ts.Add(2*time.Second))
+	l.Add("y", ts.Add(time.Second))
+	l.AddWithTTL("z", ts, time.Second)
+	for d, want := range map[time.Duration]string{
+		-time.Second:                    "",
+		0:                               "x z",
+		time.Second / 2:                 "x z",
+		time.Second:                     "y",
+		2*time.Second - time.Nanosecond: "y",
+		2 * time.Second:                 "x",
+		time.Hour:                       "x",
+	} {
+		got := ""
+		for _, e := range []string{"x", "y", "z"} {
+			if l.ExistsAt(e, ts.Add(d)) {
+				got += " " + e
+			}
+		}
+		if got != " "+want && !(want == "" && got == "") {
+			t.Errorf("Elements at %v are %q, want %q", d, got, want)
+		}
+		if n := len(l.GetAt(ts.Add(d))); n != len(got)/2 {
+			t.Error("GetAt does not match ExistsAt", d, l.GetAt(ts.Add(d)))
+		}
+	}
+	if l.ExistsAt("x", ts.Add(time.Hour)) != l.Exists("x") {
+		t.Error("ExistsAt now does not match Exists")
+	}
+
+	// GetAt does not read the sets while AddSet is ranged over.
+	l = LWW{AddSet: lockedHistorySet{&Set{History: true}, &sync.Mutex{}}}
+	l.Init()
+	l.Add("x", ts)
+	if g := l.GetAt(ts); len(g) != 1 {
+		t.Error("GetAt over a set which locks in Range is not correct", g)
+	}
+
+	// Sets without history answer from their current timestamps.
+	l = LWW{AddSet: listOnly{&Set{}}}
+	l.Init()
+	l.Add("x", ts)
+	l.Remove("x", ts.Add(time.Second))
+	if !l.ExistsAt("x", ts) || l.ExistsAt("x", ts.Add(time.Second)) {
+		t.Error("ExistsAt without history is not correct for moments after the last write")
+	}
+}
+
+func BenchmarkSet_GetAt(b *testing.B) {
+	s := Set{History: true}
+	s.Init()
+	ts := time.Now()
+	for i := 0; i < 1000; i++ {
+		s.Set("x", ts.Add(time.Duration(i)*time.Second))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetAt("x", ts.Add(time.Duration(i%1000)*time.Second))
+	}
+}
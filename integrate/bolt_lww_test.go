@@ -0,0 +1,52 @@
+package integrate
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kavehmz/lww"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltSet_integration(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "lww.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open bolt for tests", err)
+	}
+	defer db.Close()
+	add := lww.BoltSet{DB: db, Bucket: "add", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	remove := lww.BoltSet{DB: db, Bucket: "remove", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+
+	IntegrationTest(&add, &remove, t)
+}
+
+// newBoltSets returns a factory of BoltSets which each use a new bucket of db.
+func newBoltSets(db *bolt.DB) func() lww.TimedSet {
+	n := 0
+	return func() lww.TimedSet {
+		n++
+		return &lww.BoltSet{DB: db, Bucket: "conformance" + strconv.Itoa(n), Marshal: Marshal, UnMarshal: UnMarshal}
+	}
+}
+
+func openBolt(t testing.TB) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "lww.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open bolt for tests", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBoltSet_conformance(t *testing.T) {
+	ConformanceTest(t, newBoltSets(openBolt(t)), 0)
+}
+
+func TestBoltSet_crdt(t *testing.T) {
+	CRDTTest(t, newBoltSets(openBolt(t)), CRDTConfig{Histories: 10})
+}
+
+func BenchmarkBoltSet_conformance(b *testing.B) {
+	ConformanceBenchmark(b, newBoltSets(openBolt(b)))
+}
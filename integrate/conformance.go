@@ -0,0 +1,274 @@
+package integrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kavehmz/lww"
+)
+
+// Person is a struct element type the conformance suite stores next to strings and ints.
+type Person struct {
+	Name string
+	Age  int
+}
+
+// Marshal encodes the element types the conformance suite uses: strings, ints and Person.
+// Sets which need a Marshal function can use it, together with UnMarshal, to run ConformanceTest.
+func Marshal(e interface{}) string {
+	switch v := e.(type) {
+	case string:
+		return "s:" + v
+	case int:
+		return "i:" + strconv.Itoa(v)
+	case Person:
+		// Name is last and kept as it is, so any bytes survive, unlike with JSON which replaces invalid UTF-8.
+		return "p:" + strconv.Itoa(v.Age) + ":" + v.Name
+	}
+	panic(fmt.Sprintf("integrate: can't marshal %T", e))
+}
+
+// UnMarshal decodes an element which Marshal encoded.
+func UnMarshal(s string) interface{} {
+	kind, v, _ := strings.Cut(s, ":")
+	switch kind {
+	case "i":
+		n, _ := strconv.Atoi(v)
+		return n
+	case "p":
+		age, name, _ := strings.Cut(v, ":")
+		n, _ := strconv.Atoi(age)
+		return Person{Name: name, Age: n}
+	}
+	return v
+}
+
+// within reports if got is less than precision away from want. A zero precision means they must be equal.
+func within(got, want time.Time, precision time.Duration) bool {
+	if precision == 0 {
+		return got.Equal(want)
+	}
+	d := got.Sub(want)
+	return d > -precision && d < precision
+}
+
+// ConformanceTest runs the conformance suite for a TimedSet as subtests of t.
+// newSet must return a new empty set for each call, which the suite will Init. Sets which keep
+// their members in a store must not share them between calls, for example by using a new key or table.
+// Elements are strings, ints and Person values, so sets which marshal elements should use Marshal and UnMarshal.
+// precision is how far a timestamp read back may be from the one written, like time.Microsecond for RedisSet.
+func ConformanceTest(t *testing.T, newSet func() lww.TimedSet, precision time.Duration) {
+	set := func() lww.TimedSet {
+		s := newSet()
+		s.Init()
+		return s
+	}
+	// step separates timestamps far enough to be ordered after they are stored.
+	step := max(precision, time.Millisecond)
+
+	t.Run("integration", func(t *testing.T) {
+		IntegrationTest(set(), set(), t)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		s := set()
+		if s.Len() != 0 || len(s.List()) != 0 {
+			t.Errorf("New set is not empty, Len %d, List %v", s.Len(), s.List())
+		}
+		if _, ok := s.Get("e"); ok {
+			t.Error("New set claims to contain an element")
+		}
+	})
+
+	t.Run("older timestamps are ignored", func(t *testing.T) {
+		s := set()
+		ts := time.Now()
+		s.Set("e", ts)
+		s.Set("e", ts.Add(-step))
+		if got, _ := s.Get("e"); !within(got, ts, precision) {
+			t.Errorf("An older timestamp replaced a newer one, got %v want %v", got, ts)
+		}
+		s.Set("e", ts)
+		if got, _ := s.Get("e"); !within(got, ts, precision) {
+			t.Errorf("Setting the same timestamp again changed it, got %v want %v", got, ts)
+		}
+		s.Set("e", ts.Add(step))
+		if got, _ := s.Get("e"); !within(got, ts.Add(step), precision) {
+			t.Errorf("A newer timestamp did not replace an older one, got %v want %v", got, ts.Add(step))
+		}
+		if s.Len() != 1 {
+			t.Errorf("Setting one element many times gave Len %d", s.Len())
+		}
+	})
+
+	t.Run("len and list are consistent", func(t *testing.T) {
+		s := set()
+		ts := time.Now()
+		for i := 0; i < 100; i++ {
+			s.Set("e"+strconv.Itoa(i%40), ts.Add(time.Duration(i)*step))
+			l := s.List()
+			if len(l) != s.Len() {
+				t.Fatalf("Len is %d and List has %d elements", s.Len(), len(l))
+			}
+		}
+		seen := make(map[interface{}]bool)
+		for _, e := range s.List() {
+			if seen[e] {
+				t.Errorf("List has %v more than once", e)
+			}
+			seen[e] = true
+			if _, ok := s.Get(e); !ok {
+				t.Errorf("List has %v which Get can't find", e)
+			}
+		}
+		if len(seen) != 40 {
+			t.Errorf("List has %d distinct elements, want 40", len(seen))
+		}
+	})
+
+	t.Run("complex elements", func(t *testing.T) {
+		s := set()
+		ts := time.Now()
+		es := []interface{}{"", "with space", "with:colon", "ünïcode", 0, -1, 42, Person{}, Person{Name: "Ana", Age: 30}, Person{Name: "Ana", Age: 31}}
+		for i, e := range es {
+			s.Set(e, ts.Add(time.Duration(i)*step))
+		}
+		if s.Len() != len(es) {
+			t.Errorf("Len is %d, want %d", s.Len(), len(es))
+		}
+		for i, e := range es {
+			got, ok := s.Get(e)
+			if !ok || !within(got, ts.Add(time.Duration(i)*step), precision) {
+				t.Errorf("Get(%#v) = %v, %v, want %v", e, got, ok, ts.Add(time.Duration(i)*step))
+			}
+		}
+		listed := make(map[interface{}]bool)
+		for _, e := range s.List() {
+			listed[e] = true
+		}
+		for _, e := range es {
+			if !listed[e] {
+				t.Errorf("List does not have %#v or has it with another type", e)
+			}
+		}
+	})
+
+	t.Run("concurrent writers", func(t *testing.T) {
+		s := set()
+		ts := time.Now()
+		const writers, elements = 8, 20
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < elements; i++ {
+					// Writers interleave, so the newest timestamp of an element may come first.
+					s.Set(i, ts.Add(time.Duration((w+i)%writers)*step))
+					s.Get(i)
+				}
+			}(w)
+		}
+		wg.Wait()
+		if s.Len() != elements || len(s.List()) != elements {
+			t.Errorf("Len is %d and List has %d elements, want %d", s.Len(), len(s.List()), elements)
+		}
+		want := ts.Add((writers - 1) * step)
+		for i := 0; i < elements; i++ {
+			if got, _ := s.Get(i); !within(got, want, precision) {
+				t.Errorf("Element %d has %v, want the newest timestamp %v", i, got, want)
+			}
+		}
+	})
+
+	t.Run("precision round trip", func(t *testing.T) {
+		s := set()
+		now := time.Now()
+		ts := []time.Time{
+			now,
+			now.Truncate(time.Second),
+			now.Truncate(time.Second).Add(time.Nanosecond),
+			now.Truncate(time.Second).Add(999 * time.Nanosecond),
+			now.Truncate(time.Second).Add(500 * time.Nanosecond),
+			time.Unix(0, 0),
+			time.Unix(1, 1),
+			time.Date(2200, 1, 1, 0, 0, 0, 123456789, time.UTC),
+		}
+		for i, tt := range ts {
+			s.Set(i, tt)
+		}
+		for i, tt := range ts {
+			got, ok := s.Get(i)
+			if !ok || !within(got, tt, precision) {
+				t.Errorf("Timestamp %v did not round trip, got %v, %v", tt, got, ok)
+			}
+		}
+		// A timestamp one precision step newer must win even after rounding.
+		s.Set(0, now.Add(step))
+		if got, _ := s.Get(0); !within(got, now.Add(step), precision) {
+			t.Errorf("A timestamp newer by %v was ignored, got %v", step, got)
+		}
+	})
+}
+
+// ConformanceBenchmark runs benchmarks of the common operations of a TimedSet as sub-benchmarks of b.
+// newSet is called like in ConformanceTest.
+func ConformanceBenchmark(b *testing.B, newSet func() lww.TimedSet) {
+	set := func() lww.TimedSet {
+		s := newSet()
+		s.Init()
+		return s
+	}
+	ts := time.Now()
+
+	b.Run("Set/new elements", func(b *testing.B) {
+		s := set()
+		for i := 0; i < b.N; i++ {
+			s.Set(i, ts)
+		}
+	})
+
+	b.Run("Set/same element", func(b *testing.B) {
+		s := set()
+		for i := 0; i < b.N; i++ {
+			s.Set("e", ts.Add(time.Duration(i)*time.Microsecond))
+		}
+	})
+
+	b.Run("Set/parallel", func(b *testing.B) {
+		s := set()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				s.Set(i%1000, ts.Add(time.Duration(i)*time.Microsecond))
+				i++
+			}
+		})
+	})
+
+	b.Run("Get", func(b *testing.B) {
+		s := set()
+		for i := 0; i < 1000; i++ {
+			s.Set(i, ts)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.Get(i % 1000)
+		}
+	})
+
+	b.Run("List", func(b *testing.B) {
+		s := set()
+		for i := 0; i < 1000; i++ {
+			s.Set(i, ts)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.List()
+		}
+	})
+}
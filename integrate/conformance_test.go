@@ -0,0 +1,64 @@
+package integrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kavehmz/lww"
+)
+
+func TestSet_conformance(t *testing.T) {
+	ConformanceTest(t, func() lww.TimedSet { return &lww.Set{} }, 0)
+}
+
+func TestShardedSet_conformance(t *testing.T) {
+	ConformanceTest(t, func() lww.TimedSet { return &lww.ShardedSet{} }, 0)
+}
+
+func TestAtomicSet_conformance(t *testing.T) {
+	ConformanceTest(t, func() lww.TimedSet { return &lww.AtomicSet{} }, 0)
+}
+
+func TestMarshal(t *testing.T) {
+	for _, e := range []interface{}{"", "i:1", 0, -7, Person{}, Person{Name: "p:x", Age: 1}} {
+		if got := UnMarshal(Marshal(e)); got != e {
+			t.Errorf("UnMarshal(Marshal(%#v)) = %#v", e, got)
+		}
+	}
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add("s:x", 1, "Ana")
+	f.Add("", -1, "\xff:")
+	f.Fuzz(func(t *testing.T, s string, n int, name string) {
+		for _, e := range []interface{}{s, n, Person{Name: name, Age: n}} {
+			if got := UnMarshal(Marshal(e)); got != e {
+				t.Errorf("UnMarshal(Marshal(%#v)) = %#v", e, got)
+			}
+		}
+		// Malformed input must not panic.
+		UnMarshal(s)
+	})
+}
+
+func TestWithin(t *testing.T) {
+	ts := time.Now()
+	if !within(ts, ts, 0) || within(ts.Add(1), ts, 0) {
+		t.Error("A zero precision must compare timestamps exactly")
+	}
+	if !within(ts.Add(999), ts, time.Microsecond) || within(ts.Add(-time.Microsecond), ts, time.Microsecond) {
+		t.Error("within must accept less than precision and reject precision")
+	}
+}
+
+func BenchmarkSet_conformance(b *testing.B) {
+	ConformanceBenchmark(b, func() lww.TimedSet { return &lww.Set{} })
+}
+
+func BenchmarkShardedSet_conformance(b *testing.B) {
+	ConformanceBenchmark(b, func() lww.TimedSet { return &lww.ShardedSet{} })
+}
+
+func BenchmarkAtomicSet_conformance(b *testing.B) {
+	ConformanceBenchmark(b, func() lww.TimedSet { return &lww.AtomicSet{} })
+}
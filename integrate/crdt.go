@@ -0,0 +1,291 @@
+package integrate
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kavehmz/lww"
+)
+
+// Op is one Add or Remove of a History.
+type Op struct {
+	// Replica is the index of the replica which did the operation.
+	Replica int
+	Remove  bool
+	Element int
+	// Time is the timestamp in milliseconds after a fixed base, so sets which round timestamps keep it exactly.
+	Time int
+}
+
+func (o Op) String() string {
+	op := "add"
+	if o.Remove {
+		op = "remove"
+	}
+	return fmt.Sprintf("r%d %s e%d @%d", o.Replica, op, o.Element, o.Time)
+}
+
+// History is a list of operations in the order replicas did them.
+type History []Op
+
+func (h History) String() string {
+	l := make([]string, len(h))
+	for i, o := range h {
+		l[i] = o.String()
+	}
+	return "[" + strings.Join(l, ", ") + "]"
+}
+
+// CRDTConfig sets how CRDTTest generates histories. Zero fields use the defaults in their comments.
+type CRDTConfig struct {
+	// Replicas is the number of simulated replicas. Default 3.
+	Replicas int
+	// Ops is the maximum length of a history. Default 20.
+	Ops int
+	// Elements is the number of distinct elements. It is small so operations collide. Default 3.
+	Elements int
+	// Times is the number of distinct timestamps. It is small so equal timestamps are common. Default 8.
+	Times int
+	// Histories is the number of histories to check. Default 100.
+	Histories int
+	// Seed of the random generator. If it is zero the current time is used. It is reported on failures.
+	Seed int64
+}
+
+func (c CRDTConfig) withDefaults() CRDTConfig {
+	for _, f := range []struct {
+		v *int
+		d int
+	}{{&c.Replicas, 3}, {&c.Ops, 20}, {&c.Elements, 3}, {&c.Times, 8}, {&c.Histories, 100}} {
+		if *f.v <= 0 {
+			*f.v = f.d
+		}
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	return c
+}
+
+func (c CRDTConfig) generate(r *rand.Rand) History {
+	h := make(History, r.Intn(c.Ops+1))
+	for i := range h {
+		h[i] = Op{Replica: r.Intn(c.Replicas), Remove: r.Intn(2) == 0, Element: r.Intn(c.Elements), Time: r.Intn(c.Times)}
+	}
+	return h
+}
+
+// CRDTTest checks random histories with CheckHistory on LWWs over sets made by newSet, which is called
+// like in ConformanceTest. The first failing history is shrunk with ShrinkHistory and reported with the seed.
+func CRDTTest(t *testing.T, newSet func() lww.TimedSet, c CRDTConfig) {
+	c = c.withDefaults()
+	r := rand.New(rand.NewSource(c.Seed))
+	for i := 0; i < c.Histories; i++ {
+		h, seed := c.generate(r), r.Int63()
+		if err := CheckHistory(newSet, c.Replicas, h, seed); err != nil {
+			min := ShrinkHistory(h, func(h History) bool { return CheckHistory(newSet, c.Replicas, h, seed) != nil })
+			t.Fatalf("CRDT laws do not hold, seed %d\nhistory: %v\nminimal history: %v\n%v", c.Seed, h, min, CheckHistory(newSet, c.Replicas, min, seed))
+		}
+	}
+}
+
+// crdtBase is the timestamp of Time 0 of operations.
+var crdtBase = time.Unix(1500000000, 0)
+
+// runner runs a History on LWWs over sets made by newSet.
+type runner struct {
+	newSet   func() lww.TimedSet
+	elements int
+}
+
+func (rs runner) new() *lww.LWW {
+	l := &lww.LWW{AddSet: rs.newSet(), RemoveSet: rs.newSet()}
+	l.Init()
+	return l
+}
+
+// apply does o on l as its replica, so sets which implement ProvenanceSet keep the replica of each write.
+func (rs runner) apply(l *lww.LWW, o Op) {
+	w := *l
+	w.ReplicaID = fmt.Sprintf("r%d", o.Replica)
+	ts := crdtBase.Add(time.Duration(o.Time) * time.Millisecond)
+	if o.Remove {
+		w.Remove(o.Element, ts)
+		return
+	}
+	w.Add(o.Element, ts)
+}
+
+func (rs runner) clone(l *lww.LWW) *lww.LWW {
+	c := rs.new()
+	c.Merge(l)
+	return c
+}
+
+// diff describes how the states of a and b differ, or returns "" if they are the same.
+func (rs runner) diff(a, b *lww.LWW) string {
+	for e := 0; e < rs.elements; e++ {
+		sa, sb := a.State(e), b.State(e)
+		if sa.Exists != sb.Exists || !sa.Added.Equal(sb.Added) || !sa.Removed.Equal(sb.Removed) || sa.AddedBy != sb.AddedBy || sa.RemovedBy != sb.RemovedBy {
+			return fmt.Sprintf("e%d is %+v and %+v", e, sa, sb)
+		}
+	}
+	return ""
+}
+
+// CheckHistory checks the laws of a CRDT for h on LWWs over sets made by newSet. It returns an error
+// which describes the first law that does not hold, or nil. The laws are:
+//
+//   - Applying the operations in any delivery order, even more than once, gives the state of applying them in order.
+//   - Replicas which do their own operations and merge each other at random points converge to the same state.
+//   - Merge is commutative, associative and idempotent for the states of the replicas.
+//
+// seed decides delivery orders, interleavings and which replicas are merged, so a failing check can be repeated.
+func CheckHistory(newSet func() lww.TimedSet, replicas int, h History, seed int64) error {
+	r := rand.New(rand.NewSource(seed))
+	rs := runner{newSet: newSet}
+	for _, o := range h {
+		rs.elements = max(rs.elements, o.Element+1)
+	}
+
+	want := rs.new()
+	for _, o := range h {
+		rs.apply(want, o)
+	}
+
+	for i := 0; i < 4; i++ {
+		l := rs.new()
+		order := make(History, 0, len(h))
+		for _, j := range r.Perm(len(h)) {
+			order = append(order, h[j])
+		}
+		for j := 0; j < len(h)/4; j++ {
+			order = append(order, h[r.Intn(len(h))])
+		}
+		for _, o := range order {
+			rs.apply(l, o)
+		}
+		if d := rs.diff(l, want); d != "" {
+			return fmt.Errorf("delivery order %v does not converge: %s", order, d)
+		}
+	}
+
+	local := make([]History, replicas)
+	for _, o := range h {
+		local[o.Replica] = append(local[o.Replica], o)
+	}
+	states := make([]*lww.LWW, replicas)
+	for i := range states {
+		states[i] = rs.new()
+		for _, o := range local[i] {
+			rs.apply(states[i], o)
+		}
+	}
+
+	reps := make([]*lww.LWW, replicas)
+	next := make([]int, replicas)
+	var trace []string
+	for i := range reps {
+		reps[i] = rs.new()
+	}
+	for pending := len(h); pending > 0; {
+		if i, j := r.Intn(replicas), r.Intn(replicas); r.Intn(3) == 0 {
+			reps[i].Merge(reps[j])
+			trace = append(trace, fmt.Sprintf("r%d merges r%d", i, j))
+			continue
+		}
+		i := r.Intn(replicas)
+		if next[i] == len(local[i]) {
+			continue
+		}
+		rs.apply(reps[i], local[i][next[i]])
+		trace = append(trace, local[i][next[i]].String())
+		next[i]++
+		pending--
+	}
+	for i := range reps {
+		for j := range reps {
+			reps[i].Merge(reps[j])
+		}
+		if d := rs.diff(reps[i], want); d != "" {
+			return fmt.Errorf("r%d does not converge after %s: %s", i, strings.Join(trace, ", "), d)
+		}
+	}
+
+	i, j, k := r.Intn(replicas), r.Intn(replicas), r.Intn(replicas)
+	ab, ba := rs.clone(states[i]), rs.clone(states[j])
+	ab.Merge(states[j])
+	ba.Merge(states[i])
+	if d := rs.diff(ab, ba); d != "" {
+		return fmt.Errorf("merge of r%d and r%d is not commutative: %s", i, j, d)
+	}
+
+	left, bc := rs.clone(states[i]), rs.clone(states[j])
+	left.Merge(states[j])
+	left.Merge(states[k])
+	bc.Merge(states[k])
+	right := rs.clone(states[i])
+	right.Merge(bc)
+	if d := rs.diff(left, right); d != "" {
+		return fmt.Errorf("merge of r%d, r%d and r%d is not associative: %s", i, j, k, d)
+	}
+
+	aa := rs.clone(states[i])
+	aa.Merge(states[i])
+	aa.Merge(aa)
+	if d := rs.diff(aa, states[i]); d != "" {
+		return fmt.Errorf("merge of r%d is not idempotent: %s", i, d)
+	}
+	return nil
+}
+
+// ShrinkHistory returns a minimal history for which fails still returns true, starting from h for which it does.
+// It removes operations, first in big chunks and then one by one, and then moves the fields of each operation
+// towards replica 0, element 0, time 0 and add, until no change keeps the failure.
+func ShrinkHistory(h History, fails func(History) bool) History {
+	for changed := true; changed; {
+		changed = false
+		for n := max(len(h)/2, 1); n >= 1 && len(h) > 0; n /= 2 {
+			for i := 0; i+n <= len(h); {
+				c := append(append(History{}, h[:i]...), h[i+n:]...)
+				if fails(c) {
+					h, changed = c, true
+					continue
+				}
+				i += n
+			}
+		}
+		for i := range h {
+			for _, o := range simpler(h[i]) {
+				c := append(History{}, h...)
+				c[i] = o
+				if fails(c) {
+					h, changed = c, true
+					break
+				}
+			}
+		}
+	}
+	return h
+}
+
+// simpler returns operations which differ from o in one field and are closer to the zero Op.
+func simpler(o Op) []Op {
+	var l []Op
+	if o.Replica > 0 {
+		l = append(l, Op{0, o.Remove, o.Element, o.Time})
+	}
+	if o.Remove {
+		l = append(l, Op{o.Replica, false, o.Element, o.Time})
+	}
+	if o.Element > 0 {
+		l = append(l, Op{o.Replica, o.Remove, 0, o.Time})
+	}
+	if o.Time > 0 {
+		l = append(l, Op{o.Replica, o.Remove, o.Element, 0}, Op{o.Replica, o.Remove, o.Element, o.Time / 2}, Op{o.Replica, o.Remove, o.Element, o.Time - 1})
+	}
+	return l
+}
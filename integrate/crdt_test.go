@@ -0,0 +1,85 @@
+package integrate
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/kavehmz/lww"
+)
+
+func TestSet_crdt(t *testing.T) {
+	CRDTTest(t, func() lww.TimedSet { return &lww.Set{} }, CRDTConfig{})
+}
+
+func TestShardedSet_crdt(t *testing.T) {
+	CRDTTest(t, func() lww.TimedSet { return &lww.ShardedSet{} }, CRDTConfig{})
+}
+
+func TestAtomicSet_crdt(t *testing.T) {
+	CRDTTest(t, func() lww.TimedSet { return &lww.AtomicSet{} }, CRDTConfig{})
+}
+
+// overwriteSet is a broken TimedSet which keeps the last timestamp written instead of the greatest one.
+type overwriteSet struct {
+	members map[interface{}]time.Time
+}
+
+func (s *overwriteSet) Init()                          { s.members = make(map[interface{}]time.Time) }
+func (s *overwriteSet) Len() int                       { return len(s.members) }
+func (s *overwriteSet) Set(e interface{}, t time.Time) { s.members[e] = t }
+
+func (s *overwriteSet) Get(e interface{}) (time.Time, bool) {
+	t, ok := s.members[e]
+	return t, ok
+}
+
+func (s *overwriteSet) List() []interface{} {
+	l := make([]interface{}, 0, len(s.members))
+	for e := range s.members {
+		l = append(l, e)
+	}
+	return l
+}
+
+func TestCheckHistory_broken(t *testing.T) {
+	newSet := func() lww.TimedSet { return &overwriteSet{} }
+	h := History{{Replica: 2, Element: 1, Time: 7}, {Replica: 1, Remove: true, Element: 2, Time: 3}, {Replica: 0, Element: 1, Time: 5}, {Replica: 1, Element: 0, Time: 4}}
+	if CheckHistory(newSet, 3, h, 1) == nil {
+		t.Fatal("CheckHistory did not catch a set which does not keep the greatest timestamp")
+	}
+	min := ShrinkHistory(h, func(h History) bool { return CheckHistory(newSet, 3, h, 1) != nil })
+	if len(min) != 2 || CheckHistory(newSet, 3, min, 1) == nil {
+		t.Error("History is not shrunk to a minimal counterexample", min)
+	}
+	if min[0].Element != min[1].Element || min[0].Remove || min[1].Remove || min[0].Replica != 0 || min[1].Replica != 0 {
+		t.Error("Operations of the counterexample are not simplified", min)
+	}
+}
+
+func TestShrinkHistory(t *testing.T) {
+	h := History{{Replica: 1, Time: 3}, {Element: 2, Remove: true}, {Replica: 2, Element: 1, Time: 6}}
+	// Fails while there is a remove and an operation at time 5 or later.
+	fails := func(h History) bool {
+		var remove, late bool
+		for _, o := range h {
+			remove = remove || o.Remove
+			late = late || o.Time >= 5
+		}
+		return remove && late
+	}
+	min := ShrinkHistory(h, fails)
+	want := History{{Remove: true}, {Time: 5}}
+	if min.String() != want.String() {
+		t.Errorf("ShrinkHistory gave %v, want %v", min, want)
+	}
+}
+
+func BenchmarkCheckHistory(b *testing.B) {
+	c := CRDTConfig{Seed: 1}.withDefaults()
+	h := c.generate(rand.New(rand.NewSource(c.Seed)))
+	newSet := func() lww.TimedSet { return &lww.Set{} }
+	for i := 0; i < b.N; i++ {
+		CheckHistory(newSet, c.Replicas, h, int64(i))
+	}
+}
@@ -4,6 +4,24 @@ It has a function IntegrationTest that can be used by underlying sets which
 implentent TimedSet to see if they are implementing a correct behaviour.
 
 You need to create a test and pass your set to IntegrationTest as shown in the example.
+
+ConformanceTest is a fuller suite which takes a function returning new sets. It runs as subtests and checks
+that older timestamps are ignored, Len and List agree, elements of different types round trip, concurrent
+writers keep the newest timestamp and timestamps keep the precision the set promises. Run it with -race to
+check the set is race free. ConformanceBenchmark runs benchmarks of the same sets.
+
+	func TestMySet_conformance(t *testing.T) {
+		integrate.ConformanceTest(t, func() lww.TimedSet { return &MySet{Marshal: integrate.Marshal, UnMarshal: integrate.UnMarshal} }, 0)
+	}
+
+CRDTTest is a property based checker of LWW over a set. It generates random histories of adds and removes
+on a number of replicas, applies them in random delivery orders and interleaves them with LWW.Merge, and checks
+that all replicas converge and that Merge is commutative, associative and idempotent. A failing history is shrunk
+to a minimal counterexample, which CheckHistory can run again with the reported seed.
+
+	func TestMySet_crdt(t *testing.T) {
+		integrate.CRDTTest(t, newMySets, integrate.CRDTConfig{})
+	}
 */
 package integrate
 
@@ -1,41 +1,99 @@
 package integrate
 
 import (
+	"fmt"
+	"os"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/kavehmz/lww"
+	"github.com/kavehmz/lww/redistest"
 )
 
+// redisServer is the in-process redis server all tests of the package use.
+var redisServer *redistest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	if redisServer, err = redistest.NewServer(); err != nil {
+		fmt.Println("Can't start redis for tests", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	redisServer.Close()
+	os.Exit(code)
+}
+
 func setupSet(t interface {
 	Error(...interface{})
-}, r *redis.Conn, key string) lww.RedisSet {
-	c, _ := redis.Dial("tcp", "localhost:6379")
+}, p *redis.Pool, key string) lww.RedisSet {
+	c := p.Get()
+	defer c.Close()
 	_, err := c.Do("DEL", key)
-	r = &c
 	if err != nil {
 		t.Error("Can't setup redis for tests", err)
 	}
-	s := lww.RedisSet{Conn: *r, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: key}
+	s := lww.RedisSet{Pool: p, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: key}
 	s.Init()
 	return s
 }
 
+func newPool() *redis.Pool {
+	return &redis.Pool{Dial: redisServer.Dial}
+}
+
 func TestRedisSet_integration(t *testing.T) {
-	var ac redis.Conn
-	var rc redis.Conn
-	add := setupSet(t, &ac, "TESTADD")
-	remove := setupSet(t, &rc, "TESTREMOVE")
+	p := newPool()
+	add := setupSet(t, p, "TESTADD")
+	remove := setupSet(t, p, "TESTREMOVE")
 
 	IntegrationTest(&add, &remove, t)
 }
 
+// newRedisSets returns a factory of RedisSets which each use a new key.
+func newRedisSets(t interface {
+	Error(...interface{})
+}, p *redis.Pool, prefix string, nanoseconds bool) func() lww.TimedSet {
+	n := 0
+	return func() lww.TimedSet {
+		n++
+		s := setupSet(t, p, prefix+strconv.Itoa(n))
+		s.Marshal, s.UnMarshal, s.Nanoseconds = Marshal, UnMarshal, nanoseconds
+		c := p.Get()
+		c.Do("DEL", s.SetKey+":ns")
+		c.Close()
+		return &s
+	}
+}
+
+func TestRedisSet_conformance(t *testing.T) {
+	p := newPool()
+	ConformanceTest(t, newRedisSets(t, p, "TESTCONFORMANCE", false), time.Microsecond)
+}
+
+func TestRedisSet_conformanceNanoseconds(t *testing.T) {
+	p := newPool()
+	ConformanceTest(t, newRedisSets(t, p, "TESTCONFORMANCENS", true), 0)
+}
+
+func TestRedisSet_crdt(t *testing.T) {
+	p := newPool()
+	CRDTTest(t, newRedisSets(t, p, "TESTCRDT", false), CRDTConfig{Histories: 20})
+	CRDTTest(t, newRedisSets(t, p, "TESTCRDTNS", true), CRDTConfig{Histories: 20})
+}
+
+func BenchmarkRedisSet_conformance(b *testing.B) {
+	p := newPool()
+	ConformanceBenchmark(b, newRedisSets(b, p, "BENCHCONFORMANCE", false))
+}
+
 func Example() {
-	var ac redis.Conn
-	var rc redis.Conn
+	p := newPool()
 	var t testing.T
-	add := setupSet(&t, &ac, "TESTADD")
-	remove := setupSet(&t, &rc, "TESTREMOVE")
+	add := setupSet(&t, p, "TESTADD")
+	remove := setupSet(&t, p, "TESTREMOVE")
 
 	IntegrationTest(&add, &remove, &t)
 }
@@ -0,0 +1,55 @@
+package integrate
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kavehmz/lww"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLSet_integration(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lww.db"))
+	if err != nil {
+		t.Fatal("Can't open sqlite for tests", err)
+	}
+	defer db.Close()
+	add := lww.SQLSet{DB: db, Table: "lww_add", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	remove := lww.SQLSet{DB: db, Table: "lww_remove", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+
+	IntegrationTest(&add, &remove, t)
+}
+
+// newSQLSets returns a factory of SQLSets which each use a new table of db.
+func newSQLSets(db *sql.DB) func() lww.TimedSet {
+	n := 0
+	return func() lww.TimedSet {
+		n++
+		return &lww.SQLSet{DB: db, Table: "conformance" + strconv.Itoa(n), Marshal: Marshal, UnMarshal: UnMarshal}
+	}
+}
+
+func openSQLite(t testing.TB) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lww.db"))
+	if err != nil {
+		t.Fatal("Can't open sqlite for tests", err)
+	}
+	// SQLite allows one writer, so concurrent writers share one connection.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLSet_conformance(t *testing.T) {
+	ConformanceTest(t, newSQLSets(openSQLite(t)), 0)
+}
+
+func TestSQLSet_crdt(t *testing.T) {
+	CRDTTest(t, newSQLSets(openSQLite(t)), CRDTConfig{Histories: 10})
+}
+
+func BenchmarkSQLSet_conformance(b *testing.B) {
+	ConformanceBenchmark(b, newSQLSets(openSQLite(b)))
+}
@@ -13,7 +13,7 @@ LWW
 
 lww package implements LWW data structure in a modular way. It defines a TimedSet interface for underlying storage.
 
-lww package includes two storage underlying.
+lww package includes several storage underlyings.
 
 Set
 
@@ -28,14 +28,201 @@ Set is the default underlying for LWW if no other TimedSet are attached to AddSe
 
 Maps are by nature vulnerable to concurrent access. To avoid race problems Set uses a sync.RWMutex as its locking mechanism.
 
+ShardedSet
+
+ShardedSet is a Set split into a number of independently locked maps. Elements are spread across shards by their hash.
+
+It is a better fit for write heavy solutions where many goroutines would otherwise contend on the single lock of Set.
+
+  lww := LWW{AddSet: &ShardedSet{Shards: 64}, RemoveSet: &ShardedSet{}}
+
+AtomicSet
+
+AtomicSet never takes a lock. It keeps each timestamp in an atomic integer inside a sync.Map and
+moves it forward with compare-and-swap. It is meant for read mostly hot paths.
+
 RedisSet
 
 RedisSet is another implementation of TimedSet included in lww package. It uses Redis Sorted Sets to store data.
 
 Redis nature of atomic operations makes it immune to race problem and there is no need to any extra lock mechanism. But it introduces other complexities.
 
-To keep the lww simple, handling of Redis connection for both AddSet and RemoveSet in case of RedisSet is passed to client.
-It is practical as Redis setup can vary based on application and client might want handle complex connection handling.
+RedisSet borrows a connection for each operation from its Pool, so it can be shared between goroutines.
+A *redis.Pool can be used directly. SingleConn wraps a single connection for applications which want to handle connections themselves.
+
+  pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") }}
+  add  := RedisSet{Pool: pool, SetKey: "add", Marshal: m, UnMarshal: u}
+
+ZSET scores are float64, so RedisSet rounds timestamps to microseconds and two writes in the same microsecond collide.
+With Nanoseconds set it also keeps exact timestamps in a hash next to the ZSET and compares those instead.
+
+  add := RedisSet{Pool: pool, SetKey: "add", Nanoseconds: true, Marshal: m, UnMarshal: u}
+
+RedisLWW
+
+LWW over two RedisSets needs a round trip to redis for each set it touches, and other writers can interleave between them.
+RedisLWW keeps both sets under known keys and runs Add, Remove, Exists and Get each as a single Lua script.
+Every operation is then one atomic round trip.
+
+  l := RedisLWW{Pool: pool, AddKey: "add", RemoveKey: "remove", Marshal: m, UnMarshal: u}
+
+Redis Cluster
+
+Cluster is a ConnProvider which sends each command to the node owning the slot of its key and follows MOVED and ASK redirections.
+Scripts which touch both sets need their keys in the same slot. HashTagKey makes {tag} style keys for that, and RedisLWW does it by itself if Tag is set.
+
+  cluster := &Cluster{Addrs: []string{"10.0.0.1:7000"}, Dial: func(addr string) (redis.Conn, error) { return redis.Dial("tcp", addr) }}
+  l       := RedisLWW{Pool: cluster, Tag: "users", Marshal: m, UnMarshal: u}
+
+ShardedRedisSet
+
+ShardedRedisSet spreads one logical set across a number of ZSETs by the hash of each element. Len, List and Get aggregate the shards.
+It avoids a single huge key, and in a cluster the shards can live on different nodes.
+Reshard, and the lwwreshard command built on it, move a set offline to a different number of shards.
+
+BoltSet
+
+BoltSet stores the set in a bbolt database, a pure Go embedded key/value store. It is persistent without running any server.
+
+Add and remove sets can share one database file by using different buckets.
+
+  db, _  := bolt.Open("lww.db", 0600, nil)
+  add    := BoltSet{DB: db, Bucket: "add", Marshal: m, UnMarshal: u}
+  remove := BoltSet{DB: db, Bucket: "remove", Marshal: m, UnMarshal: u}
+
+SQLSet
+
+SQLSet stores the set in a database/sql table. It is useful for applications which already have a PostgreSQL or SQLite database.
+
+Init creates the table if it does not exist. Each Set is a single upsert which keeps the greater timestamp.
+
+  add    := SQLSet{DB: db, Table: "lww_add", Marshal: m, UnMarshal: u}
+  remove := SQLSet{DB: db, Table: "lww_remove", Marshal: m, UnMarshal: u}
+
+CachedSet
+
+CachedSet mixes Set and RedisSet. It serves reads from a local Set and writes through to a RedisSet.
+It is as fast as internal maps for reads but persistent and sharable through a redis server.
+
+The local copy is kept fresh by subscribing to the notifications RedisSet publishes for each accepted write,
+and by a full resync whenever the subscription is (re)established.
+
+  remote := RedisSet{Pool: pool, SetKey: "add", Marshal: m, UnMarshal: u}
+  add    := CachedSet{Remote: &remote, Dial: func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") }}
+
+Iteration
+
+List of TimedSet loads every element in one slice. Underlyings which also implement Ranger can stream their elements instead,
+and LWW.Range uses that to visit existing elements without loading both sets in memory.
+All underlyings in this package implement Ranger. RedisSet uses ZSCAN, so it does not block redis on big sets,
+but elements written during the iteration may be missed or visited twice. LWW.Get still uses List, which does not.
+
+  l.Range(func(e interface{}) bool {
+  	fmt.Println(e)
+  	return true
+  })
+
+GetWithTimestamps works like Get but also returns when each element was last added.
+State returns both timestamps of a single element and whether it exists, for replication layers or UIs
+which need to know why an element is or is not in the set.
+
+  st := l.State("x")
+  fmt.Println(st.Added, st.Removed, st.Exists)
+
+Expiry
+
+AddWithTTL adds an element which stops to exist after a timeout unless it is added again, for example for online users or leases.
+Expiry is decided at read time from the deadlines kept in ExpireSet, so no background writer is needed.
+Sweep, or a sweeper started by StartSweeper, can turn expired elements into removes at their deadline.
+Init sets ExpireSet to a lww.Set if AddSet and RemoveSet keep their elements in memory. If they are RedisSets,
+ExpireSet must be set to one too, so all replicas see the same deadlines.
+
+  l := lww.LWW{}
+  l.Init()
+  l.AddWithTTL("user-1", time.Now(), time.Minute)
+  stop := l.StartSweeper(time.Minute)
+  defer stop()
+
+Replicas
+
+If ReplicaID is set, sets which implement ProvenanceSet keep which replica wrote each timestamp, and State returns it
+as AddedBy and RemovedBy. It also breaks ties inside a set: of two writes with equal timestamps the greater ReplicaID wins,
+so all replicas agree on the writer. Set, ShardedSet and RedisSet with Provenance implement it.
+
+  l := lww.LWW{ReplicaID: "node-1"}
+
+Time range queries
+
+Underlyings which implement TimeRanger can return elements changed in a time range without a full scan.
+Set and ShardedSet keep a time index which is built on the first query and kept up to date by writes,
+RedisSet uses ZRANGEBYSCORE and SQLSet uses an index on the ts column.
+LWW.ChangedSince uses them to return elements added or removed at or after a time, for example to sync only recent changes.
+Other underlyings fall back to a full scan.
+
+  changed := l.ChangedSince(lastSync)
+
+Batch operations
+
+AddMany, RemoveMany and ExistsMany handle many elements at once. Underlyings which implement BatchSet do it natively:
+Set and ShardedSet take each lock once, BoltSet and SQLSet use a single transaction and RedisSet sends up to
+1000 elements in each script call instead of one round trip for each element. Other underlyings fall back to a loop.
+
+  l.AddMany([]lww.TimedElement{{Element: "a", Time: time.Now()}, {Element: "b", Time: time.Now()}})
+  exists := l.ExistsMany([]interface{}{"a", "b"})
+
+Merging replicas
+
+Replicas can also exchange whole states. Merge applies every timestamp of another LWW, keeping the greater one for each element.
+It is commutative, associative and idempotent, so replicas which merge each other in any order end up in the same state.
+The integrate package has a property based checker of these laws for any TimedSet, and the sim package
+runs replicas over a simulated network with latency, drops and partitions to test replication code.
+
+  a.Merge(&b)
+
+Metrics
+
+Instrument wraps the sets of LWW in InstrumentedSets, which report the latency of each operation, writes ignored
+because they were stale and errors to a Metrics. Sets which implement Updater, and ProvenanceUpdater if ReplicaID
+is set, tell if a write was accepted; all underlyings in this package do. Package lwwprom exports these measurements to Prometheus.
+
+  l := lww.LWW{AddSet: add, RemoveSet: remove}
+  l.Instrument(m)
+  l.Init()
+
+Tracing
+
+Trace wraps the sets of LWW in TracedSets, which start a span named after the operation for each call to a Tracer.
+Spans have the set name, its key, a hash of the element and the outcome, so stale writes can be told apart.
+Tracer and Span have the shape of OpenTelemetry, which an adapter of a few lines can plug in.
+TracedLWW takes a context in each method, so its spans and those of the sets are children of the caller's span.
+
+  l := lww.LWW{AddSet: add, RemoveSet: remove}
+  l.Trace(tracer)
+  l.Init()
+  tl := lww.TracedLWW{LWW: &l, Tracer: tracer}
+  tl.Add(ctx, "a", time.Now())
+
+Audit log
+
+AuditedLWW appends every Add and Remove to an AuditSink, with the element, its timestamp, the replica and whether
+the set accepted it as the newest, so writes which lost the race are kept too. FileSink, RedisStreamSink and ChanSink
+keep the log in a file, a redis stream or a channel. Replay reads it back from an AuditSource into a fresh LWW.
+
+  a := lww.AuditedLWW{LWW: &l, Sink: &lww.FileSink{W: f, Marshal: marshal}}
+  a.Add("a", time.Now())
+  n, err := lww.Replay(&lww.FileSource{R: f, UnMarshal: unmarshal}, &fresh)
+
+Point in time queries
+
+Set and RedisSet keep every timestamp of each element, not only the greatest one, if History is set.
+RedisSet keeps them in a ZSET per element next to the set, written by the same script as the set.
+Retention bounds how far back they go, also for elements which are not written again: Set trims all histories
+from time to time and RedisSet lets the key of an element expire once it was not written for Retention.
+ExistsAt and GetAt then tell which elements existed at any past moment within it.
+
+  l := lww.LWW{AddSet: &lww.Set{History: true, Retention: 30 * 24 * time.Hour}, RemoveSet: &lww.Set{History: true}}
+  l.Init()
+  wasThere := l.ExistsAt("a", lastTuesday)
 
 Adding New underlying
 
@@ -49,12 +236,13 @@ Assuming you do that and they work as expected you can initialize LWW like:
 
 Note that in theory AddSet and RemoveSet can have different underlying attached.
 This might be useful in applications which can predict higher magnitude of Adds compared to Removes. In that case application can implementation different types of TimedSet to optimize the setup
-
-There is also a an underlying implementation that mixes two Map and Redis implementations. It is available at https://github.com/kavehmz/qset. That implementation is more practical as it will be as fast as internal maps but persistent and sharable through a redis server.
 */
 package lww
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 // TimedSet interface defines what is required for an underlying set for WWL.
 type TimedSet interface {
@@ -70,59 +258,330 @@ type TimedSet interface {
 	List() []interface{}
 }
 
+// Ranger is an optional interface for an underlying set which can iterate over its elements
+// without loading all of them in memory. LWW.Range uses it if AddSet implements it.
+type Ranger interface {
+	//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+	Range(f func(interface{}, time.Time) bool)
+}
+
+// TimeRanger is an optional interface for an underlying set which can find elements by their timestamp
+// without visiting all of them. LWW.ChangedSince uses it if the sets implement it.
+type TimeRanger interface {
+	//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+	RangeByTime(from, to time.Time) []interface{}
+}
+
+// TimedElement is an element with the timestamp of its action. Batch operations take slices of it.
+type TimedElement struct {
+	Element interface{}
+	Time    time.Time
+}
+
+// BatchSet is an optional interface for an underlying set which can handle many elements at once
+// cheaper than one by one, for example in a single lock hold or a single round trip.
+// LWW.AddMany, LWW.RemoveMany and LWW.ExistsMany use it if the sets implement it.
+type BatchSet interface {
+	//SetMany works like calling Set for each element in order.
+	SetMany([]TimedElement)
+	//GetMany works like calling Get for each element. Results are in the same order as the elements.
+	GetMany([]interface{}) ([]time.Time, []bool)
+}
+
+// ProvenanceSet is an optional interface for an underlying set which can keep which replica wrote
+// the timestamp of each element. LWW uses it if ReplicaID is set.
+type ProvenanceSet interface {
+	//SetFrom works like Set and keeps replica as the writer of the timestamp.
+	//For equal timestamps the greater replica wins, so all replicas agree on the writer whatever the order of writes is.
+	SetFrom(e interface{}, t time.Time, replica string)
+	//GetFrom works like Get and also returns the replica which wrote the timestamp, or "" if it is not known.
+	GetFrom(e interface{}) (time.Time, string, bool)
+}
+
+// Updater is an optional interface for an underlying set which can tell if a write was accepted.
+// InstrumentedSet uses it to count stale writes.
+type Updater interface {
+	//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t.
+	Update(e interface{}, t time.Time) bool
+}
+
+// ProvenanceUpdater is an optional interface for a ProvenanceSet which can tell if a write through SetFrom was accepted.
+// InstrumentedSet, TracedSet and AuditedLWW use it if ReplicaID is set.
+type ProvenanceUpdater interface {
+	//UpdateFrom works like SetFrom and returns true if the write was accepted, false if the set already had a newer
+	//timestamp, or the same one from a replica which is not less.
+	UpdateFrom(e interface{}, t time.Time, replica string) bool
+}
+
+// HistorySet is an optional interface for an underlying set which keeps past timestamps of elements,
+// not only the greatest one. LWW.ExistsAt and LWW.GetAt use it to answer for past moments.
+type HistorySet interface {
+	//GetAt returns the greatest timestamp of the element which is not after t and true, or false if there is none.
+	GetAt(e interface{}, t time.Time) (time.Time, bool)
+}
+
 // LWW type a Last-Writer-Wins (LWW) Element Set data structure.
 type LWW struct {
 	// AddSet will store the state of elements added to the set. By default it is will be of type lww.Set.
 	AddSet TimedSet
 	// AddSet will store the state of elements removed from the set. By default it is will be of type lww.Set
 	RemoveSet TimedSet
+	// ReplicaID identifies this node. If it is set, sets which implement ProvenanceSet keep it as the writer of each timestamp.
+	ReplicaID string
+	// ExpireSet will store the deadlines of elements added by AddWithTTL. Init sets it to a lww.Set if it is nil and
+	// AddSet and RemoveSet are a Set, ShardedSet or AtomicSet. Otherwise it must be shared between replicas like them,
+	// so it is left nil. If it is nil no element expires, Exists and State do not look up deadlines
+	// and AddWithTTL fails.
+	ExpireSet TimedSet
+	// Now returns the current time to decide if an element is expired. By default it is time.Now.
+	Now func() time.Time
+	swept atomic.Value
+	lastState
 }
 
 // Init will initialize the underlying sets required for LWW.
 // Internally it works on two sets named "add" and "remove".
 func (lww *LWW) Init() {
-	if lww.AddSet == nil {
-		lww.AddSet = &Set{}
-	}
-	if lww.RemoveSet == nil {
-		lww.RemoveSet = &Set{}
-	}
+	lww.setDefaults()
 	lww.AddSet.Init()
 	lww.RemoveSet.Init()
+	if lww.ExpireSet != nil {
+		lww.ExpireSet.Init()
+	}
 }
 
 // Add will add an element to the add-set if it does not exists and updates its timestamp to
 // great one between current one and new one.
 func (lww *LWW) Add(e interface{}, t time.Time) {
-	lww.AddSet.Set(e, t)
+	lww.set(lww.AddSet, e, t)
 }
 
 // Remove will add an element to the remove-set if it does not exists and updates its timestamp to
 // great one between current one and new one.
 func (lww *LWW) Remove(e interface{}, t time.Time) {
-	if val, ok := lww.RemoveSet.Get(e); !ok || t.UnixNano() > val.UnixNano() {
-		lww.RemoveSet.Set(e, t)
+	lww.set(lww.RemoveSet, e, t)
+}
+
+// set uses SetFrom of s if ReplicaID is set and s implements ProvenanceSet. Otherwise it uses Set.
+func (lww *LWW) set(s TimedSet, e interface{}, t time.Time) {
+	if p, ok := s.(ProvenanceSet); ok && lww.ReplicaID != "" {
+		p.SetFrom(e, t, lww.ReplicaID)
+		return
 	}
+	s.Set(e, t)
+}
+
+// get uses GetFrom of s if it implements ProvenanceSet. Otherwise it uses Get and the replica is "".
+func get(s TimedSet, e interface{}) (time.Time, string, bool) {
+	if p, ok := s.(ProvenanceSet); ok {
+		return p.GetFrom(e)
+	}
+	t, ok := s.Get(e)
+	return t, "", ok
+}
+
+// ElementState is the state of one element in LWW, as returned by LWW.State.
+type ElementState struct {
+	// Added is the timestamp of the latest add. It is zero if the element was never added.
+	Added time.Time
+	// Removed is the timestamp of the latest remove. It is zero if the element was never removed.
+	Removed time.Time
+	// AddedBy is the replica which wrote Added, or "" if it is not known.
+	AddedBy string
+	// RemovedBy is the replica which wrote Removed, or "" if it is not known.
+	RemovedBy string
+	// Expires is the deadline of the latest add if it was added by AddWithTTL. Otherwise it is zero.
+	Expires time.Time
+	// Exists is the effective status of the element, the same as LWW.Exists returns.
+	Exists bool
 }
 
 // Exists returns true if element has a more recent record in add-set than in remove-set
+//
+// An element added by AddWithTTL does not exist anymore after its deadline.
 func (lww *LWW) Exists(e interface{}) bool {
 	a, aok := lww.AddSet.Get(e)
 	r, rok := lww.RemoveSet.Get(e)
-	if !rok {
-		return aok
+	return aok && (!rok || a.UnixNano() > r.UnixNano()) && !lww.expired(e, a)
+}
+
+// State returns the add and remove timestamps of an element and whether it exists.
+//
+// Between the add-set and the remove-set equal timestamps are still resolved in favour of remove,
+// whichever replicas wrote them.
+func (lww *LWW) State(e interface{}) ElementState {
+	a, aby, aok := get(lww.AddSet, e)
+	r, rby, rok := get(lww.RemoveSet, e)
+	st := ElementState{Exists: aok && (!rok || a.UnixNano() > r.UnixNano())}
+	if aok {
+		st.Added, st.AddedBy = a, aby
+		if d, ok := lww.deadline(e); ok && a.UnixNano() < d.UnixNano() {
+			st.Expires = d
+			st.Exists = st.Exists && !isExpired(a, d, lww.now())
+		}
+	}
+	if rok {
+		st.Removed, st.RemovedBy = r, rby
+	}
+	return st
+}
+
+// AddMany works like calling Add for each element, but uses a single batch if AddSet implements BatchSet.
+func (lww *LWW) AddMany(es []TimedElement) {
+	lww.setMany(lww.AddSet, es)
+}
+
+// RemoveMany works like calling Remove for each element, but uses a single batch if RemoveSet implements BatchSet.
+func (lww *LWW) RemoveMany(es []TimedElement) {
+	lww.setMany(lww.RemoveSet, es)
+}
+
+// ExistsMany works like calling Exists for each element. Results are in the same order as the elements.
+// It uses a single batch for each set which implements BatchSet.
+func (lww *LWW) ExistsMany(es []interface{}) []bool {
+	_, l := lww.existsMany(es)
+	return l
+}
+
+// existsMany works like ExistsMany and also returns the timestamp of the latest add of each element.
+func (lww *LWW) existsMany(es []interface{}) ([]time.Time, []bool) {
+	a, aok := getMany(lww.AddSet, es)
+	r, rok := getMany(lww.RemoveSet, es)
+	var d []time.Time
+	var dok []bool
+	if lww.ExpireSet != nil {
+		d, dok = getMany(lww.ExpireSet, es)
+	}
+	now := lww.now()
+	l := make([]bool, len(es))
+	for i := range es {
+		l[i] = aok[i] && (!rok[i] || a[i].UnixNano() > r[i].UnixNano()) && !(dok != nil && dok[i] && isExpired(a[i], d[i], now))
+	}
+	return a, l
+}
+
+// setMany uses SetMany of s if it implements BatchSet. Otherwise it calls Set for each element.
+// If ReplicaID is set and s implements ProvenanceSet it calls SetFrom for each element instead.
+func (lww *LWW) setMany(s TimedSet, es []TimedElement) {
+	if _, ok := s.(ProvenanceSet); ok && lww.ReplicaID != "" {
+		for _, e := range es {
+			lww.set(s, e.Element, e.Time)
+		}
+		return
+	}
+	setBatch(s, es)
+}
+
+// setBatch uses SetMany of s if it implements BatchSet. Otherwise it calls Set for each element.
+func setBatch(s TimedSet, es []TimedElement) {
+	if b, ok := s.(BatchSet); ok {
+		b.SetMany(es)
+		return
+	}
+	for _, e := range es {
+		s.Set(e.Element, e.Time)
+	}
+}
+
+// getMany uses GetMany of s if it implements BatchSet. Otherwise it calls Get for each element.
+func getMany(s TimedSet, es []interface{}) ([]time.Time, []bool) {
+	if b, ok := s.(BatchSet); ok {
+		return b.GetMany(es)
+	}
+	ts, oks := make([]time.Time, len(es)), make([]bool, len(es))
+	for i, e := range es {
+		ts[i], oks[i] = s.Get(e)
 	}
-	return a.UnixNano() > r.UnixNano()
+	return ts, oks
 }
 
 // Get returns slice of elements that "Exist".
+// It reads the elements of AddSet with List, so each of them is returned once even while the sets are written.
 func (lww *LWW) Get() []interface{} {
-
-	l := make([]interface{}, 0, lww.AddSet.Len())
-	for _, e := range lww.AddSet.List() {
-		if lww.Exists(e) {
+	es := lww.AddSet.List()
+	_, ok := lww.existsMany(es)
+	l := make([]interface{}, 0, len(es))
+	for i, e := range es {
+		if ok[i] {
 			l = append(l, e)
 		}
 	}
 	return l
 }
+
+// GetWithTimestamps returns elements that "Exist", each with the timestamp of its latest add.
+// Like Get it reads the elements of AddSet with List.
+func (lww *LWW) GetWithTimestamps() []TimedElement {
+	es := lww.AddSet.List()
+	a, ok := lww.existsMany(es)
+	l := make([]TimedElement, 0, len(es))
+	for i, e := range es {
+		if ok[i] {
+			l = append(l, TimedElement{Element: e, Time: a[i]})
+		}
+	}
+	return l
+}
+
+// Range calls f for each element that "Exist". If f returns false Range stops.
+// Elements are streamed from AddSet if it implements Ranger, so they are not all loaded in memory.
+// It only guarantees what Range of AddSet does for elements written during the iteration.
+// RedisSet may miss them or visit them twice, so use Get if that matters.
+// Underlyings in this package do not hold a lock while f runs, so f can read and write the LWW.
+// An underlying of your own which holds a lock while it calls the f of its Range must document that f can't.
+func (lww *LWW) Range(f func(interface{}) bool) {
+	rangeSet(lww.AddSet, func(e interface{}, a time.Time) bool {
+		if r, ok := lww.RemoveSet.Get(e); ok && a.UnixNano() <= r.UnixNano() {
+			return true
+		}
+		if lww.expired(e, a) {
+			return true
+		}
+		return f(e)
+	})
+}
+
+// rangeSet uses Range of s if it implements Ranger. Otherwise it falls back to List and Get.
+func rangeSet(s TimedSet, f func(interface{}, time.Time) bool) {
+	if r, ok := s.(Ranger); ok {
+		r.Range(f)
+		return
+	}
+	for _, e := range s.List() {
+		if t, ok := s.Get(e); ok && !f(e, t) {
+			return
+		}
+	}
+}
+
+// ChangedSince returns elements which were added or removed at or after t, whatever their state is now.
+// Including t itself makes it safe for incremental sync, as applying an element twice is harmless.
+// Elements must be usable as a hash key to remove duplicates between add-set and remove-set.
+func (lww *LWW) ChangedSince(t time.Time) []interface{} {
+	var l []interface{}
+	seen := make(map[interface{}]bool)
+	for _, s := range []TimedSet{lww.AddSet, lww.RemoveSet} {
+		for _, e := range rangeByTime(s, t, time.Time{}) {
+			if !seen[e] {
+				seen[e] = true
+				l = append(l, e)
+			}
+		}
+	}
+	return l
+}
+
+// rangeByTime uses RangeByTime of s if it implements TimeRanger. Otherwise it visits all elements of s.
+func rangeByTime(s TimedSet, from, to time.Time) []interface{} {
+	if r, ok := s.(TimeRanger); ok {
+		return r.RangeByTime(from, to)
+	}
+	var l []interface{}
+	rangeSet(s, func(e interface{}, t time.Time) bool {
+		if t.UnixNano() >= from.UnixNano() && (to.IsZero() || t.UnixNano() < to.UnixNano()) {
+			l = append(l, e)
+		}
+		return true
+	})
+	return l
+}
@@ -2,6 +2,10 @@ package lww
 
 import (
 	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -68,6 +72,182 @@ func TestLWW_Get(t *testing.T) {
 
 }
 
+// listOnly hides Range of a Set to test the fallback of LWW to List and Get.
+type listOnly struct {
+	TimedSet
+}
+
+func TestLWW_RangeCallsBack(t *testing.T) {
+	// f can use the LWW while a writer waits for the lock of the set.
+	r := setupSet(t, "TESTRANGECALLBACK")
+	cached := &CachedSet{Remote: &r, Dial: dialRedis, RetryInterval: 10 * time.Millisecond}
+	t.Cleanup(cached.Close)
+	for _, l := range []LWW{{}, {AddSet: &ShardedSet{}}, {AddSet: cached}} {
+		l.Init()
+		ts := time.Now()
+		for i := 0; i < 100; i++ {
+			l.Add(strconv.Itoa(i), ts)
+		}
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 2000; i++ {
+				select {
+				case <-done:
+					return
+				default:
+					l.Add("w", ts.Add(time.Duration(i)))
+				}
+			}
+		}()
+		finished := make(chan bool)
+		go func() {
+			l.Range(func(e interface{}) bool {
+				l.Exists(e)
+				l.Remove(e, ts.Add(time.Second))
+				return true
+			})
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Range deadlocked when f used the LWW")
+		}
+		close(done)
+		if l.Exists("0") {
+			t.Error("Removes in f were lost")
+		}
+	}
+}
+
+func TestLWW_Range(t *testing.T) {
+	for _, l := range []LWW{{}, {AddSet: listOnly{&Set{}}}} {
+		l.Init()
+		ts := time.Now()
+		for i := 0; i < 10; i++ {
+			l.Add(i, ts)
+		}
+		l.Remove(3, ts.Add(time.Second))
+		l.Remove(4, ts)
+		l.Remove(5, ts.Add(-time.Second))
+
+		seen := make(map[interface{}]bool)
+		l.Range(func(e interface{}) bool {
+			seen[e] = true
+			return true
+		})
+		if len(seen) != 8 || seen[3] || seen[4] || !seen[5] {
+			t.Error("Range did not visit existing elements correctly", seen)
+		}
+		if len(l.Get()) != 8 {
+			t.Error("Get did not return existing elements correctly", l.Get())
+		}
+
+		n := 0
+		l.Range(func(e interface{}) bool {
+			n++
+			return n < 2
+		})
+		if n != 2 {
+			t.Error("Range did not stop when f returned false", n)
+		}
+	}
+}
+
+func TestLWW_State(t *testing.T) {
+	l := LWW{}
+	l.Init()
+	ts := time.Now()
+	l.Add("a", ts)
+	l.Add("b", ts)
+	l.Remove("b", ts.Add(time.Second))
+	l.Remove("c", ts)
+
+	if st := l.State("a"); !st.Exists || !st.Added.Equal(ts) || !st.Removed.IsZero() {
+		t.Error("State of an added element is not correct", st)
+	}
+	if st := l.State("b"); st.Exists || !st.Added.Equal(ts) || !st.Removed.Equal(ts.Add(time.Second)) {
+		t.Error("State of a removed element is not correct", st)
+	}
+	if st := l.State("c"); st.Exists || !st.Added.IsZero() || !st.Removed.Equal(ts) {
+		t.Error("State of an element which was only removed is not correct", st)
+	}
+	if st := l.State("d"); st != (ElementState{}) {
+		t.Error("State of an unknown element is not empty", st)
+	}
+
+	l.Add("c", ts.Add(time.Second))
+	g := l.GetWithTimestamps()
+	sort.Slice(g, func(i, j int) bool { return g[i].Element.(string) < g[j].Element.(string) })
+	if len(g) != 2 || g[0].Element != "a" || !g[0].Time.Equal(ts) || g[1].Element != "c" || !g[1].Time.Equal(ts.Add(time.Second)) {
+		t.Error("GetWithTimestamps did not return existing elements with their add timestamp", g)
+	}
+}
+
+func TestLWW_replicaID(t *testing.T) {
+	a := LWW{ReplicaID: "a"}
+	a.Init()
+	b := LWW{AddSet: a.AddSet, RemoveSet: a.RemoveSet, ReplicaID: "b"}
+	ts := time.Now()
+
+	b.Add("x", ts)
+	a.Add("x", ts)
+	if st := a.State("x"); !st.Exists || st.AddedBy != "b" {
+		t.Error("Greater replica did not win the tie of equal timestamps", st)
+	}
+	a.Add("x", ts.Add(time.Second))
+	a.RemoveMany([]TimedElement{{"x", ts.Add(time.Second)}})
+	if st := b.State("x"); st.Exists || st.AddedBy != "a" || st.RemovedBy != "a" {
+		t.Error("Provenance of the latest timestamps is not correct", st)
+	}
+
+	l := LWW{}
+	l.Init()
+	l.Add("x", ts)
+	if st := l.State("x"); st.AddedBy != "" {
+		t.Error("Replica is set without ReplicaID", st)
+	}
+}
+
+func TestLWW_ChangedSince(t *testing.T) {
+	for _, l := range []LWW{{}, {AddSet: listOnly{&Set{}}, RemoveSet: listOnly{&Set{}}}} {
+		l.Init()
+		ts := time.Unix(1000, 0)
+		l.Add("old", ts.Add(-time.Hour))
+		l.Add("added", ts)
+		l.Add("removed", ts.Add(-time.Hour))
+		l.Remove("removed", ts.Add(time.Minute))
+		l.Add("both", ts.Add(time.Second))
+		l.Remove("both", ts.Add(time.Minute))
+		l.Remove("old-removed", ts.Add(-time.Second))
+
+		c := l.ChangedSince(ts)
+		sort.Slice(c, func(i, j int) bool { return c[i].(string) < c[j].(string) })
+		if len(c) != 3 || c[0] != "added" || c[1] != "both" || c[2] != "removed" {
+			t.Error("ChangedSince did not return changed elements correctly", c)
+		}
+		if c := l.ChangedSince(ts.Add(time.Hour)); len(c) != 0 {
+			t.Error("ChangedSince returned elements which did not change", c)
+		}
+	}
+}
+
+func TestLWW_batch(t *testing.T) {
+	for _, l := range []LWW{{}, {AddSet: listOnly{&Set{}}, RemoveSet: listOnly{&Set{}}}} {
+		l.Init()
+		ts := time.Now()
+		l.AddMany([]TimedElement{{"a", ts}, {"b", ts}, {"c", ts}, {"a", ts.Add(-time.Hour)}})
+		l.RemoveMany([]TimedElement{{"b", ts.Add(time.Second)}, {"c", ts.Add(-time.Second)}, {"d", ts}})
+
+		if ex := l.ExistsMany([]interface{}{"a", "b", "c", "d", "e"}); len(ex) != 5 || !ex[0] || ex[1] || !ex[2] || ex[3] || ex[4] {
+			t.Error("ExistsMany did not return the state of elements correctly", ex)
+		}
+		if v, _ := l.AddSet.Get("a"); !v.Equal(ts) {
+			t.Error("AddMany did not keep the greatest timestamp", v)
+		}
+	}
+}
+
 func BenchmarkLWW_Add_differnt(b *testing.B) {
 	l := LWW{}
 	l.Init()
@@ -88,6 +268,18 @@ func BenchmarkLWW_Add_same(b *testing.B) {
 	}
 }
 
+func BenchmarkLWW_AddMany(b *testing.B) {
+	l := LWW{}
+	l.Init()
+	es := make([]TimedElement, b.N)
+	for i := range es {
+		es[i] = TimedElement{Element: i, Time: time.Now()}
+	}
+	b.ResetTimer()
+
+	l.AddMany(es)
+}
+
 func BenchmarkLWW_Remove(b *testing.B) {
 	l := LWW{}
 	l.Init()
@@ -114,6 +306,61 @@ func BenchmarkLWW_Exists(b *testing.B) {
 	}
 }
 
+// parallelUnderlyings are the in-memory TimedSets compared by the parallel LWW benchmarks.
+var parallelUnderlyings = []struct {
+	name string
+	new  func() TimedSet
+}{
+	{"Set", func() TimedSet { return &Set{} }},
+	{"ShardedSet", func() TimedSet { return &ShardedSet{} }},
+	{"AtomicSet", func() TimedSet { return &AtomicSet{} }},
+}
+
+func benchmarkLWWParallel(b *testing.B, op func(l *LWW, i int)) {
+	for _, u := range parallelUnderlyings {
+		for _, procs := range []int{1, 2, 4, 8} {
+			b.Run(u.name+"/procs="+strconv.Itoa(procs), func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+				l := LWW{AddSet: u.new(), RemoveSet: u.new()}
+				l.Init()
+				var n int64
+				b.ResetTimer()
+
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						op(&l, int(atomic.AddInt64(&n, 1)))
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkLWW_Add_parallel(b *testing.B) {
+	benchmarkLWWParallel(b, func(l *LWW, i int) {
+		l.Add(i, time.Now())
+	})
+}
+
+func BenchmarkLWW_Remove_parallel(b *testing.B) {
+	benchmarkLWWParallel(b, func(l *LWW, i int) {
+		l.Remove(i, time.Now())
+	})
+}
+
+func BenchmarkLWW_Mixed_parallel(b *testing.B) {
+	benchmarkLWWParallel(b, func(l *LWW, i int) {
+		switch i % 4 {
+		case 0:
+			l.Add(i, time.Now())
+		case 1:
+			l.Remove(i-1, time.Now())
+		default:
+			l.Exists(i - 2)
+		}
+	})
+}
+
 func ExampleLWW() {
 	l := LWW{}
 	l.Init()
@@ -0,0 +1,78 @@
+/*
+Package lwwprom reports measurements of instrumented lww sets to Prometheus.
+
+  m, err := lwwprom.New(prometheus.DefaultRegisterer, "myapp")
+  if err != nil {
+  	log.Fatal(err)
+  }
+  l := lww.LWW{AddSet: add, RemoveSet: remove}
+  l.Instrument(m)
+  l.Init()
+
+It exports these metrics, each labelled with the set name and, except stale writes, the operation:
+
+  <namespace>_lww_operation_duration_seconds  histogram of operation latencies. Its _count counts operations.
+  <namespace>_lww_stale_writes_total          writes ignored because the set had a timestamp as new
+  <namespace>_lww_errors_total                operations after which the set reported an error
+*/
+package lwwprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements lww.Metrics with Prometheus collectors.
+type Metrics struct {
+	duration *prometheus.HistogramVec
+	stale    *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+}
+
+// New creates the collectors and registers them with r. If namespace is not empty it prefixes their names.
+// Latency buckets go from 10µs to about 1.3s, which covers both in memory and remote sets.
+func New(r prometheus.Registerer, namespace string) (*Metrics, error) {
+	m := &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "lww",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of operations of lww sets.",
+			Buckets:   prometheus.ExponentialBuckets(10e-6, 4, 9),
+		}, []string{"set", "op"}),
+		stale: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "lww",
+			Name:      "stale_writes_total",
+			Help:      "Writes which lww sets ignored because they already had a timestamp as new.",
+		}, []string{"set"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "lww",
+			Name:      "errors_total",
+			Help:      "Operations after which lww sets reported an error.",
+		}, []string{"set", "op"}),
+	}
+	for _, c := range []prometheus.Collector{m.duration, m.stale, m.errors} {
+		if err := r.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Operation observes the latency of an operation.
+func (m *Metrics) Operation(set, op string, d time.Duration) {
+	m.duration.WithLabelValues(set, op).Observe(d.Seconds())
+}
+
+// StaleWrite counts a write which was ignored.
+func (m *Metrics) StaleWrite(set string) {
+	m.stale.WithLabelValues(set).Inc()
+}
+
+// Error counts an operation which failed.
+func (m *Metrics) Error(set, op string) {
+	m.errors.WithLabelValues(set, op).Inc()
+}
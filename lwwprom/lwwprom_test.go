@@ -0,0 +1,71 @@
+package lwwprom
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kavehmz/lww"
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// gather returns the values of the metrics in r by name and labels, using the count of histograms.
+func gather(t *testing.T, r *prometheus.Registry) map[string]float64 {
+	fs, err := r.Gather()
+	if err != nil {
+		t.Fatal("Can't gather metrics", err)
+	}
+	v := make(map[string]float64)
+	for _, f := range fs {
+		for _, m := range f.GetMetric() {
+			k := f.GetName()
+			for _, l := range m.GetLabel() {
+				k += " " + l.GetName() + "=" + l.GetValue()
+			}
+			if h := m.GetHistogram(); h != nil {
+				v[k] = float64(h.GetSampleCount())
+			} else {
+				v[k] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return v
+}
+
+func TestMetrics(t *testing.T) {
+	r := prometheus.NewRegistry()
+	m, err := New(r, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(r, "test"); err == nil {
+		t.Error("Registering the same metrics twice did not fail")
+	}
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "lww.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open bolt for tests", err)
+	}
+	l := lww.LWW{RemoveSet: &lww.BoltSet{DB: db, Bucket: "remove", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}}
+	l.Instrument(m)
+	l.Init()
+	ts := time.Now()
+	l.Add("x", ts)
+	l.Add("x", ts)
+	l.Remove("x", ts.Add(time.Second))
+	db.Close()
+	l.Remove("x", ts.Add(2*time.Second))
+
+	v := gather(t, r)
+	for k, want := range map[string]float64{
+		"test_lww_operation_duration_seconds op=Set set=add":    2,
+		"test_lww_operation_duration_seconds op=Set set=remove": 2,
+		"test_lww_stale_writes_total set=add":                   1,
+		"test_lww_errors_total op=Set set=remove":               1,
+	} {
+		if v[k] != want {
+			t.Errorf("%s is %v, want %v", k, v[k], want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package lww
+
+import "time"
+
+// Merge applies the state of other to lww. For each element of AddSet, RemoveSet and ExpireSet lww keeps
+// the greater timestamp of the two. It is the state based merge of the CRDT, so it is commutative, associative
+// and idempotent, and replicas which merge each other in any order converge.
+//
+// Writers kept by sets which implement ProvenanceSet are merged too, if both sets implement it. Deadlines are merged only if
+// both have an ExpireSet.
+func (lww *LWW) Merge(other *LWW) {
+	mergeSet(lww.AddSet, other.AddSet)
+	mergeSet(lww.RemoveSet, other.RemoveSet)
+	if lww.ExpireSet != nil && other.ExpireSet != nil {
+		mergeSet(lww.ExpireSet, other.ExpireSet)
+	}
+}
+
+// mergeSet sets every element of src in dst. Elements are read before they are written,
+// so dst and src can be the same set.
+func mergeSet(dst, src TimedSet) {
+	var es []TimedElement
+	rangeSet(src, func(e interface{}, t time.Time) bool {
+		es = append(es, TimedElement{Element: e, Time: t})
+		return true
+	})
+	dp, ok := dst.(ProvenanceSet)
+	sp, sok := src.(ProvenanceSet)
+	if !ok || !sok {
+		setBatch(dst, es)
+		return
+	}
+	for _, e := range es {
+		if t, replica, ok := sp.GetFrom(e.Element); ok {
+			dp.SetFrom(e.Element, t, replica)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package lww
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLWW_Merge(t *testing.T) {
+	for _, replica := range []string{"", "a"} {
+		a := LWW{ReplicaID: replica}
+		a.Init()
+		b := LWW{AddSet: listOnly{&Set{}}, RemoveSet: &ShardedSet{}, ExpireSet: &Set{}, ReplicaID: "b"}
+		b.Init()
+		ts := time.Now()
+
+		a.Add("x", ts)
+		a.Add("y", ts)
+		b.Remove("x", ts.Add(time.Second))
+		b.Add("z", ts)
+		b.AddWithTTL("y", ts.Add(-time.Second), time.Hour)
+
+		a.Merge(&b)
+		b.Merge(&a)
+		for _, l := range []*LWW{&a, &b} {
+			if l.Exists("x") || !l.Exists("y") || !l.Exists("z") {
+				t.Error("Merged states are not correct", l.State("x"), l.State("y"), l.State("z"))
+			}
+			if st := l.State("y"); !st.Added.Equal(ts) || !st.Expires.Equal(ts.Add(-time.Second).Add(time.Hour)) {
+				t.Error("Merge did not keep the greater timestamps", st)
+			}
+		}
+
+		a.Merge(&a)
+		if a.AddSet.Len() != 3 || a.RemoveSet.Len() != 1 {
+			t.Error("Merging a state with itself changed it", a.AddSet.List(), a.RemoveSet.List())
+		}
+	}
+}
+
+func TestLWW_MergeProvenance(t *testing.T) {
+	a := LWW{ReplicaID: "a"}
+	a.Init()
+	b := LWW{ReplicaID: "b"}
+	b.Init()
+	ts := time.Now()
+
+	a.Add("x", ts)
+	b.Add("x", ts)
+	a.Merge(&b)
+	b.Merge(&a)
+	if a.State("x").AddedBy != "b" || b.State("x").AddedBy != "b" {
+		t.Error("Merge did not keep the writer which wins the tie", a.State("x"), b.State("x"))
+	}
+}
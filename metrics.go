@@ -0,0 +1,171 @@
+package lww
+
+import "time"
+
+// Metrics receives measurements of sets wrapped in InstrumentedSet.
+// Implementations must be safe for concurrent use. Package lwwprom has one for Prometheus.
+type Metrics interface {
+	// Operation is called after each operation of a set with how long it took. op is the method name, like "Get".
+	Operation(set, op string, d time.Duration)
+	// StaleWrite is called for each write which the set ignored because it already had a timestamp as new.
+	StaleWrite(set string)
+	// Error is called for each operation after which the set reported an error through LastState.
+	Error(set, op string)
+}
+
+/*InstrumentedSet wraps a TimedSet and reports each of its operations to Metrics, labelled with Name.
+
+Stale writes are only counted for sets which implement Updater, as other sets do not tell if they ignored a write.
+Writes through SetMany are not counted as stale either. Errors are counted for sets which have a LastState method.
+That state is shared by all operations of the set, so if it is used by many goroutines at once an error can be
+counted for an operation which ran next to the failing one or not at all, and a stale write can be missed.
+The counts are only exact for a set used by one goroutine at a time.
+InstrumentedSet implements all optional interfaces and falls back like LWW does if the wrapped set does not.
+*/
+type InstrumentedSet struct {
+	TimedSet
+	// Name labels the measurements of this set, like "add" or "remove".
+	Name string
+	// Metrics receives the measurements.
+	Metrics Metrics
+}
+
+// Instrument wraps AddSet, RemoveSet and ExpireSet in InstrumentedSets named "add", "remove" and "expire".
+// Sets are set to their defaults first, like Init does. ExpireSet is wrapped only if it is set then.
+// It must be called before Init.
+func (lww *LWW) Instrument(m Metrics) {
+	lww.setDefaults()
+	for _, s := range []struct {
+		set  *TimedSet
+		name string
+	}{{&lww.AddSet, "add"}, {&lww.RemoveSet, "remove"}, {&lww.ExpireSet, "expire"}} {
+		if *s.set == nil {
+			continue
+		}
+		*s.set = &InstrumentedSet{TimedSet: *s.set, Name: s.name, Metrics: m}
+	}
+}
+
+// observe reports an operation which started at start, and an error if the wrapped set has one now.
+func (s *InstrumentedSet) observe(op string, start time.Time) {
+	s.Metrics.Operation(s.Name, op, time.Since(start))
+	if s.LastState() != nil {
+		s.Metrics.Error(s.Name, op)
+	}
+}
+
+//LastState returns the error of the last operation of the wrapped set, or nil if it does not report errors.
+func (s *InstrumentedSet) LastState() error {
+	if st, ok := s.TimedSet.(interface{ LastState() error }); ok {
+		return st.LastState()
+	}
+	return nil
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+func (s *InstrumentedSet) Init() {
+	defer s.observe("Init", time.Now())
+	s.TimedSet.Init()
+}
+
+//Len must return the number of members in the set
+func (s *InstrumentedSet) Len() int {
+	defer s.observe("Len", time.Now())
+	return s.TimedSet.Len()
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *InstrumentedSet) Get(e interface{}) (time.Time, bool) {
+	defer s.observe("Get", time.Now())
+	return s.TimedSet.Get(e)
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *InstrumentedSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted. If the wrapped set does not implement Updater
+//it always returns true.
+func (s *InstrumentedSet) Update(e interface{}, t time.Time) bool {
+	defer s.observe("Set", time.Now())
+	u, ok := s.TimedSet.(Updater)
+	if !ok {
+		s.TimedSet.Set(e, t)
+		return true
+	}
+	accepted := u.Update(e, t)
+	if !accepted && s.LastState() == nil {
+		s.Metrics.StaleWrite(s.Name)
+	}
+	return accepted
+}
+
+//List returns list of all elements in the set
+func (s *InstrumentedSet) List() []interface{} {
+	defer s.observe("List", time.Now())
+	return s.TimedSet.List()
+}
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//The time f takes is measured too.
+func (s *InstrumentedSet) Range(f func(interface{}, time.Time) bool) {
+	defer s.observe("Range", time.Now())
+	rangeSet(s.TimedSet, f)
+}
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+func (s *InstrumentedSet) RangeByTime(from, to time.Time) []interface{} {
+	defer s.observe("RangeByTime", time.Now())
+	return rangeByTime(s.TimedSet, from, to)
+}
+
+//SetMany works like calling Set for each element in order.
+func (s *InstrumentedSet) SetMany(es []TimedElement) {
+	defer s.observe("SetMany", time.Now())
+	setBatch(s.TimedSet, es)
+}
+
+//GetMany works like calling Get for each element. Results are in the same order as the elements.
+func (s *InstrumentedSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	defer s.observe("GetMany", time.Now())
+	return getMany(s.TimedSet, es)
+}
+
+//SetFrom works like Set and keeps replica as the writer of the timestamp if the wrapped set implements ProvenanceSet.
+func (s *InstrumentedSet) SetFrom(e interface{}, t time.Time, replica string) {
+	s.UpdateFrom(e, t, replica)
+}
+
+//UpdateFrom works like SetFrom and returns true if the write was accepted. If the wrapped set implements
+//ProvenanceSet but not ProvenanceUpdater it always returns true.
+func (s *InstrumentedSet) UpdateFrom(e interface{}, t time.Time, replica string) bool {
+	p, ok := s.TimedSet.(ProvenanceSet)
+	if !ok {
+		return s.Update(e, t)
+	}
+	defer s.observe("SetFrom", time.Now())
+	u, ok := p.(ProvenanceUpdater)
+	if !ok {
+		p.SetFrom(e, t, replica)
+		return true
+	}
+	accepted := u.UpdateFrom(e, t, replica)
+	if !accepted && s.LastState() == nil {
+		s.Metrics.StaleWrite(s.Name)
+	}
+	return accepted
+}
+
+//GetFrom works like Get and also returns the replica which wrote the timestamp, or "" if it is not known.
+func (s *InstrumentedSet) GetFrom(e interface{}) (time.Time, string, bool) {
+	defer s.observe("GetFrom", time.Now())
+	return get(s.TimedSet, e)
+}
+
+//GetAt returns the greatest timestamp of the element which is not after t and true, or false if there is none.
+//If the wrapped set does not implement HistorySet it only knows the current timestamp.
+func (s *InstrumentedSet) GetAt(e interface{}, t time.Time) (time.Time, bool) {
+	defer s.observe("GetAt", time.Now())
+	return getAt(s.TimedSet, e, t)
+}
@@ -0,0 +1,186 @@
+package lww
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Metrics which counts what it receives by set and operation.
+type recorder struct {
+	sync.Mutex
+	ops, stale, errors map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{ops: make(map[string]int), stale: make(map[string]int), errors: make(map[string]int)}
+}
+
+func (r *recorder) Operation(set, op string, d time.Duration) {
+	r.Lock()
+	r.ops[set+" "+op]++
+	r.Unlock()
+}
+
+func (r *recorder) StaleWrite(set string) {
+	r.Lock()
+	r.stale[set]++
+	r.Unlock()
+}
+
+func (r *recorder) Error(set, op string) {
+	r.Lock()
+	r.errors[set+" "+op]++
+	r.Unlock()
+}
+
+func TestUpdater(t *testing.T) {
+	db := openSQLite(t)
+	bdb := openBolt(t)
+	sql := setupSQLSet(db, "lww_update")
+	bolt := setupBoltSet(bdb, "lww_update")
+	redis := setupSet(t, "TESTUPDATE")
+	nano := setupNanoSet(t, "TESTUPDATENANO")
+	provenance := setupProvenanceSet(t, "TESTUPDATEPROVENANCE", false)
+	set, sharded, atomic := &Set{}, &ShardedSet{}, &AtomicSet{}
+	set.Init()
+	sharded.Init()
+	atomic.Init()
+	for name, s := range map[string]Updater{
+		"Set":             set,
+		"ShardedSet":      sharded,
+		"AtomicSet":       atomic,
+		"SQLSet":          &sql,
+		"BoltSet":         &bolt,
+		"RedisSet":        &redis,
+		"RedisSet nano":   &nano,
+		"RedisSet origin": &provenance,
+		"ShardedRedisSet": setupShardedRedisSet(t, "TESTUPDATESHARDED", 4),
+		"CachedSet":       setupCachedSet(t, "TESTUPDATECACHED"),
+	} {
+		ts := time.Now()
+		if !s.Update("x", ts) {
+			t.Error("A new element was not accepted", name)
+		}
+		if s.Update("x", ts) || s.Update("x", ts.Add(-time.Second)) {
+			t.Error("A write which is not newer was accepted", name)
+		}
+		if !s.Update("x", ts.Add(time.Second)) {
+			t.Error("A newer write was not accepted", name)
+		}
+		if got, _ := s.(TimedSet).Get("x"); !got.Round(time.Microsecond).Equal(ts.Add(time.Second).Round(time.Microsecond)) {
+			t.Error("Update did not keep the newest timestamp", name, got)
+		}
+	}
+}
+
+func TestInstrumentedSet(t *testing.T) {
+	r := newRecorder()
+	s := InstrumentedSet{TimedSet: &Set{}, Name: "s", Metrics: r}
+	s.Init()
+	ts := time.Now()
+	s.Set("x", ts)
+	s.Set("x", ts)
+	if s.Update("x", ts.Add(-time.Second)) {
+		t.Error("Update accepted an older timestamp")
+	}
+	s.Get("x")
+	s.List()
+	s.Range(func(interface{}, time.Time) bool { return true })
+	s.SetMany([]TimedElement{{"y", ts}})
+	s.GetMany([]interface{}{"x", "y"})
+	s.RangeByTime(ts, time.Time{})
+	for k, want := range map[string]int{"s Init": 1, "s Set": 3, "s Get": 1, "s List": 1, "s Range": 1, "s SetMany": 1, "s GetMany": 1, "s RangeByTime": 1} {
+		if r.ops[k] != want {
+			t.Errorf("%s was counted %d times, want %d", k, r.ops[k], want)
+		}
+	}
+	if r.stale["s"] != 2 {
+		t.Error("Stale writes were not counted", r.stale)
+	}
+	if l := s.RangeByTime(ts, time.Time{}); len(l) != 2 {
+		t.Error("InstrumentedSet changed results of the wrapped set", l)
+	}
+
+	// Sets which do not implement Updater are never counted as stale.
+	r = newRecorder()
+	s = InstrumentedSet{TimedSet: listOnly{&Set{}}, Name: "l", Metrics: r}
+	s.Init()
+	s.Set("x", ts)
+	s.Set("x", ts)
+	if r.stale["l"] != 0 || r.ops["l Set"] != 2 {
+		t.Error("Writes to a set without Updater were not counted correctly", r.ops, r.stale)
+	}
+
+	db := openSQLite(t)
+	q := setupSQLSet(db, "instrumented")
+	r = newRecorder()
+	s = InstrumentedSet{TimedSet: &q, Name: "q", Metrics: r}
+	db.Close()
+	s.Set("x", ts)
+	s.Get("x")
+	if r.errors["q Set"] != 1 || r.errors["q Get"] != 1 || r.stale["q"] != 0 || s.LastState() == nil {
+		t.Error("Errors of the wrapped set were not counted", r.errors, r.stale)
+	}
+}
+
+func TestLWW_Instrument(t *testing.T) {
+	r := newRecorder()
+	l := LWW{ReplicaID: "a"}
+	l.Instrument(r)
+	l.Init()
+	ts := time.Now()
+	l.Add("x", ts)
+	l.Add("x", ts.Add(-time.Second))
+	l.AddWithTTL("y", ts, time.Hour)
+	l.Remove("x", ts.Add(time.Second))
+	if l.Exists("x") || !l.Exists("y") || l.State("y").AddedBy != "a" {
+		t.Error("Instrumented LWW does not work like LWW", l.State("x"), l.State("y"))
+	}
+	if r.stale["add"] != 1 || r.stale["remove"] != 0 {
+		t.Error("Stale writes with a ReplicaID were not counted", r.stale)
+	}
+	if r.ops["add SetFrom"] != 3 || r.ops["remove SetFrom"] != 1 || r.ops["expire Set"] != 1 || r.ops["add Get"] == 0 {
+		t.Error("Operations of the sets of LWW were not counted", r.ops)
+	}
+}
+
+func TestInstrumentedSet_redisMiss(t *testing.T) {
+	r := newRecorder()
+	add, remove := setupSet(t, "TESTMETRICSADD"), setupSet(t, "TESTMETRICSREMOVE")
+	l := LWW{AddSet: &add, RemoveSet: &remove}
+	l.Instrument(r)
+	l.Init()
+	l.Add("a", time.Now())
+	if !l.Exists("a") || l.Exists("b") {
+		t.Error("Instrumented redis LWW does not work like LWW")
+	}
+	if len(r.errors) != 0 || r.ops["remove Get"] == 0 || remove.LastState() != nil {
+		t.Error("Missing elements of a RedisSet were counted as errors", r.errors, remove.LastState())
+	}
+}
+
+func TestLWW_InstrumentHistory(t *testing.T) {
+	r := newRecorder()
+	l := LWW{AddSet: &Set{History: true}, RemoveSet: &Set{History: true}}
+	l.Instrument(r)
+	l.Init()
+	ts := time.Unix(0, 0)
+	l.Add("a", ts.Add(10))
+	l.Add("a", ts.Add(30))
+	if !l.ExistsAt("a", ts.Add(20)) || l.ExistsAt("a", ts.Add(5)) {
+		t.Error("ExistsAt over InstrumentedSets does not use the history")
+	}
+	if r.ops["add GetAt"] != 2 || r.ops["remove GetAt"] != 2 {
+		t.Error("GetAt was not counted", r.ops)
+	}
+}
+
+func BenchmarkInstrumentedSet_Set(b *testing.B) {
+	s := InstrumentedSet{TimedSet: &Set{}, Name: "s", Metrics: newRecorder()}
+	s.Init()
+	ts := time.Now()
+	for i := 0; i < b.N; i++ {
+		s.Set(i%1000, ts.Add(time.Duration(i)))
+	}
+}
@@ -2,6 +2,8 @@ package lww
 
 import (
 	"errors"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -16,48 +18,133 @@ This will limit this sets precision to save element's action timestamp to 1 mill
 Notice that time.Time precision is 1 nano-seconds by defaults. For this lack of precision all
 timestamps are rounded to nearest microsecond.
 Using redis can also cause latency cause by network or socket communication.
+
+If Nanoseconds is set, exact UnixNano timestamps are also kept in a hash at SetKey + ":ns",
+encoded as fixed width strings which sort like the timestamps. The scripts compare those exact values,
+so two writes in the same microsecond do not collide, while the ZSET stays available for ranges by time.
+In a redis cluster SetKey must have a hash tag, like one made by HashTagKey, so both keys are in the same slot.
+Notifications and CachedSet still use the microsecond scores.
+
+If Provenance is set, the replica which wrote each timestamp is kept in a hash at SetKey + ":replica".
+SetFrom uses it to break ties between equal timestamps and GetFrom returns it.
+
+Each operation borrows its own connection from Pool and closes it when done, so a RedisSet
+can be shared between goroutines.
 */
 type RedisSet struct {
-	// Conn is the redis connection to be used.
-	Conn redis.Conn
+	// Pool provides the redis connections to be used. A *redis.Pool can be used directly.
+	Pool ConnProvider
 	// AddSet sets which key will be used in redis for the set.
 	SetKey string
 	// Marshal function needs to convert the element to string. Redis can only store and retrieve string values.
 	Marshal func(interface{}) string
 	// UnMarshal function needs to be able to convert a Marshalled string back to a readable structure for consumer of library.
 	UnMarshal func(string) interface{}
-	// LastState is an error type that will return the error state of last executed redis command. Add redis connection are not shareable this can be used after each command to know the last state.
-	LastState error
-	setScript *redis.Script
+	// Nanoseconds keeps exact timestamps next to the ZSET instead of rounding them to microseconds.
+	Nanoseconds bool
+	// Provenance keeps which replica wrote each timestamp. See SetFrom.
+	Provenance bool
+	// History keeps all timestamps of each element in a ZSET per element at SetKey + ":h:" + the element, written
+	// by the same script as the set. See GetAt. Without Retention those keys are never deleted, so there is one
+	// more key for each element ever written. It must be set before Init.
+	History bool
+	// Retention bounds the history of each element like Retention of Set. Each history key also expires when its
+	// element was not written for Retention. If it is zero the history is kept forever.
+	Retention       time.Duration
+	setScript       *redis.Script
+	setManyScript   *redis.Script
+	getManyScript   *redis.Script
+	setNanoScript   *redis.Script
+	setFromScript   *redis.Script
+	historyAtScript *redis.Script
+	lastState
+}
+
+// ConnProvider gives RedisSet a connection for each operation. RedisSet closes the connection
+// when the operation is done, which for a *redis.Pool returns it to the pool.
+type ConnProvider interface {
+	Get() redis.Conn
+}
+
+// SingleConn turns a single redis connection into a ConnProvider. Operations of all sets using it
+// will wait for each other as a redis.Conn is not safe for concurrent use.
+func SingleConn(c redis.Conn) ConnProvider {
+	return &singleConn{c: c}
+}
+
+type singleConn struct {
+	mu sync.Mutex
+	c  redis.Conn
+}
+
+func (p *singleConn) Get() redis.Conn {
+	p.mu.Lock()
+	return &lockedConn{Conn: p.c, unlock: p.mu.Unlock}
+}
+
+// lockedConn holds the lock of a singleConn until it is closed. It does not close the underlying connection.
+type lockedConn struct {
+	redis.Conn
+	once   sync.Once
+	unlock func()
+}
+
+func (c *lockedConn) Close() error {
+	c.once.Do(c.unlock)
+	return nil
 }
 
 func roundToMicro(t time.Time) int64 {
 	return t.Round(time.Microsecond).UnixNano() / 1000
 }
 
-func (s *RedisSet) checkErr(err error) {
-	if err != nil {
-		s.LastState = err
-		return
-	}
-	s.LastState = nil
+func fromMicro(n int64) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(n) * time.Microsecond)
 }
 
+// updateToLatest sets the score of member ARGV[2] to ARGV[1] if it is greater and publishes the change.
+// It returns 1 if the score was set and 0 if the member already had a score as great.
 const updateToLatest string = `
 local c = tonumber(redis.call('ZSCORE', KEYS[1], ARGV[2]))
 if not c or tonumber(ARGV[1]) > c then
 	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
 	redis.call('PUBLISH', KEYS[1], ARGV[1] .. ":" .. ARGV[2])
-	return tonumber(ARGV[2])
+	return 1
 else
 	return 0
 end
 `
 
+// updateManyToLatest works like updateToLatest for each score and member pair in ARGV.
+const updateManyToLatest string = `
+local n = 0
+for i = 1, #ARGV, 2 do
+	local c = tonumber(redis.call('ZSCORE', KEYS[1], ARGV[i+1]))
+	if not c or tonumber(ARGV[i]) > c then
+		redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i+1])
+		redis.call('PUBLISH', KEYS[1], ARGV[i] .. ":" .. ARGV[i+1])
+		n = n + 1
+	end
+end
+return n
+`
+
+// getScores returns the score of each member in ARGV, or nil for missing ones.
+const getScores string = `
+local r = {}
+for i = 1, #ARGV do
+	r[i] = redis.call('ZSCORE', KEYS[1], ARGV[i])
+end
+return r
+`
+
+// redisBatchSize is how many elements RedisSet.SetMany and GetMany send in one script call.
+var redisBatchSize = 1000
+
 //Init will do a one time setup for underlying set. It will be called from WLL.Init
 func (s *RedisSet) Init() {
-	if s.Conn == nil {
-		s.checkErr(errors.New("Conn must be set"))
+	if s.Pool == nil {
+		s.checkErr(errors.New("Pool must be set"))
 		return
 	}
 	if s.Marshal == nil {
@@ -73,40 +160,274 @@ func (s *RedisSet) Init() {
 		return
 	}
 
-	s.setScript = redis.NewScript(1, updateToLatest)
+	if c, ok := s.Pool.(*Cluster); ok && len(c.Addrs) == 0 {
+		s.checkErr(errNoClusterAddrs)
+		return
+	}
+	if _, ok := s.Pool.(*Cluster); ok && (s.Nanoseconds || s.Provenance || s.History) && KeySlot(s.SetKey) != KeySlot(s.nanoKey()) {
+		s.checkErr(errors.New("SetKey must have a hash tag to use Nanoseconds, Provenance or History in a cluster"))
+		return
+	}
+
+	s.setScript = s.updateScript(1, updateToLatest)
+	s.setManyScript = s.updateScript(1, updateManyToLatest)
+	s.getManyScript = redis.NewScript(1, getScores)
+	s.setNanoScript = s.updateScript(2, updateManyToLatestNano)
+	s.setFromScript = s.updateScript(3, updateManyFrom)
+	s.historyAtScript = redis.NewScript(1, historyAt)
+	s.checkErr(nil)
 }
 
 //Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
 func (s *RedisSet) Set(e interface{}, t time.Time) {
-	_, err := s.setScript.Do(s.Conn, s.SetKey, roundToMicro(t), s.Marshal(e))
+	if s.Provenance {
+		s.setMembersFrom([]string{s.Marshal(e)}, []time.Time{t}, "")
+		return
+	}
+	if s.Nanoseconds {
+		s.setMembersNano([]string{s.Marshal(e)}, []time.Time{t})
+		return
+	}
+	s.setMember(s.Marshal(e), roundToMicro(t))
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t or it failed.
+func (s *RedisSet) Update(e interface{}, t time.Time) bool {
+	if s.Provenance {
+		return s.setMembersFrom([]string{s.Marshal(e)}, []time.Time{t}, "") == 1
+	}
+	if s.Nanoseconds {
+		return s.setMembersNano([]string{s.Marshal(e)}, []time.Time{t}) == 1
+	}
+	return s.setMember(s.Marshal(e), roundToMicro(t))
+}
+
+// setMember runs updateToLatest for an already marshalled member and score. It returns true if the score was set.
+func (s *RedisSet) setMember(m string, score int64) bool {
+	c := s.Pool.Get()
+	defer c.Close()
+	n, err := redis.Int(s.doUpdate(c, s.setScript, []interface{}{s.SetKey}, []interface{}{score, m}, []string{m}, []time.Time{fromMicro(score)}))
+	s.checkErr(err)
+	return n == 1
+}
+
+//SetMany works like calling Set for each element in order. Elements are sent in batches of
+//redisBatchSize, each in a single script call, so loading many elements does not need a round trip for each.
+func (s *RedisSet) SetMany(es []TimedElement) {
+	if s.Nanoseconds || s.Provenance {
+		ms, ts := make([]string, len(es)), make([]time.Time, len(es))
+		for i, e := range es {
+			ms[i], ts[i] = s.Marshal(e.Element), e.Time
+		}
+		if s.Provenance {
+			s.setMembersFrom(ms, ts, "")
+		} else {
+			s.setMembersNano(ms, ts)
+		}
+		return
+	}
+	ms, scores := make([]string, len(es)), make([]int64, len(es))
+	for i, e := range es {
+		ms[i], scores[i] = s.Marshal(e.Element), roundToMicro(e.Time)
+	}
+	s.setMembers(ms, scores)
+}
+
+// setMembers runs updateManyToLatest for already marshalled members and scores.
+func (s *RedisSet) setMembers(ms []string, scores []int64) {
+	c := s.Pool.Get()
+	defer c.Close()
+	var err error
+	for len(ms) > 0 && err == nil {
+		n := min(len(ms), redisBatchSize)
+		args := make([]interface{}, 0, 2*n)
+		var ts []time.Time
+		for i := 0; i < n; i++ {
+			args = append(args, scores[i], ms[i])
+			if s.History {
+				ts = append(ts, fromMicro(scores[i]))
+			}
+		}
+		_, err = s.doUpdate(c, s.setManyScript, []interface{}{s.SetKey}, args, ms[:n], ts)
+		ms, scores = ms[n:], scores[n:]
+	}
 	s.checkErr(err)
 }
 
 //Len must return the number of members in the set
 func (s *RedisSet) Len() int {
-	n, err := redis.Int(s.Conn.Do("ZCARD", s.SetKey))
+	c := s.Pool.Get()
+	defer c.Close()
+	n, err := redis.Int(c.Do("ZCARD", s.SetKey))
 	s.checkErr(err)
 	return n
 }
 
 //Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
 func (s *RedisSet) Get(e interface{}) (val time.Time, ok bool) {
-	n, err := redis.Int(s.Conn.Do("ZSCORE", s.SetKey, s.Marshal(e)))
+	if s.Nanoseconds {
+		ts, oks := s.getMembersNano([]string{s.Marshal(e)})
+		return ts[0], oks[0]
+	}
+	c := s.Pool.Get()
+	defer c.Close()
+	n, err := redis.Int(c.Do("ZSCORE", s.SetKey, s.Marshal(e)))
+	if err == redis.ErrNil {
+		s.checkErr(nil)
+		return val, false
+	}
 	s.checkErr(err)
 	if err == nil {
 		ok = true
-		val = time.Unix(0, 0).Add(time.Duration(n) * time.Microsecond)
+		val = fromMicro(int64(n))
 	}
 	return val, ok
 }
 
+//GetMany works like calling Get for each element. Elements are sent in batches of redisBatchSize,
+//each in a single script call.
+func (s *RedisSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	ms := make([]string, len(es))
+	for i, e := range es {
+		ms[i] = s.Marshal(e)
+	}
+	if s.Nanoseconds {
+		return s.getMembersNano(ms)
+	}
+	return s.getMembers(ms)
+}
+
+// getMembers runs getScores for already marshalled members.
+func (s *RedisSet) getMembers(ms []string) ([]time.Time, []bool) {
+	ts, oks := make([]time.Time, len(ms)), make([]bool, len(ms))
+	c := s.Pool.Get()
+	defer c.Close()
+	for done := 0; done < len(ms); {
+		n := min(len(ms)-done, redisBatchSize)
+		args := make([]interface{}, 0, n+1)
+		args = append(args, s.SetKey)
+		for _, m := range ms[done : done+n] {
+			args = append(args, m)
+		}
+		v, err := redis.Values(s.getManyScript.Do(c, args...))
+		if err != nil {
+			s.checkErr(err)
+			return ts, oks
+		}
+		for i, r := range v {
+			if r == nil {
+				continue
+			}
+			score, err := redis.Float64(r, nil)
+			if err != nil {
+				s.checkErr(err)
+				return ts, oks
+			}
+			ts[done+i], oks[done+i] = fromMicro(int64(score)), true
+		}
+		done += n
+	}
+	s.checkErr(nil)
+	return ts, oks
+}
+
 //List returns list of all elements in the set
 func (s *RedisSet) List() []interface{} {
 	var l []interface{}
-	zs, err := redis.Strings(s.Conn.Do("ZRANGE", s.SetKey, 0, -1))
+	c := s.Pool.Get()
+	defer c.Close()
+	zs, err := redis.Strings(c.Do("ZRANGE", s.SetKey, 0, -1))
 	s.checkErr(err)
 	for _, v := range zs {
 		l = append(l, s.UnMarshal(v))
 	}
 	return l
 }
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+//It uses ZRANGEBYSCORE, so bounds have the same microsecond precision as the stored timestamps,
+//unless Nanoseconds is set.
+func (s *RedisSet) RangeByTime(from, to time.Time) []interface{} {
+	if s.Nanoseconds {
+		return s.rangeByTimeNano(from, to)
+	}
+	max := "+inf"
+	if !to.IsZero() {
+		max = "(" + strconv.FormatInt(roundToMicro(to), 10)
+	}
+	c := s.Pool.Get()
+	defer c.Close()
+	zs, err := redis.Strings(c.Do("ZRANGEBYSCORE", s.SetKey, roundToMicro(from), max))
+	s.checkErr(err)
+	l := make([]interface{}, 0, len(zs))
+	for _, v := range zs {
+		l = append(l, s.UnMarshal(v))
+	}
+	return l
+}
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//It uses ZSCAN, so unlike List it does not block redis on big sets. Elements added or changed
+//during the iteration may be missed or visited twice.
+func (s *RedisSet) Range(f func(interface{}, time.Time) bool) {
+	if s.Nanoseconds {
+		s.rangeNano(f)
+		return
+	}
+	s.checkErr(scanZSet(s.Pool, s.SetKey, func(m string, score int64) error {
+		if !f(s.UnMarshal(m), fromMicro(score)) {
+			return errStopRange
+		}
+		return nil
+	}))
+}
+
+// errStopRange is returned by scanZSet callbacks to stop the scan without an error.
+var errStopRange = errors.New("stop range")
+
+// scanZSet calls f for each member of the ZSET at key. It uses ZSCAN so big sets do not block redis.
+// The connection is returned before f is called, so f can borrow its own from the same pool.
+func scanZSet(p ConnProvider, key string, f func(string, int64) error) error {
+	return scanZSetPages(p, key, func(ms []string, scores []int64) error {
+		for i := range ms {
+			if err := f(ms[i], scores[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scanZSetPages works like scanZSet but calls f once for each page ZSCAN returns.
+func scanZSetPages(p ConnProvider, key string, f func([]string, []int64) error) error {
+	cursor := 0
+	for {
+		c := p.Get()
+		v, err := redis.Values(c.Do("ZSCAN", key, cursor, "COUNT", 1000))
+		c.Close()
+		if err != nil {
+			return err
+		}
+		var zs []string
+		if _, err := redis.Scan(v, &cursor, &zs); err != nil {
+			return err
+		}
+		ms, scores := make([]string, 0, len(zs)/2), make([]int64, 0, len(zs)/2)
+		for i := 0; i+1 < len(zs); i += 2 {
+			score, err := strconv.ParseFloat(zs[i+1], 64)
+			if err != nil {
+				return err
+			}
+			ms, scores = append(ms, zs[i]), append(scores, int64(score))
+		}
+		if err := f(ms, scores); err != nil {
+			if err == errStopRange {
+				return nil
+			}
+			return err
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
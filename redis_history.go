@@ -0,0 +1,160 @@
+package lww
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// addHistory adds a timestamp to the history ZSET of each member in KEYS. ARGV has a score and an exact timestamp
+// for each key, then the score of the Retention cutoff and Retention in milliseconds, or '' and '' if there is none.
+// Timestamps with a score before the cutoff are removed, except the newest of them, and each key expires
+// after Retention, so the history of an element which is not written again does not stay forever.
+// It is only run as a part of an update script made by withHistory.
+const addHistory string = `
+local cutoff, ttl = ARGV[#ARGV-1], ARGV[#ARGV]
+for i = 1, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[2*i-1], ARGV[2*i])
+	if cutoff ~= '' then
+		local old = redis.call('ZREVRANGEBYSCORE', KEYS[i], '(' .. cutoff, '-inf', 'LIMIT', 0, 1, 'WITHSCORES')
+		if #old > 0 then
+			redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', '(' .. old[2])
+		end
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return #KEYS
+`
+
+// withHistory makes an update script with nkeys keys also run addHistory, so the update and the history are
+// written atomically. Its KEYS are those of update followed by those of addHistory. Its ARGV are those of update
+// followed by those of addHistory, which are two for each history key and two more.
+// It returns what update returns.
+func withHistory(update string, nkeys int) string {
+	return fmt.Sprintf(`
+local nkeys = %d
+local h = #KEYS - nkeys
+local keys, argv, hkeys, hargv = {}, {}, {}, {}
+for i = 1, #KEYS do
+	if i <= nkeys then keys[i] = KEYS[i] else hkeys[i-nkeys] = KEYS[i] end
+end
+local nargv = #ARGV - 2*h - 2
+for i = 1, #ARGV do
+	if i <= nargv then argv[i] = ARGV[i] else hargv[i-nargv] = ARGV[i] end
+end
+local r = (function(KEYS, ARGV)
+%s
+end)(keys, argv)
+;(function(KEYS, ARGV)
+%s
+end)(hkeys, hargv)
+return r
+`, nkeys, update, addHistory)
+}
+
+// historyAt returns the greatest exact timestamp in the history ZSET KEYS[1] which is not after ARGV[2], looking
+// at scores up to ARGV[1]. It returns 0 if there is none and nil if there is no history at all.
+const historyAt string = `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local off = 0
+while true do
+	local l = redis.call('ZREVRANGEBYSCORE', KEYS[1], ARGV[1], '-inf', 'LIMIT', off, 16)
+	if #l == 0 then
+		return 0
+	end
+	for i = 1, #l do
+		if l[i] <= ARGV[2] then
+			return l[i]
+		end
+	end
+	off = off + #l
+end
+`
+
+// historyKey is the key of the ZSET which keeps all timestamps of member m if History is set.
+// Its members are exact timestamps encoded by encodeSortableNano and its scores are those timestamps
+// in microseconds rounded down, so members with the same score are still ordered by their name.
+func (s *RedisSet) historyKey(m string) string {
+	return s.SetKey + ":h:" + m
+}
+
+// floorMicro returns UnixNano of t in microseconds rounded down, also for times before 1970.
+func floorMicro(t time.Time) int64 {
+	n := t.UnixNano()
+	q := n / 1000
+	if n%1000 < 0 {
+		q--
+	}
+	return q
+}
+
+// updateScript returns the script of an update with nkeys keys. If History is set it is made by withHistory.
+func (s *RedisSet) updateScript(nkeys int, update string) *redis.Script {
+	if s.History {
+		return redis.NewScript(-1, withHistory(update, nkeys))
+	}
+	return redis.NewScript(nkeys, update)
+}
+
+// doUpdate runs an update script made by updateScript with keys and args. If History is set,
+// the history keys of the already marshalled members ms and their args for timestamps ts are added.
+// Timestamps are rounded to microseconds like in the ZSET unless Nanoseconds is set.
+func (s *RedisSet) doUpdate(c redis.Conn, script *redis.Script, keys, args []interface{}, ms []string, ts []time.Time) (interface{}, error) {
+	if !s.History {
+		return script.Do(c, append(keys, args...)...)
+	}
+	all := make([]interface{}, 0, 1+len(keys)+len(args)+3*len(ms)+2)
+	all = append(all, len(keys)+len(ms))
+	all = append(all, keys...)
+	for _, m := range ms {
+		all = append(all, s.historyKey(m))
+	}
+	all = append(all, args...)
+	for _, t := range ts {
+		if !s.Nanoseconds {
+			t = fromMicro(roundToMicro(t))
+		}
+		all = append(all, floorMicro(t), encodeSortableNano(t))
+	}
+	cutoff, ttl := "", ""
+	if s.Retention > 0 {
+		cutoff = strconv.FormatInt(floorMicro(time.Now().Add(-s.Retention)), 10)
+		ttl = strconv.FormatInt(int64((s.Retention+time.Millisecond-1)/time.Millisecond), 10)
+	}
+	return script.Do(c, append(all, cutoff, ttl)...)
+}
+
+//GetAt returns the greatest timestamp of the element which is not after t and true, or false if there is none.
+//t is rounded to microseconds like timestamps unless Nanoseconds is set.
+//Without History, for elements which were written before History was set, or whose history expired after
+//Retention, it only knows the current timestamp, so it returns false if that is after t.
+func (s *RedisSet) GetAt(e interface{}, t time.Time) (time.Time, bool) {
+	if !s.History {
+		val, ok := s.Get(e)
+		return val, ok && val.UnixNano() <= t.UnixNano()
+	}
+	if !s.Nanoseconds {
+		t = fromMicro(roundToMicro(t))
+	}
+	c := s.Pool.Get()
+	defer c.Close()
+	v, err := s.historyAtScript.Do(c, s.historyKey(s.Marshal(e)), floorMicro(t), encodeSortableNano(t))
+	s.checkErr(err)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch v := v.(type) {
+	case []byte:
+		val, err := decodeSortableNano(string(v))
+		s.checkErr(err)
+		return val, err == nil
+	case nil:
+		val, ok := s.Get(e)
+		return val, ok && val.UnixNano() <= t.UnixNano()
+	}
+	return time.Time{}, false
+}
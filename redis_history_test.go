@@ -0,0 +1,134 @@
+package lww
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func setupHistorySet(t testing.TB, key string, nanoseconds, provenance bool, retention time.Duration) RedisSet {
+	s := setupSet(t, key)
+	c := s.Pool.Get()
+	defer c.Close()
+	for _, m := range []string{"x", "y"} {
+		if _, err := c.Do("DEL", s.historyKey(m), s.nanoKey(), s.replicaKey()); err != nil {
+			t.Error("Can't setup redis for tests", err)
+		}
+	}
+	s.History, s.Nanoseconds, s.Provenance, s.Retention = true, nanoseconds, provenance, retention
+	s.Init()
+	return s
+}
+
+func TestRedisSet_GetAt(t *testing.T) {
+	for name, s := range map[string]RedisSet{
+		"micro":      setupHistorySet(t, "TESTHISTORY", false, false, 0),
+		"nano":       setupHistorySet(t, "TESTHISTORYNANO", true, false, 0),
+		"provenance": setupHistorySet(t, "TESTHISTORYPROVENANCE", true, true, 0),
+	} {
+		ts := time.Unix(1700000000, 123456789)
+		s.Set("x", ts)
+		s.Set("x", ts.Add(2*time.Second))
+		if s.Update("x", ts.Add(time.Second)) {
+			t.Error("Update with history accepted an older timestamp", name)
+		}
+		s.SetFrom("x", ts.Add(3*time.Second), "a")
+		s.SetMany([]TimedElement{{"x", ts.Add(4 * time.Second)}, {"y", ts}})
+		if s.LastState() != nil {
+			t.Fatal("Writing history failed", name, s.LastState())
+		}
+		precision := time.Microsecond
+		if s.Nanoseconds {
+			precision = time.Nanosecond
+		}
+		for d, want := range map[time.Duration]time.Duration{
+			time.Second / 2:             0,
+			time.Second:                 time.Second,
+			time.Second + time.Second/2: time.Second,
+			3 * time.Second:             3 * time.Second,
+			time.Hour:                   4 * time.Second,
+		} {
+			got, ok := s.GetAt("x", ts.Add(d))
+			if !ok || !got.Equal(ts.Add(want).Round(precision)) {
+				t.Error("GetAt is not correct", name, d, got, ok)
+			}
+		}
+		if _, ok := s.GetAt("x", ts.Add(-time.Microsecond)); ok {
+			t.Error("GetAt returned a timestamp before the first one", name)
+		}
+		if s.Nanoseconds {
+			// Timestamps in the same microsecond are still told apart.
+			s.Set("y", ts.Add(time.Nanosecond))
+			if got, _ := s.GetAt("y", ts.Add(time.Nanosecond/2)); !got.Equal(ts) {
+				t.Error("GetAt does not keep exact timestamps", name, got)
+			}
+		}
+		if _, ok := s.GetAt("z", ts.Add(time.Hour)); ok {
+			t.Error("GetAt returned a timestamp of a missing element", name)
+		}
+	}
+}
+
+func TestRedisSet_GetAtWithoutHistory(t *testing.T) {
+	s := setupHistorySet(t, "TESTHISTORYOFF", false, false, 0)
+	s.History = false
+	s.Init()
+	ts := time.Now()
+	s.Set("x", ts)
+	for _, h := range []bool{false, true} {
+		// Elements written before History was set fall back to the current timestamp.
+		s.History = h
+		s.Init()
+		if _, ok := s.GetAt("x", ts.Add(-time.Second)); ok {
+			t.Error("GetAt returned a timestamp after t", h)
+		}
+		if _, ok := s.GetAt("x", ts.Add(time.Second)); !ok {
+			t.Error("GetAt did not return the current timestamp", h)
+		}
+	}
+}
+
+func TestRedisSet_retention(t *testing.T) {
+	s := setupHistorySet(t, "TESTHISTORYRETENTION", true, false, time.Hour)
+	now := time.Now()
+	for _, d := range []time.Duration{-4 * time.Hour, -3 * time.Hour, -2 * time.Hour, -time.Minute, time.Minute} {
+		s.Set("x", now.Add(d))
+	}
+	c := s.Pool.Get()
+	defer c.Close()
+	if n, _ := c.Do("ZCARD", s.historyKey("x")); n != int64(3) {
+		t.Error("Retention did not drop old timestamps", n)
+	}
+	if got, ok := s.GetAt("x", now.Add(-30*time.Minute)); !ok || !got.Equal(now.Add(-2*time.Hour)) {
+		t.Error("GetAt in the retention window is not correct", got, ok)
+	}
+	if ms, _ := redis.Int64(c.Do("PTTL", s.historyKey("x"))); ms <= 0 || ms > time.Hour.Nanoseconds()/1e6 {
+		t.Error("History key does not expire after Retention", ms)
+	}
+}
+
+func TestLWW_ExistsAtRedis(t *testing.T) {
+	add := setupHistorySet(t, "TESTHISTORYADD", true, false, 0)
+	remove := setupHistorySet(t, "TESTHISTORYREMOVE", true, false, 0)
+	l := LWW{AddSet: &add, RemoveSet: &remove}
+	l.Init()
+	ts := time.Now()
+	l.Add("x", ts)
+	l.Remove("x", ts.Add(time.Second))
+	l.Add("x", ts.Add(2*time.Second))
+	if !l.ExistsAt("x", ts) || l.ExistsAt("x", ts.Add(time.Second)) || !l.ExistsAt("x", ts.Add(2*time.Second)) {
+		t.Error("ExistsAt over redis history is not correct")
+	}
+	if g := l.GetAt(ts.Add(time.Second + time.Second/2)); len(g) != 0 {
+		t.Error("GetAt over redis history is not correct", g)
+	}
+}
+
+func TestRedisSet_historyCluster(t *testing.T) {
+	s := RedisSet{Pool: &Cluster{Addrs: []string{"node1"}}, SetKey: "users", History: true, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("History in a cluster without a hash tag did not fail")
+	}
+}
@@ -0,0 +1,137 @@
+package lww
+
+import (
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+/*RedisLWW is a Last-Writer-Wins Element Set which is completely kept and evaluated in redis.
+LWW over two RedisSets needs a round trip for each set it touches, and another writer can change
+the state between them. RedisLWW stores both sets under known keys and runs each operation as a
+single Lua script, so Add, Remove, Exists and Get are one atomic round trip each.
+
+The sets are stored with the same layout as RedisSet, under AddKey and RemoveKey.
+They can be read or written by RedisSets with the same keys, for example through a CachedSet.
+*/
+type RedisLWW struct {
+	// Pool provides the redis connections to be used. A *redis.Pool can be used directly.
+	Pool ConnProvider
+	// AddKey sets which key will be used in redis for the add-set.
+	AddKey string
+	// RemoveKey sets which key will be used in redis for the remove-set.
+	RemoveKey string
+	// Tag can be set instead of AddKey and RemoveKey. They will be HashTagKey(Tag, "add") and HashTagKey(Tag, "remove"),
+	// which redis cluster keeps in the same slot.
+	Tag string
+	// Marshal function needs to convert the element to string. Redis can only store and retrieve string values.
+	Marshal func(interface{}) string
+	// UnMarshal function needs to be able to convert a Marshalled string back to a readable structure for consumer of library.
+	UnMarshal    func(string) interface{}
+	setScript    *redis.Script
+	existsScript *redis.Script
+	getScript    *redis.Script
+	lastState
+}
+
+const existsLatest string = `
+local a = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not a then
+	return 0
+end
+local r = redis.call('ZSCORE', KEYS[2], ARGV[1])
+if r and tonumber(r) >= tonumber(a) then
+	return 0
+end
+return 1
+`
+
+const getExisting string = `
+local l = {}
+local a = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+for i = 1, #a, 2 do
+	local r = redis.call('ZSCORE', KEYS[2], a[i])
+	if not r or tonumber(a[i + 1]) > tonumber(r) then
+		l[#l + 1] = a[i]
+	end
+end
+return l
+`
+
+// Init will check the settings and prepare the scripts. It must be called before any other method.
+func (lww *RedisLWW) Init() {
+	if lww.Pool == nil {
+		lww.checkErr(errors.New("Pool must be set"))
+		return
+	}
+	if lww.Marshal == nil {
+		lww.checkErr(errors.New("Marshal must be set"))
+		return
+	}
+	if lww.UnMarshal == nil {
+		lww.checkErr(errors.New("UnMarshal must be set"))
+		return
+	}
+	if lww.Tag != "" && lww.AddKey == "" && lww.RemoveKey == "" {
+		lww.AddKey = HashTagKey(lww.Tag, "add")
+		lww.RemoveKey = HashTagKey(lww.Tag, "remove")
+	}
+	if lww.AddKey == "" || lww.RemoveKey == "" {
+		lww.checkErr(errors.New("AddKey and RemoveKey or Tag must be set"))
+		return
+	}
+	if c, ok := lww.Pool.(*Cluster); ok && len(c.Addrs) == 0 {
+		lww.checkErr(errNoClusterAddrs)
+		return
+	}
+	if _, ok := lww.Pool.(*Cluster); ok && KeySlot(lww.AddKey) != KeySlot(lww.RemoveKey) {
+		lww.checkErr(errors.New("AddKey and RemoveKey must be in the same cluster slot"))
+		return
+	}
+
+	lww.setScript = redis.NewScript(1, updateToLatest)
+	lww.existsScript = redis.NewScript(2, existsLatest)
+	lww.getScript = redis.NewScript(2, getExisting)
+	lww.checkErr(nil)
+}
+
+// Add will add an element to the add-set if it does not exists and updates its timestamp to
+// great one between current one and new one.
+func (lww *RedisLWW) Add(e interface{}, t time.Time) {
+	c := lww.Pool.Get()
+	defer c.Close()
+	_, err := lww.setScript.Do(c, lww.AddKey, roundToMicro(t), lww.Marshal(e))
+	lww.checkErr(err)
+}
+
+// Remove will add an element to the remove-set if it does not exists and updates its timestamp to
+// great one between current one and new one.
+func (lww *RedisLWW) Remove(e interface{}, t time.Time) {
+	c := lww.Pool.Get()
+	defer c.Close()
+	_, err := lww.setScript.Do(c, lww.RemoveKey, roundToMicro(t), lww.Marshal(e))
+	lww.checkErr(err)
+}
+
+// Exists returns true if element has a more recent record in add-set than in remove-set
+func (lww *RedisLWW) Exists(e interface{}) bool {
+	c := lww.Pool.Get()
+	defer c.Close()
+	ok, err := redis.Bool(lww.existsScript.Do(c, lww.AddKey, lww.RemoveKey, lww.Marshal(e)))
+	lww.checkErr(err)
+	return ok
+}
+
+// Get returns slice of elements that "Exist".
+func (lww *RedisLWW) Get() []interface{} {
+	c := lww.Pool.Get()
+	defer c.Close()
+	zs, err := redis.Strings(lww.getScript.Do(c, lww.AddKey, lww.RemoveKey))
+	lww.checkErr(err)
+	l := make([]interface{}, 0, len(zs))
+	for _, v := range zs {
+		l = append(l, lww.UnMarshal(v))
+	}
+	return l
+}
@@ -0,0 +1,134 @@
+package lww
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func setupRedisLWW(t testing.TB) *RedisLWW {
+	add := setupSet(t, "TESTLWWADD")
+	remove := setupSet(t, "TESTLWWREMOVE")
+	l := &RedisLWW{Pool: add.Pool, AddKey: add.SetKey, RemoveKey: remove.SetKey, Marshal: add.Marshal, UnMarshal: add.UnMarshal}
+	l.Init()
+	return l
+}
+
+func TestRedisLWW_init(t *testing.T) {
+	l := RedisLWW{}
+	l.Init()
+	if l.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	l = RedisLWW{Pool: newPool(), Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, AddKey: "TESTLWWADD"}
+	l.Init()
+	if l.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	if l := setupRedisLWW(t); l.LastState() != nil {
+		t.Error("Error raised when all params are present and correct", l.LastState())
+	}
+}
+
+func TestRedisLWW_AddExistRemove(t *testing.T) {
+	l := setupRedisLWW(t)
+	e := "John"
+	ts := time.Now()
+
+	if l.Exists(e) {
+		t.Error("New LWW claims to containt an element")
+	}
+
+	l.Add(e, ts)
+	if !l.Exists(e) {
+		t.Error("Newly added element does not exists and it should", l.LastState())
+	}
+
+	ts = ts.Add(time.Second)
+	l.Remove(e, ts)
+	if l.Exists(e) {
+		t.Error("An element which was remove with a more recent timestmap must be removed and is not")
+	}
+
+	l.Remove(e, ts.Add(-time.Hour))
+	ts = ts.Add(time.Second)
+	l.Add(e, ts)
+	if !l.Exists(e) {
+		t.Error("An element which was remove and added again with a more recent timestamp does not exists")
+	}
+
+	l.Remove(e, ts)
+	if l.Exists(e) {
+		t.Error("Remove with the same timestamp as add must win")
+	}
+}
+
+func TestRedisLWW_Get(t *testing.T) {
+	l := setupRedisLWW(t)
+	ts := time.Now()
+	l.Add("John", ts)
+	l.Add("Betty", ts)
+	l.Add("Frank", ts)
+	l.Remove("Frank", ts.Add(time.Second))
+	l.Remove("Nobody", ts.Add(time.Second))
+
+	a := l.Get()
+	sort.Slice(a, func(i, j int) bool { return a[i].(string) < a[j].(string) })
+	if len(a) != 2 || a[0] != "Betty" || a[1] != "John" {
+		t.Error("Get did not return correct memebers", a, l.LastState())
+	}
+}
+
+func TestRedisLWW_sameLayoutAsRedisSet(t *testing.T) {
+	l := setupRedisLWW(t)
+	add := RedisSet{Pool: l.Pool, SetKey: l.AddKey, Marshal: l.Marshal, UnMarshal: l.UnMarshal}
+	remove := RedisSet{Pool: l.Pool, SetKey: l.RemoveKey, Marshal: l.Marshal, UnMarshal: l.UnMarshal}
+	other := LWW{AddSet: &add, RemoveSet: &remove}
+	other.Init()
+
+	ts := time.Now()
+	for i := 0; i < 10; i++ {
+		l.Add(strconv.Itoa(i), ts)
+		if i%2 == 0 {
+			other.Remove(strconv.Itoa(i), ts.Add(time.Second))
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if l.Exists(strconv.Itoa(i)) != other.Exists(strconv.Itoa(i)) {
+			t.Error("RedisLWW and LWW over RedisSets disagree", i)
+		}
+	}
+	if len(l.Get()) != len(other.Get()) {
+		t.Error("RedisLWW and LWW over RedisSets disagree", l.Get(), other.Get())
+	}
+}
+
+func BenchmarkRedisLWW_Exists(b *testing.B) {
+	l := setupRedisLWW(b)
+	for i := 0; i < b.N; i++ {
+		l.Add(strconv.Itoa(i), time.Now())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Exists(strconv.Itoa(i))
+	}
+}
+
+func ExampleRedisLWW() {
+	l := RedisLWW{Pool: newPool(), AddKey: "TESTLWWADD", RemoveKey: "TESTLWWREMOVE", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	l.Init()
+	e := "Any_Structure"
+	l.Add(e, time.Now().UTC())
+	l.Remove(e, time.Now().UTC().Add(time.Second))
+	fmt.Println(l.Exists(e))
+	l.Add(e, time.Now().UTC().Add(2*time.Second))
+	fmt.Println(l.Exists(e))
+	// Output:
+	// false
+	// true
+}
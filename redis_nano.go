@@ -0,0 +1,167 @@
+package lww
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// updateManyToLatestNano works like updateManyToLatest for each score, member and exact timestamp in ARGV.
+// KEYS[2] is the hash of exact timestamps. Members which are only in the ZSET, because they were written
+// without Nanoseconds, are compared by their score.
+const updateManyToLatestNano string = `
+local n = 0
+for i = 1, #ARGV, 3 do
+	local newer
+	local c = redis.call('HGET', KEYS[2], ARGV[i+1])
+	if c then
+		newer = ARGV[i+2] > c
+	else
+		local s = tonumber(redis.call('ZSCORE', KEYS[1], ARGV[i+1]))
+		newer = not s or tonumber(ARGV[i]) > s
+	end
+	if newer then
+		redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i+1])
+		redis.call('HSET', KEYS[2], ARGV[i+1], ARGV[i+2])
+		redis.call('PUBLISH', KEYS[1], ARGV[i] .. ":" .. ARGV[i+1])
+		n = n + 1
+	end
+end
+return n
+`
+
+// encodeSortableNano encodes UnixNano of t as a fixed width decimal string. Flipping the sign bit
+// makes negative timestamps sort before positive ones, so the strings sort like the timestamps.
+func encodeSortableNano(t time.Time) string {
+	return fmt.Sprintf("%020d", uint64(t.UnixNano())^1<<63)
+}
+
+func decodeSortableNano(s string) (time.Time, error) {
+	u, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(u^1<<63)), nil
+}
+
+// nanoKey is the key of the hash which keeps exact timestamps if Nanoseconds is set.
+func (s *RedisSet) nanoKey() string {
+	return s.SetKey + ":ns"
+}
+
+// setMembersNano runs updateManyToLatestNano for already marshalled members in batches of redisBatchSize.
+// It returns the number of members which were set.
+func (s *RedisSet) setMembersNano(ms []string, ts []time.Time) int {
+	c := s.Pool.Get()
+	defer c.Close()
+	var err error
+	accepted := 0
+	for len(ms) > 0 && err == nil {
+		n := min(len(ms), redisBatchSize)
+		args := make([]interface{}, 0, 3*n)
+		for i := 0; i < n; i++ {
+			args = append(args, roundToMicro(ts[i]), ms[i], encodeSortableNano(ts[i]))
+		}
+		var k int
+		k, err = redis.Int(s.doUpdate(c, s.setNanoScript, []interface{}{s.SetKey, s.nanoKey()}, args, ms[:n], ts[:n]))
+		accepted += k
+		ms, ts = ms[n:], ts[n:]
+	}
+	s.checkErr(err)
+	return accepted
+}
+
+// getMembersNano reads exact timestamps of already marshalled members with HMGET in batches of redisBatchSize.
+// Members which have no exact timestamp fall back to their microsecond score.
+func (s *RedisSet) getMembersNano(ms []string) ([]time.Time, []bool) {
+	ts, oks := make([]time.Time, len(ms)), make([]bool, len(ms))
+	var missing []int
+	c := s.Pool.Get()
+	for done := 0; done < len(ms); {
+		n := min(len(ms)-done, redisBatchSize)
+		args := make([]interface{}, 0, n+1)
+		args = append(args, s.nanoKey())
+		for _, m := range ms[done : done+n] {
+			args = append(args, m)
+		}
+		v, err := redis.Values(c.Do("HMGET", args...))
+		if err != nil {
+			c.Close()
+			s.checkErr(err)
+			return ts, oks
+		}
+		for i, r := range v {
+			if r == nil {
+				missing = append(missing, done+i)
+				continue
+			}
+			b, _ := redis.String(r, nil)
+			t, err := decodeSortableNano(b)
+			if err != nil {
+				c.Close()
+				s.checkErr(err)
+				return ts, oks
+			}
+			ts[done+i], oks[done+i] = t, true
+		}
+		done += n
+	}
+	c.Close()
+
+	if len(missing) == 0 {
+		s.checkErr(nil)
+		return ts, oks
+	}
+	lms := make([]string, len(missing))
+	for j, i := range missing {
+		lms[j] = ms[i]
+	}
+	lts, loks := s.getMembers(lms)
+	for j, i := range missing {
+		ts[i], oks[i] = lts[j], loks[j]
+	}
+	return ts, oks
+}
+
+// rangeNano works like Range but reads exact timestamps for each page ZSCAN returns.
+func (s *RedisSet) rangeNano(f func(interface{}, time.Time) bool) {
+	s.checkErr(scanZSetPages(s.Pool, s.SetKey, func(ms []string, _ []int64) error {
+		ts, oks := s.getMembersNano(ms)
+		if err := s.LastState(); err != nil {
+			return err
+		}
+		for i := range ms {
+			if oks[i] && !f(s.UnMarshal(ms[i]), ts[i]) {
+				return errStopRange
+			}
+		}
+		return nil
+	}))
+}
+
+// rangeByTimeNano works like RangeByTime but compares exact timestamps. Rounding to microseconds
+// keeps the order of timestamps, so the ZSET can still narrow down the candidates.
+func (s *RedisSet) rangeByTimeNano(from, to time.Time) []interface{} {
+	max := "+inf"
+	if !to.IsZero() {
+		max = strconv.FormatInt(roundToMicro(to), 10)
+	}
+	c := s.Pool.Get()
+	ms, err := redis.Strings(c.Do("ZRANGEBYSCORE", s.SetKey, roundToMicro(from), max))
+	c.Close()
+	if err != nil {
+		s.checkErr(err)
+		return nil
+	}
+	ts, oks := s.getMembersNano(ms)
+	l := make([]interface{}, 0, len(ms))
+	for i := range ms {
+		n := ts[i].UnixNano()
+		if oks[i] && n >= from.UnixNano() && (to.IsZero() || n < to.UnixNano()) {
+			l = append(l, s.UnMarshal(ms[i]))
+		}
+	}
+	return l
+}
@@ -0,0 +1,130 @@
+package lww
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func setupNanoSet(t testing.TB, key string) RedisSet {
+	s := setupSet(t, key)
+	c := s.Pool.Get()
+	defer c.Close()
+	if _, err := c.Do("DEL", s.nanoKey()); err != nil {
+		t.Error("Can't setup redis for tests", err)
+	}
+	s.Nanoseconds = true
+	s.Init()
+	return s
+}
+
+func TestSortableNano(t *testing.T) {
+	ts := []time.Time{time.Unix(-10, 5), time.Unix(0, -1), time.Unix(0, 0), time.Unix(0, 1), time.Unix(1, 999), time.Now()}
+	for i, v := range ts {
+		d, err := decodeSortableNano(encodeSortableNano(v))
+		if err != nil || !d.Equal(v) {
+			t.Error("Timestamp did not survive encoding", v, d, err)
+		}
+		if i > 0 && encodeSortableNano(ts[i-1]) >= encodeSortableNano(v) {
+			t.Error("Encoded timestamps do not sort like timestamps", ts[i-1], v)
+		}
+	}
+	if _, err := decodeSortableNano("x"); err == nil {
+		t.Error("No error for a malformed timestamp")
+	}
+}
+
+func TestRedisSet_nanoseconds(t *testing.T) {
+	s := setupNanoSet(t, "TESTNANO")
+	ts := time.Now().Round(time.Microsecond)
+
+	s.Set("a", ts.Add(100))
+	s.Set("a", ts.Add(300))
+	s.Set("a", ts.Add(200))
+	if v, ok := s.Get("a"); !ok || !v.Equal(ts.Add(300)) {
+		t.Error("Writes in the same microsecond are not compared exactly", v, ok, s.LastState())
+	}
+
+	s.SetMany([]TimedElement{{"b", ts.Add(1)}, {"c", ts.Add(2)}, {"b", ts}})
+	v, ok := s.GetMany([]interface{}{"a", "b", "c", "d"})
+	if !v[0].Equal(ts.Add(300)) || !v[1].Equal(ts.Add(1)) || !v[2].Equal(ts.Add(2)) || ok[3] {
+		t.Error("SetMany and GetMany do not keep exact timestamps", v, ok)
+	}
+
+	n := 0
+	s.Range(func(e interface{}, t0 time.Time) bool {
+		if e == "a" && !t0.Equal(ts.Add(300)) {
+			t.Error("Range does not return exact timestamps", t0)
+		}
+		n++
+		return true
+	})
+	if n != 3 || s.Len() != 3 {
+		t.Error("Range did not visit all members", n, s.Len())
+	}
+
+	l := s.RangeByTime(ts.Add(2), ts.Add(300))
+	if len(l) != 1 || l[0] != "c" {
+		t.Error("RangeByTime does not compare exact timestamps", l)
+	}
+	if l := s.RangeByTime(ts.Add(1), time.Time{}); len(l) != 3 {
+		t.Error("RangeByTime without upper bound did not return all members", l)
+	}
+}
+
+func TestRedisSet_nanosecondsExisting(t *testing.T) {
+	s := setupNanoSet(t, "TESTNANO")
+	s.Nanoseconds = false
+	ts := time.Now().Round(time.Microsecond)
+	for i := 0; i < 5; i++ {
+		s.Set(strconv.Itoa(i), ts)
+	}
+
+	s.Nanoseconds = true
+	s.Set("0", ts.Add(-time.Second))
+	s.Set("1", ts.Add(time.Nanosecond))
+	s.Set("2", ts.Add(time.Microsecond+time.Nanosecond))
+	v, ok := s.GetMany([]interface{}{"0", "1", "2"})
+	if !ok[0] || !v[0].Equal(ts) || !v[1].Equal(ts) || !v[2].Equal(ts.Add(time.Microsecond+time.Nanosecond)) {
+		t.Error("Members written without Nanoseconds are not compared by their score", v, ok)
+	}
+	l := s.RangeByTime(ts, time.Time{})
+	sort.Slice(l, func(i, j int) bool { return l[i].(string) < l[j].(string) })
+	if len(l) != 5 || l[0] != "0" {
+		t.Error("RangeByTime did not return members written without Nanoseconds", l)
+	}
+}
+
+func TestRedisSet_nanosecondsCluster(t *testing.T) {
+	s := RedisSet{Pool: &Cluster{Addrs: []string{"node1"}}, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: "TESTNANO", Nanoseconds: true}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for a SetKey without hash tag in a cluster")
+	}
+	s.SetKey = HashTagKey("nano", "add")
+	s.Init()
+	if s.LastState() != nil {
+		t.Error("Error raised for a SetKey with hash tag", s.LastState())
+	}
+}
+
+func FuzzSortableNano(f *testing.F) {
+	f.Add(int64(0), int64(1), "09223372036854775808")
+	f.Add(int64(-1), int64(0), "x")
+	f.Add(int64(-9223372036854775808), int64(9223372036854775807), "18446744073709551615")
+	f.Fuzz(func(t *testing.T, a, b int64, s string) {
+		ta, tb := time.Unix(0, a), time.Unix(0, b)
+		ea, eb := encodeSortableNano(ta), encodeSortableNano(tb)
+		if d, err := decodeSortableNano(ea); err != nil || !d.Equal(ta) {
+			t.Error("Timestamp did not survive encoding", ta, d, err)
+		}
+		if (a < b) != (ea < eb) || len(ea) != 20 {
+			t.Error("Encoded timestamps do not sort like timestamps", a, b, ea, eb)
+		}
+		// Any 20 digits which decode are the encoding of what they decode to.
+		if d, err := decodeSortableNano(s); err == nil && len(s) == 20 && encodeSortableNano(d) != s {
+			t.Errorf("%q decoded to %v which encodes as %q", s, d, encodeSortableNano(d))
+		}
+	})
+}
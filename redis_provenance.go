@@ -0,0 +1,115 @@
+package lww
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// updateManyFrom works like updateManyToLatest for each score, member, exact timestamp and replica in ARGV.
+// The exact timestamp is empty unless Nanoseconds is set. KEYS[3] is the hash of replicas.
+// For equal timestamps the greater replica wins and no replica is the least of all.
+const updateManyFrom string = `
+local n = 0
+for i = 1, #ARGV, 4 do
+	local m = ARGV[i+1]
+	local cmp = 1
+	local s = tonumber(redis.call('ZSCORE', KEYS[1], m))
+	if s then
+		local a, b = tonumber(ARGV[i]), s
+		if ARGV[i+2] ~= '' then
+			local c = redis.call('HGET', KEYS[2], m)
+			if c then
+				a, b = ARGV[i+2], c
+			end
+		end
+		if a < b then
+			cmp = -1
+		elseif a == b then
+			cmp = 0
+		end
+	end
+	if cmp == 0 and ARGV[i+3] > (redis.call('HGET', KEYS[3], m) or '') then
+		cmp = 1
+	end
+	if cmp == 1 then
+		redis.call('ZADD', KEYS[1], ARGV[i], m)
+		if ARGV[i+2] ~= '' then
+			redis.call('HSET', KEYS[2], m, ARGV[i+2])
+		end
+		if ARGV[i+3] ~= '' then
+			redis.call('HSET', KEYS[3], m, ARGV[i+3])
+		else
+			redis.call('HDEL', KEYS[3], m)
+		end
+		redis.call('PUBLISH', KEYS[1], ARGV[i] .. ":" .. m)
+		n = n + 1
+	end
+end
+return n
+`
+
+// replicaKey is the key of the hash which keeps the writer of each timestamp if Provenance is set.
+func (s *RedisSet) replicaKey() string {
+	return s.SetKey + ":replica"
+}
+
+//SetFrom works like Set and keeps replica as the writer of the timestamp. For equal timestamps the greater replica wins.
+//If Provenance is not set it is the same as Set.
+func (s *RedisSet) SetFrom(e interface{}, t time.Time, replica string) {
+	if !s.Provenance {
+		s.Set(e, t)
+		return
+	}
+	s.setMembersFrom([]string{s.Marshal(e)}, []time.Time{t}, replica)
+}
+
+//UpdateFrom works like SetFrom and returns true if the write was accepted, false if it was stale or it failed.
+func (s *RedisSet) UpdateFrom(e interface{}, t time.Time, replica string) bool {
+	if !s.Provenance {
+		return s.Update(e, t)
+	}
+	return s.setMembersFrom([]string{s.Marshal(e)}, []time.Time{t}, replica) == 1
+}
+
+//GetFrom works like Get and also returns the replica which wrote the timestamp, or "" if it is not known.
+func (s *RedisSet) GetFrom(e interface{}) (time.Time, string, bool) {
+	t, ok := s.Get(e)
+	if !ok || !s.Provenance || s.LastState() != nil {
+		return t, "", ok
+	}
+	c := s.Pool.Get()
+	defer c.Close()
+	r, err := redis.String(c.Do("HGET", s.replicaKey(), s.Marshal(e)))
+	if err == redis.ErrNil {
+		err = nil
+	}
+	s.checkErr(err)
+	return t, r, ok
+}
+
+// setMembersFrom runs updateManyFrom for already marshalled members in batches of redisBatchSize.
+// It returns the number of members which were set.
+func (s *RedisSet) setMembersFrom(ms []string, ts []time.Time, replica string) int {
+	c := s.Pool.Get()
+	defer c.Close()
+	var err error
+	accepted := 0
+	for len(ms) > 0 && err == nil {
+		n := min(len(ms), redisBatchSize)
+		args := make([]interface{}, 0, 4*n)
+		for i := 0; i < n; i++ {
+			nano := ""
+			if s.Nanoseconds {
+				nano = encodeSortableNano(ts[i])
+			}
+			args = append(args, roundToMicro(ts[i]), ms[i], nano, replica)
+		}
+		var k int
+		k, err = redis.Int(s.doUpdate(c, s.setFromScript, []interface{}{s.SetKey, s.nanoKey(), s.replicaKey()}, args, ms[:n], ts[:n]))
+		accepted += k
+		ms, ts = ms[n:], ts[n:]
+	}
+	s.checkErr(err)
+	return accepted
+}
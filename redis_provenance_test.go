@@ -0,0 +1,64 @@
+package lww
+
+import (
+	"testing"
+	"time"
+)
+
+func setupProvenanceSet(t testing.TB, key string, nanoseconds bool) RedisSet {
+	s := setupSet(t, key)
+	c := s.Pool.Get()
+	defer c.Close()
+	if _, err := c.Do("DEL", s.nanoKey(), s.replicaKey()); err != nil {
+		t.Error("Can't setup redis for tests", err)
+	}
+	s.Provenance, s.Nanoseconds = true, nanoseconds
+	s.Init()
+	return s
+}
+
+func TestRedisSet_provenance(t *testing.T) {
+	for _, nanoseconds := range []bool{false, true} {
+		s := setupProvenanceSet(t, "TESTPROVENANCE", nanoseconds)
+		ts := time.Now().Round(time.Microsecond)
+
+		if !s.UpdateFrom("a", ts, "b") || s.UpdateFrom("a", ts, "a") || s.UpdateFrom("a", ts, "b") {
+			t.Error("UpdateFrom did not tell which writes were accepted", nanoseconds)
+		}
+		if v, r, ok := s.GetFrom("a"); !ok || !v.Equal(ts) || r != "b" {
+			t.Error("Smaller replica won the tie of equal timestamps", nanoseconds, v, r, ok, s.LastState())
+		}
+		s.SetFrom("a", ts.Add(-time.Second), "c")
+		if _, r, _ := s.GetFrom("a"); r != "b" {
+			t.Error("Older timestamp replaced the replica", nanoseconds, r)
+		}
+		s.SetFrom("a", ts.Add(time.Second), "a")
+		if v, r, _ := s.GetFrom("a"); !v.Equal(ts.Add(time.Second)) || r != "a" {
+			t.Error("Newer timestamp did not replace the replica", nanoseconds, v, r)
+		}
+		s.SetMany([]TimedElement{{"a", ts.Add(2 * time.Second)}, {"b", ts}})
+		if _, r, _ := s.GetFrom("a"); r != "" {
+			t.Error("Set without replica kept the old replica", nanoseconds, r)
+		}
+		if _, r, ok := s.GetFrom("b"); !ok || r != "" {
+			t.Error("Element without replica is not correct", nanoseconds, r, ok)
+		}
+	}
+
+	s := setupProvenanceSet(t, "TESTPROVENANCE", true)
+	ts := time.Now().Round(time.Microsecond)
+	s.SetFrom("a", ts.Add(2), "a")
+	s.SetFrom("a", ts.Add(1), "b")
+	if v, r, _ := s.GetFrom("a"); !v.Equal(ts.Add(2)) || r != "a" {
+		t.Error("Nanoseconds are not compared before replicas", v, r)
+	}
+}
+
+func TestRedisSet_provenanceDisabled(t *testing.T) {
+	s := setupSet(t, "TESTPROVENANCE")
+	ts := time.Now().Round(time.Microsecond)
+	s.SetFrom("a", ts, "a")
+	if v, r, ok := s.GetFrom("a"); !ok || !v.Equal(ts) || r != "" {
+		t.Error("SetFrom without Provenance did not work like Set", v, r, ok)
+	}
+}
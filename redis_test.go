@@ -2,61 +2,86 @@ package lww
 
 import (
 	"fmt"
+	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/kavehmz/lww/redistest"
 )
 
+// redisAddr is the address of the in-process redis server all tests of the package use.
+var redisAddr string
+
+func TestMain(m *testing.M) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		fmt.Println("Can't start redis for tests", err)
+		os.Exit(1)
+	}
+	redisAddr = s.Addr()
+	code := m.Run()
+	s.Close()
+	os.Exit(code)
+}
+
+func dialRedis() (redis.Conn, error) {
+	return redis.Dial("tcp", redisAddr)
+}
+
+func newPool() *redis.Pool {
+	return &redis.Pool{Dial: dialRedis}
+}
+
 func TestRedisSet_init(t *testing.T) {
-	r, _ := redis.Dial("tcp", ":6379")
+	r := newPool()
 	s := RedisSet{}
 	s.Init()
-	if s.LastState == nil {
+	if s.LastState() == nil {
 		t.Error("No error for missing params")
 	}
 
-	s = RedisSet{Conn: r}
+	s = RedisSet{Pool: r}
 	s.Init()
-	if s.LastState == nil {
+	if s.LastState() == nil {
 		t.Error("No error for missing params")
 	}
 
-	s = RedisSet{Conn: r, Marshal: func(e interface{}) string { return e.(string) }}
+	s = RedisSet{Pool: r, Marshal: func(e interface{}) string { return e.(string) }}
 	s.Init()
-	if s.LastState == nil {
+	if s.LastState() == nil {
 		t.Error("No error for missing params")
 	}
 
-	s = RedisSet{Conn: r, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s = RedisSet{Pool: r, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
 	s.Init()
-	if s.LastState == nil {
+	if s.LastState() == nil {
 		t.Error("No error for missing params")
 	}
 
-	s = RedisSet{Conn: r, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: "TESTKEY"}
+	s = RedisSet{Pool: r, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: "TESTKEY"}
 	s.Init()
-	if s.LastState != nil {
+	if s.LastState() != nil {
 		t.Error("Error raised when all params are present and correct")
 	}
 }
 
-func setupSet(t testing.TB, r *redis.Conn, key string) RedisSet {
-	c, _ := redis.Dial("tcp", "localhost:6379")
-	_, err := c.Do("DEL", key)
-	r = &c
-	if err != nil {
+func setupSet(t testing.TB, key string) RedisSet {
+	p := newPool()
+	c := p.Get()
+	defer c.Close()
+	if _, err := c.Do("DEL", key); err != nil {
 		t.Error("Can't setup redis for tests", err)
 	}
-	s := RedisSet{Conn: *r, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: key}
+	s := RedisSet{Pool: p, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: key}
 	s.Init()
 	return s
 }
 
 func TestRedisSet(t *testing.T) {
-	var r *redis.Conn
-	s := setupSet(t, r, "TESTKEY")
+	s := setupSet(t, "TESTKEY")
 
 	if s.Len() != 0 {
 		t.Error("New set if not empty")
@@ -98,9 +123,137 @@ func TestRedisSet(t *testing.T) {
 	}
 }
 
+func TestRedisSet_range(t *testing.T) {
+	s := setupSet(t, "TESTKEY")
+	ts := time.Now().Round(time.Microsecond)
+	for i := 0; i < 2500; i++ {
+		s.Set(strconv.Itoa(i), ts.Add(time.Duration(i)*time.Second))
+	}
+
+	seen := make(map[interface{}]bool)
+	s.Range(func(e interface{}, t0 time.Time) bool {
+		i, _ := strconv.Atoi(e.(string))
+		if !t0.Equal(ts.Add(time.Duration(i) * time.Second)) {
+			t.Error("Range did not return correct timestamp", e, t0)
+		}
+		seen[e] = true
+		return true
+	})
+	if len(seen) != 2500 || s.LastState() != nil {
+		t.Error("Range did not visit all members", len(seen), s.LastState())
+	}
+
+	n := 0
+	s.Range(func(interface{}, time.Time) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Error("Range did not stop when f returned false", n)
+	}
+}
+
+func TestRedisSet_rangeByTime(t *testing.T) {
+	s := setupSet(t, "TESTKEY")
+	for i := 0; i < 10; i++ {
+		s.Set(strconv.Itoa(i), time.Unix(int64(i), 0))
+	}
+
+	l := s.RangeByTime(time.Unix(3, 0), time.Unix(6, 0))
+	if len(l) != 3 || l[0] != "3" || l[2] != "5" {
+		t.Error("RangeByTime did not return correct members", l, s.LastState())
+	}
+	l = s.RangeByTime(time.Unix(8, 0), time.Time{})
+	if len(l) != 2 || l[0] != "8" || l[1] != "9" {
+		t.Error("RangeByTime without upper bound did not return correct members", l)
+	}
+}
+
+func TestRedisSet_batch(t *testing.T) {
+	defer func(n int) { redisBatchSize = n }(redisBatchSize)
+	redisBatchSize = 3
+	s := setupSet(t, "TESTKEY")
+
+	ts := time.Now().Round(time.Microsecond)
+	es := make([]TimedElement, 10)
+	keys := make([]interface{}, 11)
+	for i := range es {
+		es[i] = TimedElement{Element: strconv.Itoa(i), Time: ts.Add(time.Duration(i) * time.Second)}
+		keys[i] = strconv.Itoa(i)
+	}
+	keys[10] = "missing"
+	s.SetMany(es)
+	s.SetMany([]TimedElement{{"0", time.Unix(1, 0)}})
+	if s.LastState() != nil || s.Len() != 10 {
+		t.Error("SetMany did not add all elements", s.LastState(), s.Len())
+	}
+
+	v, ok := s.GetMany(keys)
+	for i := 0; i < 10; i++ {
+		if !ok[i] || !v[i].Equal(ts.Add(time.Duration(i)*time.Second)) {
+			t.Error("GetMany did not return elements in order", i, v[i], ok[i])
+		}
+	}
+	if ok[10] {
+		t.Error("GetMany found an element which was not set")
+	}
+}
+
+func TestRedisSet_singleConn(t *testing.T) {
+	c, _ := redis.Dial("tcp", redisAddr)
+	defer c.Close()
+	c.Do("DEL", "TESTKEY")
+	p := SingleConn(c)
+	add := RedisSet{Pool: p, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: "TESTKEY"}
+	add.Init()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				add.Set(strconv.Itoa(i), time.Unix(int64(w), 0))
+				add.Get(strconv.Itoa(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if add.Len() != 20 {
+		t.Error("Single connection shared between goroutines is not working", add.Len(), add.LastState())
+	}
+}
+
+// TestRedisSet_concurrent is meant to be run with -race. A RedisSet is shared between many goroutines.
+func TestRedisSet_concurrent(t *testing.T) {
+	s := setupSet(t, "TESTKEY")
+	base := time.Now().Round(time.Microsecond)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				s.Set(strconv.Itoa(i), base.Add(time.Duration(w)*time.Second))
+				s.Get(strconv.Itoa(i))
+				s.LastState()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if s.Len() != 20 {
+		t.Error("len is wrong after concurrent add", s.Len())
+	}
+	if ts, ok := s.Get("10"); !ok || !ts.Equal(base.Add(15*time.Second)) {
+		t.Error("concurrent writers did not keep the greatest timestamp", ts, ok)
+	}
+}
+
 func BenchmarkRedisSet_add_different(b *testing.B) {
-	var r *redis.Conn
-	s := setupSet(b, r, "TESTKEY")
+	s := setupSet(b, "TESTKEY")
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -109,8 +262,7 @@ func BenchmarkRedisSet_add_different(b *testing.B) {
 }
 
 func BenchmarkRedisSet_add_same(b *testing.B) {
-	var r *redis.Conn
-	s := setupSet(b, r, "TESTKEY")
+	s := setupSet(b, "TESTKEY")
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -118,9 +270,19 @@ func BenchmarkRedisSet_add_same(b *testing.B) {
 	}
 }
 
+func BenchmarkRedisSet_setMany(b *testing.B) {
+	s := setupSet(b, "TESTKEY")
+	es := make([]TimedElement, b.N)
+	for i := range es {
+		es[i] = TimedElement{Element: strconv.Itoa(i), Time: time.Now()}
+	}
+	b.ResetTimer()
+
+	s.SetMany(es)
+}
+
 func BenchmarkRedisSet_get(b *testing.B) {
-	var r *redis.Conn
-	s := setupSet(b, r, "TESTKEY")
+	s := setupSet(b, "TESTKEY")
 	for i := 0; i < b.N; i++ {
 		s.Set(strconv.Itoa(i), time.Now())
 	}
@@ -132,8 +294,8 @@ func BenchmarkRedisSet_get(b *testing.B) {
 }
 
 func ExampleRedisSet() {
-	c, _ := redis.Dial("tcp", "localhost:6379")
-	s := RedisSet{Conn: c, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: "TESTKEY"}
+	p := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", redisAddr) }}
+	s := RedisSet{Pool: p, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, SetKey: "TESTKEY"}
 	s.Init()
 	s.Set("Data", time.Unix(1451606400, 0))
 	ts, ok := s.Get("Data")
@@ -143,3 +305,60 @@ func ExampleRedisSet() {
 	// true
 	// 1451606400
 }
+
+// maxExactNano bounds timestamps of fuzz tests to those whose microseconds a float64 score holds exactly.
+const maxExactNano = (1 << 53) * 1000
+
+func FuzzRoundToMicro(f *testing.F) {
+	f.Add(int64(0), int64(1))
+	f.Add(int64(499), int64(500))
+	f.Add(int64(-500), int64(-501))
+	f.Add(int64(1500000000123456789), int64(1500000000123457289))
+	f.Fuzz(func(t *testing.T, a, b int64) {
+		a, b = a%maxExactNano, b%maxExactNano
+		if a > b {
+			a, b = b, a
+		}
+		ta, tb := time.Unix(0, a), time.Unix(0, b)
+		ra, rb := roundToMicro(ta), roundToMicro(tb)
+		if d := fromMicro(ra).Sub(ta); d < -500 || d > 500 {
+			t.Error("Rounding to microseconds moved a timestamp by", d, a)
+		}
+		if roundToMicro(fromMicro(ra)) != ra {
+			t.Error("Microseconds did not survive a round trip", ra)
+		}
+		if ra > rb {
+			t.Error("Rounding to microseconds does not keep the order of timestamps", a, b, ra, rb)
+		}
+	})
+}
+
+// FuzzRedisSet_set writes two timestamps of any member through updateToLatest, or updateManyToLatestNano if nano is set,
+// and checks the greater one is kept. Its seed corpus is in testdata/fuzz.
+func FuzzRedisSet_set(f *testing.F) {
+	f.Add("e", int64(1), int64(2), false)
+	f.Add("e", int64(2), int64(1), true)
+	f.Fuzz(func(t *testing.T, m string, a, b int64, nano bool) {
+		s := setupSet(t, "FUZZSET")
+		if nano {
+			s = setupNanoSet(t, "FUZZSET")
+		}
+		ta, tb := time.Unix(0, a%maxExactNano), time.Unix(0, b%maxExactNano)
+		s.Set(m, ta)
+		s.Set(m, tb)
+		if err := s.LastState(); err != nil {
+			t.Fatalf("Set of %q failed: %v", m, err)
+		}
+
+		want := fromMicro(max(roundToMicro(ta), roundToMicro(tb)))
+		if nano {
+			want = time.Unix(0, max(ta.UnixNano(), tb.UnixNano()))
+		}
+		if got, ok := s.Get(m); !ok || !got.Equal(want) {
+			t.Errorf("Get(%q) = %v, %v, want %v", m, got, ok, want)
+		}
+		if l := s.List(); len(l) != 1 || l[0] != m || s.Len() != 1 {
+			t.Errorf("List is %q and Len %d after setting %q", l, s.Len(), m)
+		}
+	})
+}
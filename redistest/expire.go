@@ -0,0 +1,54 @@
+package redistest
+
+import (
+	"strconv"
+	"time"
+)
+
+// expireKeys removes keys whose deadline has passed, and deadlines of keys which are gone.
+// It runs before each command, so expired keys are never seen. The caller must hold s.mu.
+func (s *Server) expireKeys() {
+	now := time.Now()
+	for k, d := range s.expires {
+		if !s.exists(k) || !now.Before(d) {
+			s.del(k)
+		}
+	}
+}
+
+// del removes the key k of any type and its deadline. It returns false if there was no such key.
+func (s *Server) del(k string) bool {
+	ok := s.exists(k)
+	delete(s.zsets, k)
+	delete(s.hashes, k)
+	delete(s.streams, k)
+	delete(s.expires, k)
+	return ok
+}
+
+func cmdPExpire(s *Server, args []string) interface{} {
+	ms, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return replyError("ERR value is not an integer or out of range")
+	}
+	if !s.exists(args[1]) {
+		return int64(0)
+	}
+	if ms <= 0 {
+		s.del(args[1])
+		return int64(1)
+	}
+	s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	return int64(1)
+}
+
+func cmdPTTL(s *Server, args []string) interface{} {
+	if !s.exists(args[1]) {
+		return int64(-2)
+	}
+	d, ok := s.expires[args[1]]
+	if !ok {
+		return int64(-1)
+	}
+	return int64(time.Until(d) / time.Millisecond)
+}
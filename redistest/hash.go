@@ -0,0 +1,97 @@
+package redistest
+
+// hash returns the hash at key. If create is set a missing one is created.
+func (s *Server) hash(key string, create bool) (map[string]string, replyError) {
+	if _, ok := s.zsets[key]; ok || s.streams[key] != nil {
+		return nil, wrongType
+	}
+	h := s.hashes[key]
+	if h == nil && create {
+		h = make(map[string]string)
+		s.hashes[key] = h
+	}
+	return h, ""
+}
+
+func cmdHSet(s *Server, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return wrongArity(args[0])
+	}
+	h, err := s.hash(args[1], true)
+	if err != "" {
+		return err
+	}
+	n := int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := h[args[i]]; !ok {
+			n++
+		}
+		h[args[i]] = args[i+1]
+	}
+	return n
+}
+
+func cmdHGet(s *Server, args []string) interface{} {
+	h, err := s.hash(args[1], false)
+	if err != "" {
+		return err
+	}
+	if v, ok := h[args[2]]; ok {
+		return v
+	}
+	return nil
+}
+
+func cmdHMGet(s *Server, args []string) interface{} {
+	h, err := s.hash(args[1], false)
+	if err != "" {
+		return err
+	}
+	r := make([]interface{}, 0, len(args)-2)
+	for _, f := range args[2:] {
+		if v, ok := h[f]; ok {
+			r = append(r, v)
+		} else {
+			r = append(r, nil)
+		}
+	}
+	return r
+}
+
+func cmdHDel(s *Server, args []string) interface{} {
+	h, err := s.hash(args[1], false)
+	if err != "" {
+		return err
+	}
+	n := int64(0)
+	for _, f := range args[2:] {
+		if _, ok := h[f]; ok {
+			delete(h, f)
+			n++
+		}
+	}
+	if h != nil && len(h) == 0 {
+		delete(s.hashes, args[1])
+	}
+	return n
+}
+
+func cmdHLen(s *Server, args []string) interface{} {
+	h, err := s.hash(args[1], false)
+	if err != "" {
+		return err
+	}
+	return int64(len(h))
+}
+
+func cmdHGetAll(s *Server, args []string) interface{} {
+	h, err := s.hash(args[1], false)
+	if err != "" {
+		return err
+	}
+	r := make([]interface{}, 0, 2*len(h))
+	for f, v := range h {
+		r = append(r, f, v)
+	}
+	return r
+}
@@ -0,0 +1,242 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// newLuaState returns a Lua state with the libraries redis offers to scripts.
+// The redis table is set for each script run, as it needs the Server.
+func newLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	return L
+}
+
+func sha1hex(script string) string {
+	h := sha1.Sum([]byte(script))
+	return hex.EncodeToString(h[:])
+}
+
+// load compiles a script and keeps it by its SHA1, like SCRIPT LOAD.
+func (s *Server) load(script string) (string, replyError) {
+	sha := sha1hex(script)
+	if _, ok := s.scripts[sha]; ok {
+		return sha, ""
+	}
+	f, err := s.lua.LoadString(script)
+	if err != nil {
+		return "", replyError("ERR Error compiling script " + strings.ReplaceAll(err.Error(), "\n", " "))
+	}
+	s.scripts[sha] = f
+	return sha, ""
+}
+
+func cmdEval(s *Server, args []string) interface{} {
+	sha, err := s.load(args[1])
+	if err != "" {
+		return err
+	}
+	return s.eval(sha, args[2:])
+}
+
+func cmdEvalSHA(s *Server, args []string) interface{} {
+	return s.eval(strings.ToLower(args[1]), args[2:])
+}
+
+func cmdScript(s *Server, args []string) interface{} {
+	switch strings.ToUpper(args[1]) {
+	case "LOAD":
+		if len(args) != 3 {
+			return wrongArity("script|load")
+		}
+		sha, err := s.load(args[2])
+		if err != "" {
+			return err
+		}
+		return sha
+	case "EXISTS":
+		r := make([]interface{}, 0, len(args)-2)
+		for _, sha := range args[2:] {
+			n := int64(0)
+			if _, ok := s.scripts[strings.ToLower(sha)]; ok {
+				n = 1
+			}
+			r = append(r, n)
+		}
+		return r
+	case "FLUSH":
+		s.scripts = make(map[string]*lua.LFunction)
+		return status("OK")
+	}
+	return replyError("ERR unknown subcommand '" + args[1] + "'")
+}
+
+// eval runs a loaded script. args are numkeys followed by keys and arguments, like for EVALSHA.
+func (s *Server) eval(sha string, args []string) interface{} {
+	f, ok := s.scripts[sha]
+	if !ok {
+		return replyError("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return replyError("ERR value is not an integer or out of range")
+	}
+	if numkeys < 0 || numkeys > len(args)-1 {
+		return replyError("ERR Number of keys can't be greater than number of args")
+	}
+	L := s.lua
+	L.SetGlobal("KEYS", stringsTable(L, args[1:1+numkeys]))
+	L.SetGlobal("ARGV", stringsTable(L, args[1+numkeys:]))
+	L.SetGlobal("redis", s.redisTable(L))
+
+	L.Push(f)
+	if err := L.PCall(0, 1, nil); err != nil {
+		msg := err.Error()
+		if e, ok := err.(*lua.ApiError); ok {
+			msg = e.Object.String()
+		}
+		return replyError(fmt.Sprintf("ERR Error running script (call to f_%s): %s", sha, msg))
+	}
+	r := L.Get(-1)
+	L.Pop(1)
+	return fromLua(r)
+}
+
+func stringsTable(L *lua.LState, l []string) *lua.LTable {
+	t := L.NewTable()
+	for _, v := range l {
+		t.Append(lua.LString(v))
+	}
+	return t
+}
+
+// redisTable returns the redis table of scripts, with call, pcall, status_reply and error_reply.
+func (s *Server) redisTable(L *lua.LState) *lua.LTable {
+	t := L.NewTable()
+	call := func(protected bool) lua.LGFunction {
+		return func(L *lua.LState) int {
+			args := make([]string, 0, L.GetTop())
+			for i := 1; i <= L.GetTop(); i++ {
+				switch v := L.Get(i).(type) {
+				case lua.LString:
+					args = append(args, string(v))
+				case lua.LNumber:
+					args = append(args, formatLuaNumber(float64(v)))
+				default:
+					L.RaiseError("Lua redis() command arguments must be strings or integers")
+					return 0
+				}
+			}
+			if len(args) == 0 {
+				L.RaiseError("Please specify at least one argument for redis.call()")
+				return 0
+			}
+			r := s.do(args, true)
+			if e, ok := r.(replyError); ok && !protected {
+				L.RaiseError("%s", string(e))
+				return 0
+			}
+			L.Push(toLua(L, r))
+			return 1
+		}
+	}
+	t.RawSetString("call", L.NewFunction(call(false)))
+	t.RawSetString("pcall", L.NewFunction(call(true)))
+	t.RawSetString("status_reply", L.NewFunction(func(L *lua.LState) int {
+		r := L.NewTable()
+		r.RawSetString("ok", lua.LString(L.CheckString(1)))
+		L.Push(r)
+		return 1
+	}))
+	t.RawSetString("error_reply", L.NewFunction(func(L *lua.LState) int {
+		r := L.NewTable()
+		r.RawSetString("err", lua.LString(L.CheckString(1)))
+		L.Push(r)
+		return 1
+	}))
+	return t
+}
+
+// formatLuaNumber formats a number passed to redis.call like redis does, so integers have no exponent.
+func formatLuaNumber(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e17 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+// toLua converts a reply to a Lua value the way redis does for redis.call.
+func toLua(L *lua.LState, r interface{}) lua.LValue {
+	switch v := r.(type) {
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case status:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v))
+		return t
+	case replyError:
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(v))
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for _, e := range v {
+			t.Append(toLua(L, e))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+// fromLua converts the value a script returns to a reply the way redis does.
+// Numbers are truncated to integers and arrays stop at their first nil.
+func fromLua(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if e, ok := v.RawGetString("err").(lua.LString); ok {
+			return replyError(e)
+		}
+		if s, ok := v.RawGetString("ok").(lua.LString); ok {
+			return status(s)
+		}
+		r := []interface{}{}
+		for i := 1; ; i++ {
+			e := v.RawGetInt(i)
+			if e == lua.LNil {
+				return r
+			}
+			r = append(r, fromLua(e))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package redistest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestServer_eval(t *testing.T) {
+	_, c := setupServer(t)
+	script := redis.NewScript(1, `
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+return {redis.call('ZSCORE', KEYS[1], ARGV[2]), redis.call('ZSCORE', KEYS[1], 'missing'), tonumber(ARGV[1]) + 1, true}
+`)
+	v, err := redis.Values(script.Do(c, "z", "1451606400000000", "a"))
+	if err != nil {
+		t.Fatal("Script failed", err)
+	}
+	if len(v) != 4 || string(v[0].([]byte)) != "1451606400000000" || v[1] != nil || v[2] != int64(1451606400000001) || v[3] != int64(1) {
+		t.Error("Script replies are not converted like redis", v)
+	}
+
+	if l, _ := redis.Ints(c.Do("SCRIPT", "EXISTS", script.Hash(), "0000")); len(l) != 2 || l[0] != 1 || l[1] != 0 {
+		t.Error("SCRIPT EXISTS is not correct", l)
+	}
+	c.Do("SCRIPT", "FLUSH")
+	if _, err := c.Do("EVALSHA", script.Hash(), 1, "z", 1, "a"); err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		t.Error("No NOSCRIPT error for a flushed script", err)
+	}
+}
+
+func TestServer_evalErrors(t *testing.T) {
+	_, c := setupServer(t)
+	c.Do("HSET", "h", "f", "v")
+	if _, err := c.Do("EVAL", "return redis.call('ZCARD', KEYS[1])", 1, "h"); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Error("Errors of redis.call are not raised", err)
+	}
+	v, err := redis.String(c.Do("EVAL", "return redis.pcall('ZCARD', KEYS[1])['err']", 1, "h"))
+	if err != nil || !strings.HasPrefix(v, "WRONGTYPE") {
+		t.Error("Errors of redis.pcall are not returned", v, err)
+	}
+	if _, err := c.Do("EVAL", "return redis.error_reply('MY error')", 0); err == nil || err.Error() != "MY error" {
+		t.Error("error_reply is not returned as an error", err)
+	}
+	if v, _ := redis.String(c.Do("EVAL", "return redis.status_reply('FINE')", 0)); v != "FINE" {
+		t.Error("status_reply is not returned as a status", v)
+	}
+	if _, err := c.Do("EVAL", "return redis.call('EVAL', 'return 1', 0)", 0); err == nil {
+		t.Error("Scripts can run other scripts")
+	}
+	if _, err := c.Do("EVAL", "return (", 0); err == nil {
+		t.Error("No error for a script which does not compile")
+	}
+}
@@ -0,0 +1,461 @@
+/*
+Package redistest provides an in-process redis server for tests, so tests of redis backed sets
+do not need an external redis.
+
+Server speaks RESP on a random local port and implements the part of redis the lww package uses:
+DEL, EXISTS, FLUSHALL, PING, PEXPIRE, PTTL, sorted sets (ZADD, ZREM, ZSCORE, ZCARD, ZRANGE, ZRANGEBYSCORE, ZREVRANGEBYSCORE, ZREMRANGEBYSCORE, ZSCAN),
+hashes (HSET, HGET, HMGET, HDEL, HLEN, HGETALL), streams (XADD, XLEN, XRANGE), pub/sub (PUBLISH, SUBSCRIBE, UNSUBSCRIBE)
+and Lua scripts through EVAL, EVALSHA and SCRIPT. Commands run one at a time, so scripts are atomic like in redis.
+
+  s, err := redistest.NewServer()
+  if err != nil {
+  	log.Fatal(err)
+  }
+  defer s.Close()
+  pool := &redis.Pool{Dial: s.Dial}
+*/
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// status is a simple string reply, like OK.
+type status string
+
+// replyError is an error reply. It must start with an error code like ERR.
+type replyError string
+
+// Replies are status, replyError, int64, string for bulk strings, nil for a nil bulk string or []interface{} for arrays.
+
+/*Server is an in-process redis server. It keeps all data in memory and is safe for many connections at once.
+A Server must be created by NewServer.
+*/
+type Server struct {
+	l       net.Listener
+	mu      sync.Mutex
+	zsets   map[string]map[string]float64
+	hashes  map[string]map[string]string
+	streams map[string]*stream
+	expires map[string]time.Time
+	subs    map[string]map[*client]bool
+	scripts map[string]*lua.LFunction
+	lua     *lua.LState
+	clients map[*client]bool
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a Server on a random local port.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		l:       l,
+		zsets:   make(map[string]map[string]float64),
+		hashes:  make(map[string]map[string]string),
+		streams: make(map[string]*stream),
+		expires: make(map[string]time.Time),
+		subs:    make(map[string]map[*client]bool),
+		scripts: make(map[string]*lua.LFunction),
+		lua:     newLuaState(),
+		clients: make(map[*client]bool),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the Server listens on.
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Dial opens a new connection to the Server. It can be used as Dial of a redis.Pool.
+func (s *Server) Dial() (redis.Conn, error) {
+	return redis.Dial("tcp", s.Addr())
+}
+
+// FlushAll removes all keys, like FLUSHALL.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushAll()
+}
+
+// Close stops the Server and closes all its connections.
+func (s *Server) Close() {
+	s.l.Close()
+	s.mu.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.lua.Close()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		c := newClient(conn)
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+		s.wg.Add(2)
+		go func() {
+			defer s.wg.Done()
+			c.writeLoop()
+		}()
+		go func() {
+			defer s.wg.Done()
+			s.serve(c)
+		}()
+	}
+}
+
+func (s *Server) serve(c *client) {
+	r := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				c.send(replyError("ERR Protocol error: " + err.Error()))
+			}
+			break
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.handle(c, args)
+	}
+	s.mu.Lock()
+	for ch := range c.channels {
+		s.unsubscribe(c, ch)
+	}
+	delete(s.clients, c)
+	s.mu.Unlock()
+	c.close()
+}
+
+// handle runs a command of a client and sends its replies.
+func (s *Server) handle(c *client, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "SUBSCRIBE":
+		if len(args) < 2 {
+			c.send(wrongArity(cmd))
+			return
+		}
+		for _, ch := range args[1:] {
+			s.subscribe(c, ch)
+			c.send([]interface{}{"subscribe", ch, int64(len(c.channels))})
+		}
+		return
+	case "UNSUBSCRIBE":
+		channels := args[1:]
+		if len(channels) == 0 {
+			for ch := range c.channels {
+				channels = append(channels, ch)
+			}
+		}
+		for _, ch := range channels {
+			s.unsubscribe(c, ch)
+			c.send([]interface{}{"unsubscribe", ch, int64(len(c.channels))})
+		}
+		return
+	}
+	if len(c.channels) > 0 {
+		if cmd == "PING" {
+			c.send([]interface{}{"pong", ""})
+			return
+		}
+		c.send(replyError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(cmd))))
+		return
+	}
+	c.send(s.do(args, false))
+}
+
+func (s *Server) subscribe(c *client, ch string) {
+	if s.subs[ch] == nil {
+		s.subs[ch] = make(map[*client]bool)
+	}
+	s.subs[ch][c] = true
+	c.channels[ch] = true
+}
+
+func (s *Server) unsubscribe(c *client, ch string) {
+	delete(s.subs[ch], c)
+	if len(s.subs[ch]) == 0 {
+		delete(s.subs, ch)
+	}
+	delete(c.channels, ch)
+}
+
+func (s *Server) flushAll() {
+	s.zsets = make(map[string]map[string]float64)
+	s.hashes = make(map[string]map[string]string)
+	s.streams = make(map[string]*stream)
+	s.expires = make(map[string]time.Time)
+}
+
+func wrongArity(cmd string) replyError {
+	return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+const wrongType = replyError("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// do runs a command and returns its reply. The caller must hold s.mu. inScript is set for commands
+// called by a script, which can not run other scripts.
+func (s *Server) do(args []string, inScript bool) interface{} {
+	cmd := strings.ToUpper(args[0])
+	f, ok := commands[cmd]
+	if !ok {
+		return replyError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	if len(args) < f.minArgs || (f.maxArgs > 0 && len(args) > f.maxArgs) {
+		return wrongArity(cmd)
+	}
+	if inScript && f.noScript {
+		return replyError("ERR This Redis command is not allowed from script")
+	}
+	s.expireKeys()
+	return f.run(s, args)
+}
+
+// command describes a command. minArgs and maxArgs count the command name too. maxArgs is zero if there is no limit.
+type command struct {
+	minArgs, maxArgs int
+	noScript         bool
+	run              func(s *Server, args []string) interface{}
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":             {1, 2, false, cmdPing},
+		"DEL":              {2, 0, false, cmdDel},
+		"EXISTS":           {2, 0, false, cmdExists},
+		"PEXPIRE":          {3, 3, false, cmdPExpire},
+		"PTTL":             {2, 2, false, cmdPTTL},
+		"FLUSHALL":         {1, 2, false, cmdFlushAll},
+		"FLUSHDB":          {1, 2, false, cmdFlushAll},
+		"ZADD":             {4, 0, false, cmdZAdd},
+		"ZREM":             {3, 0, false, cmdZRem},
+		"ZSCORE":           {3, 3, false, cmdZScore},
+		"ZCARD":            {2, 2, false, cmdZCard},
+		"ZRANGE":           {4, 5, false, cmdZRange},
+		"ZRANGEBYSCORE":    {4, 8, false, cmdZRangeByScore},
+		"ZREVRANGEBYSCORE": {4, 8, false, cmdZRevRangeByScore},
+		"ZREMRANGEBYSCORE": {4, 4, false, cmdZRemRangeByScore},
+		"ZSCAN":            {3, 0, false, cmdZScan},
+		"HSET":             {4, 0, false, cmdHSet},
+		"HGET":             {3, 3, false, cmdHGet},
+		"HMGET":            {3, 0, false, cmdHMGet},
+		"HDEL":             {3, 0, false, cmdHDel},
+		"HLEN":             {2, 2, false, cmdHLen},
+		"HGETALL":          {2, 2, false, cmdHGetAll},
+		"XADD":             {5, 0, false, cmdXAdd},
+		"XLEN":             {2, 2, false, cmdXLen},
+		"XRANGE":           {4, 6, false, cmdXRange},
+		"PUBLISH":          {3, 3, false, cmdPublish},
+		"EVAL":             {3, 0, true, cmdEval},
+		"EVALSHA":          {3, 0, true, cmdEvalSHA},
+		"SCRIPT":           {2, 0, true, cmdScript},
+	}
+}
+
+func cmdPing(s *Server, args []string) interface{} {
+	if len(args) == 2 {
+		return args[1]
+	}
+	return status("PONG")
+}
+
+func cmdDel(s *Server, args []string) interface{} {
+	n := int64(0)
+	for _, k := range args[1:] {
+		if s.del(k) {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Server, args []string) interface{} {
+	n := int64(0)
+	for _, k := range args[1:] {
+		if s.exists(k) {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdFlushAll(s *Server, args []string) interface{} {
+	s.flushAll()
+	return status("OK")
+}
+
+func (s *Server) exists(k string) bool {
+	_, z := s.zsets[k]
+	_, h := s.hashes[k]
+	_, x := s.streams[k]
+	return z || h || x
+}
+
+func cmdPublish(s *Server, args []string) interface{} {
+	for c := range s.subs[args[1]] {
+		c.send([]interface{}{"message", args[1], args[2]})
+	}
+	return int64(len(s.subs[args[1]]))
+}
+
+// client is a connection to the Server. Replies are queued and written by writeLoop,
+// so publishing to a slow subscriber does not block the Server.
+type client struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	cond     *sync.Cond
+	out      []byte
+	closed   bool
+	channels map[string]bool
+}
+
+func newClient(conn net.Conn) *client {
+	c := &client{conn: conn, channels: make(map[string]bool)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *client) send(r interface{}) {
+	c.mu.Lock()
+	c.out = appendReply(c.out, r)
+	c.cond.Signal()
+	c.mu.Unlock()
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Signal()
+	c.mu.Unlock()
+}
+
+func (c *client) writeLoop() {
+	defer c.conn.Close()
+	for {
+		c.mu.Lock()
+		for len(c.out) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		b := c.out
+		c.out = nil
+		closed := c.closed
+		c.mu.Unlock()
+		if len(b) > 0 {
+			if _, err := c.conn.Write(b); err != nil {
+				return
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+var errProtocol = errors.New("expected an array of bulk strings")
+
+// maxArgs and maxBulkLen are the limits redis has by default for the number of arguments and the size of each.
+const (
+	maxArgs    = 1024 * 1024
+	maxBulkLen = 512 * 1024 * 1024
+)
+
+// readCommand reads a command sent as a RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, errProtocol
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, errProtocol
+	}
+	args := make([]string, 0, min(max(n, 0), 64))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, errProtocol
+		}
+		// The buffer grows as data arrives, so a huge size alone does not allocate.
+		var b strings.Builder
+		if _, err := io.CopyN(&b, r, int64(size)+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		args = append(args, b.String()[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func appendReply(b []byte, r interface{}) []byte {
+	switch v := r.(type) {
+	case status:
+		return append(append(append(b, '+'), v...), "\r\n"...)
+	case replyError:
+		return append(append(append(b, '-'), v...), "\r\n"...)
+	case int64:
+		return append(strconv.AppendInt(append(b, ':'), v, 10), "\r\n"...)
+	case string:
+		b = strconv.AppendInt(append(b, '$'), int64(len(v)), 10)
+		return append(append(append(b, "\r\n"...), v...), "\r\n"...)
+	case []interface{}:
+		b = append(strconv.AppendInt(append(b, '*'), int64(len(v)), 10), "\r\n"...)
+		for _, e := range v {
+			b = appendReply(b, e)
+		}
+		return b
+	default:
+		return append(b, "$-1\r\n"...)
+	}
+}
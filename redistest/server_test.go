@@ -0,0 +1,278 @@
+package redistest
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func setupServer(t testing.TB) (*Server, redis.Conn) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal("Can't start server", err)
+	}
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal("Can't dial server", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return s, c
+}
+
+func TestServer_keys(t *testing.T) {
+	s, c := setupServer(t)
+	if v, err := redis.String(c.Do("PING")); err != nil || v != "PONG" {
+		t.Error("PING is not answered", v, err)
+	}
+	c.Do("ZADD", "z", 1, "a")
+	c.Do("HSET", "h", "f", "v")
+	if n, _ := redis.Int(c.Do("EXISTS", "z", "h", "x")); n != 2 {
+		t.Error("EXISTS did not count existing keys", n)
+	}
+	if _, err := c.Do("HGET", "z", "f"); err == nil {
+		t.Error("No error for a key of the wrong type")
+	}
+	if n, _ := redis.Int(c.Do("DEL", "z", "x")); n != 1 {
+		t.Error("DEL did not count removed keys", n)
+	}
+	s.FlushAll()
+	if n, _ := redis.Int(c.Do("EXISTS", "h")); n != 0 {
+		t.Error("FlushAll did not remove keys")
+	}
+	if _, err := c.Do("NOSUCHCOMMAND"); err == nil {
+		t.Error("No error for an unknown command")
+	}
+	if _, err := c.Do("ZCARD"); err == nil {
+		t.Error("No error for wrong number of arguments")
+	}
+}
+
+func TestServer_expire(t *testing.T) {
+	_, c := setupServer(t)
+	c.Do("ZADD", "z", 1, "a")
+	c.Do("HSET", "h", "f", "v")
+	if n, _ := redis.Int(c.Do("PEXPIRE", "z", 20)); n != 1 {
+		t.Error("PEXPIRE did not set a deadline", n)
+	}
+	if n, _ := redis.Int(c.Do("PEXPIRE", "x", 20)); n != 0 {
+		t.Error("PEXPIRE set a deadline of a missing key", n)
+	}
+	if ms, _ := redis.Int(c.Do("PTTL", "z")); ms <= 0 || ms > 20 {
+		t.Error("PTTL did not return the time left", ms)
+	}
+	if ms, _ := redis.Int(c.Do("PTTL", "h")); ms != -1 {
+		t.Error("PTTL of a key without deadline is not -1", ms)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n, _ := redis.Int(c.Do("EXISTS", "z", "h")); n != 1 {
+		t.Error("Key was not removed at its deadline", n)
+	}
+	if ms, _ := redis.Int(c.Do("PTTL", "z")); ms != -2 {
+		t.Error("PTTL of a missing key is not -2", ms)
+	}
+	// A deleted key does not keep its deadline.
+	c.Do("PEXPIRE", "h", 20)
+	c.Do("DEL", "h")
+	c.Do("HSET", "h", "f", "v")
+	time.Sleep(30 * time.Millisecond)
+	if n, _ := redis.Int(c.Do("EXISTS", "h")); n != 1 {
+		t.Error("A new key got the deadline of a deleted one", n)
+	}
+}
+
+func TestServer_zset(t *testing.T) {
+	_, c := setupServer(t)
+	if n, _ := redis.Int(c.Do("ZADD", "z", 1451606400000000, "a", 2, "b", 3, "c")); n != 3 {
+		t.Error("ZADD did not count new members", n)
+	}
+	c.Do("ZADD", "z", 1.5, "a")
+	if v, _ := redis.String(c.Do("ZSCORE", "z", "a")); v != "1.5" {
+		t.Error("ZSCORE is not correct", v)
+	}
+	c.Do("ZADD", "z", 1451606400000000, "a")
+	if v, _ := redis.String(c.Do("ZSCORE", "z", "a")); v != "1451606400000000" {
+		t.Error("Integer scores must be formatted without exponent", v)
+	}
+	if _, err := redis.String(c.Do("ZSCORE", "z", "x")); err != redis.ErrNil {
+		t.Error("ZSCORE of a missing member is not nil", err)
+	}
+	if n, _ := redis.Int(c.Do("ZCARD", "z")); n != 3 {
+		t.Error("ZCARD is not correct", n)
+	}
+	if l, _ := redis.Strings(c.Do("ZRANGE", "z", 0, -1)); len(l) != 3 || l[0] != "b" || l[2] != "a" {
+		t.Error("ZRANGE is not ordered by score", l)
+	}
+	if l, _ := redis.Strings(c.Do("ZRANGE", "z", -2, -1, "WITHSCORES")); len(l) != 4 || l[0] != "c" || l[1] != "3" {
+		t.Error("ZRANGE with negative indexes and scores is not correct", l)
+	}
+	if l, _ := redis.Strings(c.Do("ZRANGEBYSCORE", "z", 2, "(3")); len(l) != 1 || l[0] != "b" {
+		t.Error("ZRANGEBYSCORE with an exclusive bound is not correct", l)
+	}
+	if l, _ := redis.Strings(c.Do("ZRANGEBYSCORE", "z", "-inf", "+inf", "LIMIT", 1, 1)); len(l) != 1 || l[0] != "c" {
+		t.Error("ZRANGEBYSCORE with LIMIT is not correct", l)
+	}
+	if l, _ := redis.Strings(c.Do("ZREVRANGEBYSCORE", "z", "(1451606400000000", "-inf", "WITHSCORES", "LIMIT", 0, 1)); len(l) != 2 || l[0] != "c" || l[1] != "3" {
+		t.Error("ZREVRANGEBYSCORE is not in reverse order", l)
+	}
+	if n, _ := redis.Int(c.Do("ZREMRANGEBYSCORE", "z", "-inf", "(3")); n != 1 {
+		t.Error("ZREMRANGEBYSCORE did not count removed members", n)
+	}
+	if n, _ := redis.Int(c.Do("ZREM", "z", "a", "x")); n != 1 {
+		t.Error("ZREM did not count removed members", n)
+	}
+}
+
+func TestServer_zscan(t *testing.T) {
+	_, c := setupServer(t)
+	for i := 0; i < 25; i++ {
+		c.Do("ZADD", "z", i, string(rune('a'+i)))
+	}
+	seen := make(map[string]bool)
+	cursor, pages := 0, 0
+	for {
+		v, err := redis.Values(c.Do("ZSCAN", "z", cursor, "COUNT", 10))
+		if err != nil {
+			t.Fatal("ZSCAN failed", err)
+		}
+		var zs []string
+		redis.Scan(v, &cursor, &zs)
+		for i := 0; i < len(zs); i += 2 {
+			seen[zs[i]] = true
+		}
+		pages++
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 25 || pages != 3 {
+		t.Error("ZSCAN did not page through all members", len(seen), pages)
+	}
+}
+
+func TestServer_hash(t *testing.T) {
+	_, c := setupServer(t)
+	if n, _ := redis.Int(c.Do("HSET", "h", "a", "1", "b", "2")); n != 2 {
+		t.Error("HSET did not count new fields", n)
+	}
+	if v, _ := redis.String(c.Do("HGET", "h", "a")); v != "1" {
+		t.Error("HGET is not correct", v)
+	}
+	if l, _ := redis.Values(c.Do("HMGET", "h", "b", "x")); len(l) != 2 || l[1] != nil {
+		t.Error("HMGET is not correct", l)
+	}
+	if m, _ := redis.StringMap(c.Do("HGETALL", "h")); len(m) != 2 || m["b"] != "2" {
+		t.Error("HGETALL is not correct", m)
+	}
+	c.Do("HDEL", "h", "a", "b")
+	if n, _ := redis.Int(c.Do("HLEN", "h")); n != 0 {
+		t.Error("HDEL did not remove fields", n)
+	}
+}
+
+func TestServer_stream(t *testing.T) {
+	_, c := setupServer(t)
+	first, err := redis.String(c.Do("XADD", "x", "*", "a", "1"))
+	if err != nil {
+		t.Fatal("XADD failed", err)
+	}
+	c.Do("XADD", "x", "*", "a", "2", "b", "3")
+	if _, err := c.Do("XADD", "x", "1-0", "a", "4"); err == nil {
+		t.Error("XADD accepted an id smaller than the last one")
+	}
+	if n, _ := redis.Int(c.Do("XLEN", "x")); n != 2 {
+		t.Error("XLEN is not correct", n)
+	}
+	l, _ := redis.Values(c.Do("XRANGE", "x", "-", "+"))
+	if len(l) != 2 {
+		t.Fatal("XRANGE did not return all entries", l)
+	}
+	e, _ := redis.Values(l[1], nil)
+	if id, _ := redis.String(e[0], nil); id <= first {
+		t.Error("Ids of XADD are not increasing", first, id)
+	}
+	if f, _ := redis.Strings(e[1], nil); len(f) != 4 || f[3] != "3" {
+		t.Error("XRANGE did not return fields of the entry", f)
+	}
+	if l, _ := redis.Values(c.Do("XRANGE", "x", "("+first, "+", "COUNT", "5")); len(l) != 1 {
+		t.Error("XRANGE with an exclusive start is not correct", l)
+	}
+	if l, _ := redis.Values(c.Do("XRANGE", "x", "-", "+", "COUNT", "1")); len(l) != 1 {
+		t.Error("XRANGE did not stop at COUNT", l)
+	}
+	c.Do("XADD", "x", "MAXLEN", "~", "1", "*", "a", "5")
+	if n, _ := redis.Int(c.Do("XLEN", "x")); n != 1 {
+		t.Error("XADD did not trim to MAXLEN", n)
+	}
+	if _, err := c.Do("ZADD", "x", "1", "a"); err == nil {
+		t.Error("ZADD on a stream did not fail")
+	}
+	if _, err := c.Do("XADD", "h", "*", "a", "1"); err != nil {
+		t.Error(err)
+	}
+	c.Do("DEL", "x")
+	if n, _ := redis.Int(c.Do("EXISTS", "x", "h")); n != 1 {
+		t.Error("DEL did not remove the stream", n)
+	}
+}
+
+func TestServer_pubsub(t *testing.T) {
+	s, c := setupServer(t)
+	sc, _ := s.Dial()
+	defer sc.Close()
+	psc := redis.PubSubConn{Conn: sc}
+	psc.Subscribe("ch")
+	if v, ok := psc.Receive().(redis.Subscription); !ok || v.Kind != "subscribe" || v.Channel != "ch" || v.Count != 1 {
+		t.Error("SUBSCRIBE is not confirmed", v)
+	}
+	if _, err := sc.Do("GET", "x"); err == nil {
+		t.Error("No error for a command in subscribed state")
+	}
+
+	if n, _ := redis.Int(c.Do("PUBLISH", "ch", "hello")); n != 1 {
+		t.Error("PUBLISH did not count receivers", n)
+	}
+	received := make(chan interface{}, 1)
+	go func() { received <- psc.Receive() }()
+	select {
+	case v := <-received:
+		if m, ok := v.(redis.Message); !ok || string(m.Data) != "hello" {
+			t.Error("Message is not received correctly", v)
+		}
+	case <-time.After(time.Second):
+		t.Error("Message is not received")
+	}
+}
+
+func FuzzReadCommand(f *testing.F) {
+	f.Add([]byte("*2\r\n$4\r\nPING\r\n$0\r\n\r\n"))
+	f.Add([]byte("*1\r\n$999999999\r\nx"))
+	f.Add([]byte("*-1\r\n"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		args, err := readCommand(bufio.NewReader(strings.NewReader(string(b))))
+		if err != nil {
+			return
+		}
+		// A command which was read must read the same from its own encoding.
+		var enc []byte
+		enc = appendReply(enc, toReplies(args))
+		again, err := readCommand(bufio.NewReader(strings.NewReader(string(enc))))
+		if err != nil || !slices.Equal(again, args) {
+			t.Errorf("Command %q did not survive encoding: %q, %v", args, again, err)
+		}
+	})
+}
+
+func toReplies(args []string) []interface{} {
+	r := make([]interface{}, len(args))
+	for i, a := range args {
+		r[i] = a
+	}
+	return r
+}
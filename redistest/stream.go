@@ -0,0 +1,199 @@
+package redistest
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID is the id of a stream entry, ms-seq in redis.
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq)
+}
+
+// parseStreamID parses an id. A missing sequence is seq, which is 0 for the start of a range and the maximum for its end.
+func parseStreamID(s string, seq uint64) (streamID, bool) {
+	ms, rest, found := strings.Cut(s, "-")
+	id := streamID{seq: seq}
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return id, false
+	}
+	if found {
+		if id.seq, err = strconv.ParseUint(rest, 10, 64); err != nil {
+			return id, false
+		}
+	}
+	return id, true
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// stream is a redis stream. Entries are ordered by id.
+type stream struct {
+	entries []streamEntry
+	last    streamID
+}
+
+// stream returns the stream at key. If create is set a missing one is created.
+func (s *Server) stream(key string, create bool) (*stream, replyError) {
+	if s.exists(key) && s.streams[key] == nil {
+		return nil, wrongType
+	}
+	x := s.streams[key]
+	if x == nil && create {
+		x = &stream{}
+		s.streams[key] = x
+	}
+	return x, ""
+}
+
+// cmdXAdd implements XADD key [MAXLEN [~|=] n] <*|id> field value [field value ...].
+func cmdXAdd(s *Server, args []string) interface{} {
+	i := 2
+	maxLen := -1
+	if strings.ToUpper(args[i]) == "MAXLEN" {
+		i++
+		if args[i] == "~" || args[i] == "=" {
+			i++
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		maxLen = n
+		i++
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return wrongArity(args[0])
+	}
+	x, err := s.stream(args[1], false)
+	if err != "" {
+		return err
+	}
+	last := streamID{}
+	if x != nil {
+		last = x.last
+	}
+	var id streamID
+	if args[i] == "*" {
+		id = streamID{ms: uint64(time.Now().UnixMilli())}
+		if !last.less(id) {
+			if last.seq == math.MaxUint64 {
+				return replyError("ERR The stream has exhausted the last possible ID, unable to add more items")
+			}
+			id = streamID{last.ms, last.seq + 1}
+		}
+	} else {
+		var ok bool
+		if id, ok = parseStreamID(args[i], 0); !ok {
+			return replyError("ERR Invalid stream ID specified as stream command argument")
+		}
+		if !last.less(id) {
+			return replyError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+	x, _ = s.stream(args[1], true)
+	x.entries = append(x.entries, streamEntry{id, append([]string(nil), args[i+1:]...)})
+	x.last = id
+	if maxLen >= 0 && len(x.entries) > maxLen {
+		x.entries = append([]streamEntry(nil), x.entries[len(x.entries)-maxLen:]...)
+	}
+	return id.String()
+}
+
+func cmdXLen(s *Server, args []string) interface{} {
+	x, err := s.stream(args[1], false)
+	if err != "" {
+		return err
+	}
+	if x == nil {
+		return int64(0)
+	}
+	return int64(len(x.entries))
+}
+
+// cmdXRange implements XRANGE key start end [COUNT n]. start and end can be - and +, and exclusive with a ( prefix.
+func cmdXRange(s *Server, args []string) interface{} {
+	start, ok := parseRangeID(args[2], 0)
+	if !ok {
+		return replyError("ERR Invalid stream ID specified as stream command argument")
+	}
+	end, ok := parseRangeID(args[3], math.MaxUint64)
+	if !ok {
+		return replyError("ERR Invalid stream ID specified as stream command argument")
+	}
+	count := -1
+	if len(args) == 6 {
+		if strings.ToUpper(args[4]) != "COUNT" {
+			return replyError("ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[5])
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		count = n
+	} else if len(args) != 4 {
+		return replyError("ERR syntax error")
+	}
+	x, err := s.stream(args[1], false)
+	if err != "" {
+		return err
+	}
+	r := []interface{}{}
+	if x == nil {
+		return r
+	}
+	for _, e := range x.entries {
+		if count >= 0 && len(r) >= count {
+			break
+		}
+		if e.id.less(start.id) || (start.exclusive && e.id == start.id) {
+			continue
+		}
+		if end.id.less(e.id) || (end.exclusive && e.id == end.id) {
+			break
+		}
+		fields := make([]interface{}, len(e.fields))
+		for i, f := range e.fields {
+			fields[i] = f
+		}
+		r = append(r, []interface{}{e.id.String(), fields})
+	}
+	return r
+}
+
+type rangeID struct {
+	id        streamID
+	exclusive bool
+}
+
+// parseRangeID parses a bound of XRANGE. seq is used for a missing sequence.
+func parseRangeID(s string, seq uint64) (rangeID, bool) {
+	switch s {
+	case "-":
+		return rangeID{}, true
+	case "+":
+		return rangeID{id: streamID{math.MaxUint64, math.MaxUint64}}, true
+	}
+	r := rangeID{}
+	if strings.HasPrefix(s, "(") {
+		r.exclusive = true
+		s = s[1:]
+	}
+	var ok bool
+	r.id, ok = parseStreamID(s, seq)
+	return r, ok
+}
@@ -0,0 +1,295 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zset returns the sorted set at key. If create is set a missing one is created.
+func (s *Server) zset(key string, create bool) (map[string]float64, replyError) {
+	if _, ok := s.hashes[key]; ok || s.streams[key] != nil {
+		return nil, wrongType
+	}
+	z := s.zsets[key]
+	if z == nil && create {
+		z = make(map[string]float64)
+		s.zsets[key] = z
+	}
+	return z, ""
+}
+
+type zmember struct {
+	m     string
+	score float64
+}
+
+// sorted returns members of z ordered by score and then by member, like redis.
+func sorted(z map[string]float64) []zmember {
+	l := make([]zmember, 0, len(z))
+	for m, score := range z {
+		l = append(l, zmember{m, score})
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].score != l[j].score {
+			return l[i].score < l[j].score
+		}
+		return l[i].m < l[j].m
+	})
+	return l
+}
+
+// formatScore formats a score like redis does in its replies. Integers are written without an exponent.
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == math.Trunc(f) && math.Abs(f) < 1e17:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+func parseScore(v string) (float64, bool) {
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+func withScores(l []zmember, scores bool) []interface{} {
+	r := make([]interface{}, 0, len(l))
+	for _, e := range l {
+		r = append(r, e.m)
+		if scores {
+			r = append(r, formatScore(e.score))
+		}
+	}
+	return r
+}
+
+func cmdZAdd(s *Server, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return replyError("ERR syntax error")
+	}
+	scores := make([]float64, 0, len(args)/2)
+	for i := 2; i < len(args); i += 2 {
+		f, ok := parseScore(args[i])
+		if !ok {
+			return replyError("ERR value is not a valid float")
+		}
+		scores = append(scores, f)
+	}
+	z, err := s.zset(args[1], true)
+	if err != "" {
+		return err
+	}
+	n := int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := z[args[i+1]]; !ok {
+			n++
+		}
+		z[args[i+1]] = scores[i/2-1]
+	}
+	return n
+}
+
+func cmdZRem(s *Server, args []string) interface{} {
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	n := int64(0)
+	for _, m := range args[2:] {
+		if _, ok := z[m]; ok {
+			delete(z, m)
+			n++
+		}
+	}
+	if z != nil && len(z) == 0 {
+		delete(s.zsets, args[1])
+	}
+	return n
+}
+
+func cmdZScore(s *Server, args []string) interface{} {
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	if score, ok := z[args[2]]; ok {
+		return formatScore(score)
+	}
+	return nil
+}
+
+func cmdZCard(s *Server, args []string) interface{} {
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	return int64(len(z))
+}
+
+func cmdZRange(s *Server, args []string) interface{} {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		return replyError("ERR value is not an integer or out of range")
+	}
+	scores := len(args) == 5
+	if scores && !strings.EqualFold(args[4], "WITHSCORES") {
+		return replyError("ERR syntax error")
+	}
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	l := sorted(z)
+	n := len(l)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return []interface{}{}
+	}
+	return withScores(l[start:stop+1], scores)
+}
+
+// parseBound parses a ZRANGEBYSCORE bound, which is exclusive if it starts with "(".
+func parseBound(v string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(v, "(")
+	f, ok := parseScore(strings.TrimPrefix(v, "("))
+	return f, exclusive, ok
+}
+
+func cmdZRangeByScore(s *Server, args []string) interface{} {
+	return rangeByScore(s, args, false)
+}
+
+// cmdZRevRangeByScore works like ZRANGEBYSCORE with max before min, and returns members in reverse order.
+func cmdZRevRangeByScore(s *Server, args []string) interface{} {
+	return rangeByScore(s, args, true)
+}
+
+func rangeByScore(s *Server, args []string, rev bool) interface{} {
+	loArg, hiArg := args[2], args[3]
+	if rev {
+		loArg, hiArg = hiArg, loArg
+	}
+	lo, loEx, ok1 := parseBound(loArg)
+	hi, hiEx, ok2 := parseBound(hiArg)
+	if !ok1 || !ok2 {
+		return replyError("ERR min or max is not a float")
+	}
+	scores, offset, count := false, 0, -1
+	for i := 4; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "WITHSCORES"):
+			scores = true
+		case strings.EqualFold(args[i], "LIMIT") && i+2 < len(args):
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1])
+			count, err2 = strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return replyError("ERR value is not an integer or out of range")
+			}
+			i += 2
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	l := inRange(z, lo, hi, loEx, hiEx)
+	if rev {
+		for i, j := 0, len(l)-1; i < j; i, j = i+1, j-1 {
+			l[i], l[j] = l[j], l[i]
+		}
+	}
+	if offset < 0 || offset >= len(l) {
+		return []interface{}{}
+	}
+	l = l[offset:]
+	if count >= 0 && count < len(l) {
+		l = l[:count]
+	}
+	return withScores(l, scores)
+}
+
+// inRange returns members of z with a score between lo and hi, ordered like sorted.
+func inRange(z map[string]float64, lo, hi float64, loEx, hiEx bool) []zmember {
+	var l []zmember
+	for _, e := range sorted(z) {
+		if (e.score > lo || !loEx && e.score == lo) && (e.score < hi || !hiEx && e.score == hi) {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+func cmdZRemRangeByScore(s *Server, args []string) interface{} {
+	lo, loEx, ok1 := parseBound(args[2])
+	hi, hiEx, ok2 := parseBound(args[3])
+	if !ok1 || !ok2 {
+		return replyError("ERR min or max is not a float")
+	}
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	l := inRange(z, lo, hi, loEx, hiEx)
+	for _, e := range l {
+		delete(z, e.m)
+	}
+	if z != nil && len(z) == 0 {
+		delete(s.zsets, args[1])
+	}
+	return int64(len(l))
+}
+
+// cmdZScan pages through members ordered by name. The cursor is the number of members already returned,
+// so members added or removed during a scan may be missed or returned twice, which redis allows too.
+func cmdZScan(s *Server, args []string) interface{} {
+	cursor, err1 := strconv.Atoi(args[2])
+	if err1 != nil || cursor < 0 {
+		return replyError("ERR invalid cursor")
+	}
+	count := 10
+	for i := 3; i < len(args); i += 2 {
+		if !strings.EqualFold(args[i], "COUNT") || i+1 >= len(args) {
+			return replyError("ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 1 {
+			return replyError("ERR syntax error")
+		}
+		count = n
+	}
+	z, err := s.zset(args[1], false)
+	if err != "" {
+		return err
+	}
+	l := make([]zmember, 0, len(z))
+	for m, score := range z {
+		l = append(l, zmember{m, score})
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].m < l[j].m })
+	if cursor > len(l) {
+		cursor = len(l)
+	}
+	next := cursor + count
+	if next >= len(l) {
+		next = 0
+		count = len(l) - cursor
+	}
+	return []interface{}{strconv.Itoa(next), withScores(l[cursor:cursor+count], true)}
+}
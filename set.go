@@ -1,6 +1,7 @@
 package lww
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -9,12 +10,26 @@ import (
 This implementation uses maps. To avoid race condition that comes by using maps
 it is using a locking mechanism. Set is using separete Read/Write locks.
 Map data structure have a practical performance of O(1) but locking instructions might make
-this implementation sub optimal for write heavy solutions.
+this implementation sub optimal for write heavy solutions. ShardedSet is an alternative for those.
+
+If History is set, Set also keeps every timestamp written for each element, so GetAt can tell
+the timestamp an element had at a past moment.
 
 Note: Elements of set type must be usable as a hash key. Any comparable in Go type can be used.
 */
 type Set struct {
-	members map[interface{}]time.Time
+	// History keeps all timestamps of each element, not only the greatest one. It must be set before Init.
+	History bool
+	// Retention bounds the history of each element. Timestamps older than Retention before now are dropped
+	// when the element is written, except the newest of them, so GetAt is still exact within Retention.
+	// Histories of elements which are not written again are trimmed too, by any write at most every
+	// Retention/2, so no timestamp is kept much longer than Retention. If it is zero the history is kept forever.
+	Retention time.Duration
+	members   map[interface{}]time.Time
+	replicas  map[interface{}]string
+	byTime    *timeIndex
+	history   map[interface{}][]time.Time
+	trimmed   time.Time
 	sync.RWMutex
 }
 
@@ -23,13 +38,70 @@ func (s *Set) Init() {
 	s.Lock()
 	defer s.Unlock()
 	s.members = make(map[interface{}]time.Time)
+	s.replicas = nil
+	s.byTime = nil
+	s.history = nil
+	s.trimmed = time.Time{}
+	if s.History {
+		s.history = make(map[interface{}][]time.Time)
+	}
 }
 
 //Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
 func (s *Set) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t.
+func (s *Set) Update(e interface{}, t time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	s.record(e, t)
+	if val, ok := s.members[e]; ok && t.UnixNano() <= val.UnixNano() {
+		return false
+	}
+	s.members[e] = t
+	delete(s.replicas, e)
+	s.index(e, t)
+	return true
+}
+
+//SetFrom works like Set and keeps replica as the writer of the timestamp. For equal timestamps the greater replica wins.
+func (s *Set) SetFrom(e interface{}, t time.Time, replica string) {
+	s.UpdateFrom(e, t, replica)
+}
+
+//UpdateFrom works like SetFrom and returns true if the write was accepted.
+func (s *Set) UpdateFrom(e interface{}, t time.Time, replica string) bool {
 	s.Lock()
-	if val, ok := s.members[e]; !ok || t.UnixNano() > val.UnixNano() {
-		s.members[e] = t
+	defer s.Unlock()
+	s.record(e, t)
+	if val, ok := s.members[e]; ok && (t.UnixNano() < val.UnixNano() || t.UnixNano() == val.UnixNano() && replica <= s.replicas[e]) {
+		return false
+	}
+	s.members[e] = t
+	s.index(e, t)
+	if replica == "" {
+		delete(s.replicas, e)
+		return true
+	}
+	if s.replicas == nil {
+		s.replicas = make(map[interface{}]string)
+	}
+	s.replicas[e] = replica
+	return true
+}
+
+//SetMany works like calling Set for each element in order, but takes the lock only once.
+func (s *Set) SetMany(es []TimedElement) {
+	s.Lock()
+	for _, e := range es {
+		s.record(e.Element, e.Time)
+		if val, ok := s.members[e.Element]; !ok || e.Time.UnixNano() > val.UnixNano() {
+			s.members[e.Element] = e.Time
+			delete(s.replicas, e.Element)
+			s.index(e.Element, e.Time)
+		}
 	}
 	s.Unlock()
 }
@@ -49,13 +121,166 @@ func (s *Set) Get(e interface{}) (time.Time, bool) {
 	return val, ok
 }
 
+//GetFrom works like Get and also returns the replica which wrote the timestamp, or "" if it is not known.
+func (s *Set) GetFrom(e interface{}) (time.Time, string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	val, ok := s.members[e]
+	return val, s.replicas[e], ok
+}
+
+//GetMany works like calling Get for each element, but takes the lock only once.
+func (s *Set) GetMany(es []interface{}) ([]time.Time, []bool) {
+	s.RLock()
+	defer s.RUnlock()
+	ts, oks := make([]time.Time, len(es)), make([]bool, len(es))
+	for i, e := range es {
+		ts[i], oks[i] = s.members[e]
+	}
+	return ts, oks
+}
+
 //List returns list of all elements in the set
 func (s *Set) List() []interface{} {
 	s.RLock()
 	defer s.RUnlock()
-	l := make([]interface{}, 0, s.Len())
+	l := make([]interface{}, 0, len(s.members))
 	for k := range s.members {
 		l = append(l, k)
 	}
 	return l
 }
+
+// rangeChunk is how many elements Set.Range reads under the read lock before it calls f without it.
+const rangeChunk = 64
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//It reads rangeChunk elements at a time under the read lock and calls f for them after releasing it,
+//so f can read and write the same set and the set is not copied. Each element is visited at most once.
+//Elements added during the iteration may or may not be visited.
+func (s *Set) Range(f func(interface{}, time.Time) bool) {
+	var chunk [rangeChunk]TimedElement
+	n := 0
+	s.RLock()
+	for k, v := range s.members {
+		chunk[n] = TimedElement{Element: k, Time: v}
+		if n++; n < rangeChunk {
+			continue
+		}
+		s.RUnlock()
+		if !callEach(chunk[:n], f) {
+			return
+		}
+		n = 0
+		s.RLock()
+	}
+	s.RUnlock()
+	callEach(chunk[:n], f)
+}
+
+// callEach calls f for each element of es until it returns false, and returns false if it did.
+func callEach(es []TimedElement, f func(interface{}, time.Time) bool) bool {
+	for _, e := range es {
+		if !f(e.Element, e.Time) {
+			return false
+		}
+	}
+	return true
+}
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+//It uses a time index which is built with the write lock on the first call. After that writes keep it up to date,
+//so later calls only need the read lock and visit only the elements they return.
+func (s *Set) RangeByTime(from, to time.Time) []interface{} {
+	s.RLock()
+	if s.byTime == nil {
+		s.RUnlock()
+		s.Lock()
+		if s.byTime == nil {
+			s.byTime = newTimeIndex()
+			for k, v := range s.members {
+				s.byTime.set(k, v.UnixNano())
+			}
+		}
+		defer s.Unlock()
+	} else {
+		defer s.RUnlock()
+	}
+
+	var l []interface{}
+	s.byTime.ascend(from.UnixNano(), func(e interface{}, n int64) bool {
+		if !to.IsZero() && n >= to.UnixNano() {
+			return false
+		}
+		l = append(l, e)
+		return true
+	})
+	return l
+}
+
+// index updates the time index for e if it was built. The caller must hold the write lock.
+func (s *Set) index(e interface{}, t time.Time) {
+	if s.byTime != nil {
+		s.byTime.set(e, t.UnixNano())
+	}
+}
+
+//GetAt returns the greatest timestamp of the element which is not after t and true, or false if there is none.
+//Without History it only knows the current timestamp, so it returns false if that is after t.
+func (s *Set) GetAt(e interface{}, t time.Time) (time.Time, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	h, ok := s.history[e]
+	if !ok {
+		val, ok := s.members[e]
+		return val, ok && val.UnixNano() <= t.UnixNano()
+	}
+	i := sort.Search(len(h), func(i int) bool { return h[i].UnixNano() > t.UnixNano() })
+	if i == 0 {
+		return time.Time{}, false
+	}
+	return h[i-1], true
+}
+
+// record adds t to the history of e if History is set and drops what is older than Retention.
+// The history is sorted by timestamp and has no duplicates. The caller must hold the write lock.
+func (s *Set) record(e interface{}, t time.Time) {
+	if s.history == nil {
+		return
+	}
+	h := s.history[e]
+	i := sort.Search(len(h), func(i int) bool { return h[i].UnixNano() >= t.UnixNano() })
+	if i == len(h) || h[i].UnixNano() != t.UnixNano() {
+		h = append(h, time.Time{})
+		copy(h[i+1:], h[i:])
+		h[i] = t
+	}
+	s.history[e] = h
+	if s.Retention <= 0 {
+		return
+	}
+	now := time.Now()
+	cutoff := now.Add(-s.Retention).UnixNano()
+	s.history[e] = trimHistory(h, cutoff)
+	if now.Sub(s.trimmed) < s.Retention/2 {
+		return
+	}
+	s.trimmed = now
+	for k, h := range s.history {
+		h = trimHistory(h, cutoff)
+		// The only timestamp left is the current one, which GetAt also finds in members.
+		if len(h) == 1 && h[0].UnixNano() < cutoff {
+			delete(s.history, k)
+			continue
+		}
+		s.history[k] = h
+	}
+}
+
+// trimHistory drops timestamps before cutoff from a sorted history h, except the newest of them.
+func trimHistory(h []time.Time, cutoff int64) []time.Time {
+	if k := sort.Search(len(h), func(i int) bool { return h[i].UnixNano() >= cutoff }); k > 1 {
+		return append(h[:0:0], h[k-1:]...)
+	}
+	return h
+}
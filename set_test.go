@@ -88,6 +88,119 @@ func TestSet_list(t *testing.T) {
 	}
 }
 
+func TestSet_range(t *testing.T) {
+	s := Set{}
+	s.Init()
+	ts := time.Now()
+	s.Set(customType{name: "John", age: 18}, ts)
+	s.Set(customType{name: "Frank", age: 20}, ts)
+
+	n := 0
+	s.Range(func(e interface{}, t0 time.Time) bool {
+		if _, ok := e.(customType); !ok || t0 != ts {
+			t.Error("Range did not return correct member", e, t0)
+		}
+		n++
+		return true
+	})
+	if n != 2 {
+		t.Error("Range did not visit all members", n)
+	}
+
+	n = 0
+	s.Range(func(interface{}, time.Time) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Error("Range did not stop when f returned false", n)
+	}
+}
+
+func TestSet_rangeWrites(t *testing.T) {
+	// f writes to the set while Range reads it in chunks.
+	s := Set{}
+	s.Init()
+	ts := time.Now()
+	for i := 0; i < 10*rangeChunk; i++ {
+		s.Set(i, ts)
+	}
+	seen := make(map[interface{}]bool)
+	s.Range(func(e interface{}, _ time.Time) bool {
+		if seen[e] {
+			t.Error("Range visited an element twice", e)
+		}
+		seen[e] = true
+		s.Set(e, ts.Add(time.Second))
+		return true
+	})
+	if len(seen) != 10*rangeChunk {
+		t.Error("Range did not visit all members", len(seen))
+	}
+}
+
+func TestSet_rangeByTime(t *testing.T) {
+	s := Set{}
+	s.Init()
+	for i := 0; i < 10; i++ {
+		s.Set(i, time.Unix(int64(i), 0))
+	}
+
+	l := s.RangeByTime(time.Unix(3, 0), time.Unix(6, 0))
+	if len(l) != 3 || l[0] != 3 || l[2] != 5 {
+		t.Error("RangeByTime did not return correct members", l)
+	}
+
+	s.Set(0, time.Unix(20, 0))
+	s.Set(9, time.Unix(1, 0))
+	l = s.RangeByTime(time.Unix(8, 0), time.Time{})
+	if len(l) != 3 || l[0] != 8 || l[1] != 9 || l[2] != 0 {
+		t.Error("RangeByTime did not see changes after the index was built", l)
+	}
+	if l := s.RangeByTime(time.Unix(30, 0), time.Time{}); len(l) != 0 {
+		t.Error("RangeByTime returned members out of range", l)
+	}
+}
+
+func TestSet_batch(t *testing.T) {
+	s := Set{}
+	s.Init()
+	ts := time.Now()
+	s.SetMany([]TimedElement{{1, ts}, {2, ts}, {1, ts.Add(-time.Second)}, {2, ts.Add(time.Second)}})
+
+	v, ok := s.GetMany([]interface{}{1, 2, 3})
+	if !ok[0] || !v[0].Equal(ts) || !ok[1] || !v[1].Equal(ts.Add(time.Second)) || ok[2] {
+		t.Error("SetMany and GetMany did not work like Set and Get", v, ok)
+	}
+	if l := s.RangeByTime(ts.Add(time.Second), time.Time{}); len(l) != 1 || l[0] != 2 {
+		t.Error("SetMany did not reset the time index", l)
+	}
+}
+
+func TestSet_provenance(t *testing.T) {
+	s := Set{}
+	s.Init()
+	ts := time.Now()
+
+	s.SetFrom("a", ts, "b")
+	s.SetFrom("a", ts, "a")
+	if v, r, ok := s.GetFrom("a"); !ok || !v.Equal(ts) || r != "b" {
+		t.Error("Smaller replica won the tie of equal timestamps", v, r, ok)
+	}
+	s.SetFrom("a", ts.Add(-time.Second), "c")
+	if _, r, _ := s.GetFrom("a"); r != "b" {
+		t.Error("Older timestamp replaced the replica", r)
+	}
+	s.SetFrom("a", ts.Add(time.Second), "a")
+	if v, r, _ := s.GetFrom("a"); !v.Equal(ts.Add(time.Second)) || r != "a" {
+		t.Error("Newer timestamp did not replace the replica", v, r)
+	}
+	s.Set("a", ts.Add(2*time.Second))
+	if _, r, _ := s.GetFrom("a"); r != "" {
+		t.Error("Set without replica kept the old replica", r)
+	}
+}
+
 func BenchmarkSet_add_different(b *testing.B) {
 	s := Set{}
 	s.Init()
@@ -0,0 +1,154 @@
+package lww
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+// DefaultShards is the number of shards a ShardedSet uses if Shards is not set.
+const DefaultShards = 32
+
+/*ShardedSet is an in-memory TimedSet split into shards with a lock each. It is safe for concurrent use.
+It works like Set but spreads elements by their hash across a number of Sets, each with its own lock.
+Writers to different shards do not contend with each other, which makes it a better fit than Set
+for write heavy solutions with many goroutines.
+
+Len and List visit all shards one after another. They do not take a consistent snapshot
+of the whole set while other goroutines are writing to it.
+
+Note: Elements of set type must be usable as a hash key. Any comparable in Go type can be used.
+*/
+type ShardedSet struct {
+	// Shards is the number of independently locked maps. If it is zero DefaultShards will be used.
+	Shards int
+	shards []Set
+	seed   maphash.Seed
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+func (s *ShardedSet) Init() {
+	if s.Shards <= 0 {
+		s.Shards = DefaultShards
+	}
+	s.seed = maphash.MakeSeed()
+	s.shards = make([]Set, s.Shards)
+	for i := range s.shards {
+		s.shards[i].Init()
+	}
+}
+
+func (s *ShardedSet) shard(e interface{}) *Set {
+	return &s.shards[s.shardIndex(e)]
+}
+
+func (s *ShardedSet) shardIndex(e interface{}) int {
+	return int(maphash.Comparable(s.seed, e) % uint64(len(s.shards)))
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *ShardedSet) Set(e interface{}, t time.Time) {
+	s.shard(e).Set(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t.
+func (s *ShardedSet) Update(e interface{}, t time.Time) bool {
+	return s.shard(e).Update(e, t)
+}
+
+//SetMany works like calling Set for each element in order. Elements are grouped by shard,
+//so the lock of each shard is taken only once.
+func (s *ShardedSet) SetMany(es []TimedElement) {
+	groups := make([][]TimedElement, len(s.shards))
+	for _, e := range es {
+		i := s.shardIndex(e.Element)
+		groups[i] = append(groups[i], e)
+	}
+	for i := range groups {
+		if len(groups[i]) > 0 {
+			s.shards[i].SetMany(groups[i])
+		}
+	}
+}
+
+//SetFrom works like Set and keeps replica as the writer of the timestamp. For equal timestamps the greater replica wins.
+func (s *ShardedSet) SetFrom(e interface{}, t time.Time, replica string) {
+	s.shard(e).SetFrom(e, t, replica)
+}
+
+//UpdateFrom works like SetFrom and returns true if the write was accepted.
+func (s *ShardedSet) UpdateFrom(e interface{}, t time.Time, replica string) bool {
+	return s.shard(e).UpdateFrom(e, t, replica)
+}
+
+//Len must return the number of members in the set
+func (s *ShardedSet) Len() int {
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Len()
+	}
+	return n
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *ShardedSet) Get(e interface{}) (time.Time, bool) {
+	return s.shard(e).Get(e)
+}
+
+//GetFrom works like Get and also returns the replica which wrote the timestamp, or "" if it is not known.
+func (s *ShardedSet) GetFrom(e interface{}) (time.Time, string, bool) {
+	return s.shard(e).GetFrom(e)
+}
+
+//GetMany works like calling Get for each element. Elements are grouped by shard,
+//so the lock of each shard is taken only once.
+func (s *ShardedSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	ts, oks := make([]time.Time, len(es)), make([]bool, len(es))
+	groups := make([][]int, len(s.shards))
+	for i, e := range es {
+		n := s.shardIndex(e)
+		groups[n] = append(groups[n], i)
+	}
+	for n, idx := range groups {
+		if len(idx) == 0 {
+			continue
+		}
+		sh := &s.shards[n]
+		sh.RLock()
+		for _, i := range idx {
+			ts[i], oks[i] = sh.members[es[i]]
+		}
+		sh.RUnlock()
+	}
+	return ts, oks
+}
+
+//List returns list of all elements in the set
+func (s *ShardedSet) List() []interface{} {
+	l := make([]interface{}, 0, s.Len())
+	for i := range s.shards {
+		l = append(l, s.shards[i].List()...)
+	}
+	return l
+}
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//It visits the shards one after another, holding the read lock of one shard at a time.
+func (s *ShardedSet) Range(f func(interface{}, time.Time) bool) {
+	next := true
+	for i := 0; i < len(s.shards) && next; i++ {
+		s.shards[i].Range(func(e interface{}, t time.Time) bool {
+			next = f(e, t)
+			return next
+		})
+	}
+}
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+//Each shard keeps its own time index, like Set.
+func (s *ShardedSet) RangeByTime(from, to time.Time) []interface{} {
+	var l []interface{}
+	for i := range s.shards {
+		l = append(l, s.shards[i].RangeByTime(from, to)...)
+	}
+	return l
+}
@@ -0,0 +1,226 @@
+package lww
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+/*ShardedRedisSet is a TimedSet kept in redis as a number of ZSETs, one per shard.
+It spreads one logical set across a number of RedisSets by the hash of the marshalled element.
+A single ZSET with tens of millions of members becomes a hotspot, and in a redis cluster
+all of it lives on one node. The shards are separate keys, so they can be spread over the cluster.
+
+Get and Set touch only the shard of the element. Len and List aggregate all shards.
+
+The shard of an element depends on the number of shards. Use Reshard to move a set to a different
+number of shards while it is not being written to.
+*/
+type ShardedRedisSet struct {
+	// Pool provides the redis connections to be used. A *redis.Pool or a *Cluster can be used directly.
+	Pool ConnProvider
+	// SetKey is the prefix of shard keys. Shard i is stored under SetKey:i.
+	SetKey string
+	// Shards is the number of keys the set is spread across.
+	Shards int
+	// Marshal function needs to convert the element to string. Redis can only store and retrieve string values.
+	Marshal func(interface{}) string
+	// UnMarshal function needs to be able to convert a Marshalled string back to a readable structure for consumer of library.
+	UnMarshal func(string) interface{}
+	shards    []RedisSet
+	lastState
+}
+
+// ShardKey returns the key of shard i of a ShardedRedisSet with prefix key.
+func ShardKey(key string, i int) string {
+	return key + ":" + strconv.Itoa(i)
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+func (s *ShardedRedisSet) Init() {
+	if s.Shards < 1 {
+		s.checkErr(errors.New("Shards must be set"))
+		return
+	}
+	if s.SetKey == "" {
+		s.checkErr(errors.New("SetKey must be set"))
+		return
+	}
+	s.shards = make([]RedisSet, s.Shards)
+	for i := range s.shards {
+		s.shards[i] = RedisSet{Pool: s.Pool, SetKey: ShardKey(s.SetKey, i), Marshal: s.Marshal, UnMarshal: s.UnMarshal}
+		s.shards[i].Init()
+		if err := s.shards[i].LastState(); err != nil {
+			s.checkErr(err)
+			return
+		}
+	}
+	s.checkErr(nil)
+}
+
+func (s *ShardedRedisSet) shard(m string) *RedisSet {
+	return &s.shards[s.shardIndex(m)]
+}
+
+func (s *ShardedRedisSet) shardIndex(m string) int {
+	h := fnv.New32a()
+	h.Write([]byte(m))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *ShardedRedisSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t or it failed.
+func (s *ShardedRedisSet) Update(e interface{}, t time.Time) bool {
+	m := s.Marshal(e)
+	r := s.shard(m)
+	accepted := r.setMember(m, roundToMicro(t))
+	s.checkErr(r.LastState())
+	return accepted
+}
+
+//SetMany works like calling Set for each element in order. Elements are grouped by shard
+//and each group is sent in batches like RedisSet.SetMany.
+func (s *ShardedRedisSet) SetMany(es []TimedElement) {
+	ms, scores := make([][]string, len(s.shards)), make([][]int64, len(s.shards))
+	for _, e := range es {
+		m := s.Marshal(e.Element)
+		i := s.shardIndex(m)
+		ms[i], scores[i] = append(ms[i], m), append(scores[i], roundToMicro(e.Time))
+	}
+	var err error
+	for i := range s.shards {
+		if len(ms[i]) == 0 {
+			continue
+		}
+		s.shards[i].setMembers(ms[i], scores[i])
+		if e := s.shards[i].LastState(); e != nil {
+			err = e
+		}
+	}
+	s.checkErr(err)
+}
+
+//GetMany works like calling Get for each element. Elements are grouped by shard
+//and each group is sent in batches like RedisSet.GetMany.
+func (s *ShardedRedisSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	ts, oks := make([]time.Time, len(es)), make([]bool, len(es))
+	ms, idx := make([][]string, len(s.shards)), make([][]int, len(s.shards))
+	for i, e := range es {
+		m := s.Marshal(e)
+		n := s.shardIndex(m)
+		ms[n], idx[n] = append(ms[n], m), append(idx[n], i)
+	}
+	var err error
+	for n := range s.shards {
+		if len(ms[n]) == 0 {
+			continue
+		}
+		sts, soks := s.shards[n].getMembers(ms[n])
+		for j, i := range idx[n] {
+			ts[i], oks[i] = sts[j], soks[j]
+		}
+		if e := s.shards[n].LastState(); e != nil {
+			err = e
+		}
+	}
+	s.checkErr(err)
+	return ts, oks
+}
+
+//Len must return the number of members in the set
+func (s *ShardedRedisSet) Len() int {
+	n := 0
+	var err error
+	for i := range s.shards {
+		n += s.shards[i].Len()
+		if e := s.shards[i].LastState(); e != nil {
+			err = e
+		}
+	}
+	s.checkErr(err)
+	return n
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *ShardedRedisSet) Get(e interface{}) (time.Time, bool) {
+	r := s.shard(s.Marshal(e))
+	val, ok := r.Get(e)
+	s.checkErr(r.LastState())
+	return val, ok
+}
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//It scans the shards one after another.
+func (s *ShardedRedisSet) Range(f func(interface{}, time.Time) bool) {
+	next := true
+	for i := 0; i < len(s.shards) && next; i++ {
+		s.shards[i].Range(func(e interface{}, t time.Time) bool {
+			next = f(e, t)
+			return next
+		})
+		s.checkErr(s.shards[i].LastState())
+	}
+}
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+func (s *ShardedRedisSet) RangeByTime(from, to time.Time) []interface{} {
+	var l []interface{}
+	var err error
+	for i := range s.shards {
+		l = append(l, s.shards[i].RangeByTime(from, to)...)
+		if e := s.shards[i].LastState(); e != nil {
+			err = e
+		}
+	}
+	s.checkErr(err)
+	return l
+}
+
+//List returns list of all elements in the set
+func (s *ShardedRedisSet) List() []interface{} {
+	var l []interface{}
+	var err error
+	for i := range s.shards {
+		l = append(l, s.shards[i].List()...)
+		if e := s.shards[i].LastState(); e != nil {
+			err = e
+		}
+	}
+	s.checkErr(err)
+	return l
+}
+
+/*Reshard copies all members of from into to, which can have a different number of shards.
+It is meant to be run offline, while no one writes to from. Members keep their timestamps,
+and if to already has some of them the greater timestamp is kept.
+It returns the number of members it wrote, which does not count those to already had as new.
+
+Both sets must be initialized and have different SetKeys. Keys of from are left in place
+and can be deleted once the application uses to.
+*/
+func Reshard(from, to *ShardedRedisSet) (int, error) {
+	if len(from.shards) == 0 || len(to.shards) == 0 {
+		return 0, errors.New("from and to must be initialized")
+	}
+	if from.SetKey == to.SetKey {
+		return 0, errors.New("from and to must have different SetKeys")
+	}
+	n := 0
+	for i := range from.shards {
+		if err := scanZSet(from.Pool, from.shards[i].SetKey, func(m string, score int64) error {
+			r := to.shard(m)
+			if r.setMember(m, score) {
+				n++
+			}
+			return r.LastState()
+		}); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
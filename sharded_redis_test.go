@@ -0,0 +1,153 @@
+package lww
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func setupShardedRedisSet(t testing.TB, key string, shards int) *ShardedRedisSet {
+	p := newPool()
+	c := p.Get()
+	defer c.Close()
+	for i := 0; i < shards; i++ {
+		if _, err := c.Do("DEL", ShardKey(key, i)); err != nil {
+			t.Error("Can't setup redis for tests", err)
+		}
+	}
+	s := &ShardedRedisSet{Pool: p, SetKey: key, Shards: shards, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	return s
+}
+
+func TestShardedRedisSet_init(t *testing.T) {
+	s := ShardedRedisSet{Pool: newPool(), SetKey: "TESTSHARDED"}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	s = ShardedRedisSet{Pool: newPool(), SetKey: "TESTSHARDED", Shards: 4}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	if s := setupShardedRedisSet(t, "TESTSHARDED", 4); s.LastState() != nil {
+		t.Error("Error raised when all params are present and correct", s.LastState())
+	}
+}
+
+func TestShardedRedisSet(t *testing.T) {
+	s := setupShardedRedisSet(t, "TESTSHARDED", 4)
+
+	if s.Len() != 0 {
+		t.Error("New set if not empty")
+	}
+
+	ts := time.Now().Round(time.Microsecond)
+	for i := 0; i < 100; i++ {
+		s.Set(strconv.Itoa(i), ts)
+	}
+	s.Set("0", ts.Add(time.Second))
+	s.Set("0", time.Unix(1, 0))
+	if ts0, ok := s.Get("0"); !ok || !ts0.Equal(ts.Add(time.Second)) {
+		t.Error("Element with older timestamp is not ignored corretly", ts0, ok)
+	}
+	if ts0, ok := s.Get("99"); !ok || !ts0.Equal(ts) {
+		t.Error("Element is not saved correctly", ts0, ok)
+	}
+
+	if s.Len() != 100 || len(s.List()) != 100 {
+		t.Error("Len and List do not aggregate all shards", s.Len(), len(s.List()))
+	}
+	for i := range s.shards {
+		if n := s.shards[i].Len(); n == 0 || n == 100 {
+			t.Error("Elements are not spread across shards", i, n)
+		}
+	}
+}
+
+func TestShardedRedisSet_range(t *testing.T) {
+	s := setupShardedRedisSet(t, "TESTSHARDED", 4)
+	for i := 0; i < 100; i++ {
+		s.Set(strconv.Itoa(i), time.Now())
+	}
+
+	n := 0
+	s.Range(func(interface{}, time.Time) bool {
+		n++
+		return true
+	})
+	if n != 100 {
+		t.Error("Range did not visit all shards", n)
+	}
+
+	n = 0
+	s.Range(func(interface{}, time.Time) bool {
+		n++
+		return n < 50
+	})
+	if n != 50 {
+		t.Error("Range did not stop when f returned false", n)
+	}
+}
+
+func TestShardedRedisSet_batch(t *testing.T) {
+	s := setupShardedRedisSet(t, "TESTSHARDED", 4)
+	ts := time.Now().Round(time.Microsecond)
+	es := make([]TimedElement, 100)
+	keys := make([]interface{}, 100)
+	for i := range es {
+		es[i] = TimedElement{Element: strconv.Itoa(i), Time: ts.Add(time.Duration(i) * time.Second)}
+		keys[i] = strconv.Itoa(i)
+	}
+	s.SetMany(es)
+	if s.LastState() != nil || s.Len() != 100 {
+		t.Error("SetMany did not add all elements", s.LastState(), s.Len())
+	}
+
+	v, ok := s.GetMany(keys)
+	for i := range keys {
+		if !ok[i] || !v[i].Equal(ts.Add(time.Duration(i)*time.Second)) {
+			t.Error("GetMany did not return elements in order", i, v[i], ok[i])
+		}
+	}
+}
+
+func TestShardedRedisSet_Reshard(t *testing.T) {
+	from := setupShardedRedisSet(t, "TESTSHARDED", 4)
+	to := setupShardedRedisSet(t, "TESTRESHARDED", 7)
+
+	ts := time.Now().Round(time.Microsecond)
+	for i := 0; i < 50; i++ {
+		from.Set(strconv.Itoa(i), ts.Add(time.Duration(i)*time.Second))
+	}
+	if _, err := Reshard(from, from); err == nil {
+		t.Error("No error for resharding into the same keys")
+	}
+	if _, err := Reshard(from, &ShardedRedisSet{Pool: from.Pool, SetKey: "TESTRESHARDEDNOINIT", Shards: 3}); err == nil {
+		t.Error("No error for resharding into a set which is not initialized")
+	}
+	if n, err := Reshard(from, to); err != nil || n != 50 {
+		t.Fatal("Reshard failed", n, err)
+	}
+	if n, err := Reshard(from, to); err != nil || n != 0 {
+		t.Error("Reshard counted members which to already had", n, err)
+	}
+
+	if to.Len() != 50 {
+		t.Error("Not all elements are resharded", to.Len())
+	}
+	for i := 0; i < 50; i++ {
+		if ts0, ok := to.Get(strconv.Itoa(i)); !ok || !ts0.Equal(ts.Add(time.Duration(i)*time.Second)) {
+			t.Error("Element was not resharded with its timestamp", i, ts0, ok)
+		}
+	}
+	l := to.List()
+	sort.Slice(l, func(i, j int) bool { return l[i].(string) < l[j].(string) })
+	if len(l) != 50 || l[0] != "0" {
+		t.Error("List of resharded set is not correct", l)
+	}
+}
@@ -0,0 +1,169 @@
+package lww
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedSet_init(t *testing.T) {
+	s := ShardedSet{}
+	s.Init()
+	if len(s.shards) != DefaultShards || s.Shards != DefaultShards {
+		t.Error("ShardedSet is not initialized with default shards", len(s.shards))
+	}
+
+	s = ShardedSet{Shards: 3}
+	s.Init()
+	if len(s.shards) != 3 {
+		t.Error("ShardedSet is not initialized correctly", len(s.shards))
+	}
+}
+
+func TestShardedSet(t *testing.T) {
+	s := ShardedSet{Shards: 4}
+	s.Init()
+	a := customType{name: "John", age: 18}
+
+	if _, ok := s.Get(a); ok || s.Len() != 0 {
+		t.Error("After init get is finding elements")
+	}
+
+	ts := time.Now()
+	s.Set(a, ts)
+	if v, ok := s.Get(a); !ok || v != ts {
+		t.Error("Element was not added correctly", ok, v, ts)
+	}
+
+	ts = ts.Add(time.Second * 10)
+	s.Set(a, ts)
+	s.Set(a, time.Unix(1, 0))
+	if v, ok := s.Get(a); !ok || v != ts {
+		t.Error("Element with older timestamp is not ignored corretly", ok, v, ts)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Set(i, ts)
+	}
+	if s.Len() != 101 {
+		t.Error("len is wrong after add", s.Len())
+	}
+	seen := make(map[interface{}]bool)
+	for _, e := range s.List() {
+		seen[e] = true
+	}
+	if len(seen) != 101 || !seen[a] || !seen[99] {
+		t.Error("list did not return all members", len(seen))
+	}
+}
+
+func TestShardedSet_range(t *testing.T) {
+	s := ShardedSet{Shards: 4}
+	s.Init()
+	for i := 0; i < 100; i++ {
+		s.Set(i, time.Unix(int64(i), 0))
+	}
+
+	seen := make(map[interface{}]bool)
+	s.Range(func(e interface{}, t0 time.Time) bool {
+		if t0 != time.Unix(int64(e.(int)), 0) {
+			t.Error("Range did not return correct timestamp", e, t0)
+		}
+		seen[e] = true
+		return true
+	})
+	if len(seen) != 100 {
+		t.Error("Range did not visit all members", len(seen))
+	}
+
+	n := 0
+	s.Range(func(interface{}, time.Time) bool {
+		n++
+		return n < 30
+	})
+	if n != 30 {
+		t.Error("Range did not stop when f returned false", n)
+	}
+}
+
+func TestShardedSet_rangeByTime(t *testing.T) {
+	s := ShardedSet{Shards: 4}
+	s.Init()
+	for i := 0; i < 100; i++ {
+		s.Set(i, time.Unix(int64(i), 0))
+	}
+	if l := s.RangeByTime(time.Unix(10, 0), time.Unix(20, 0)); len(l) != 10 {
+		t.Error("RangeByTime did not aggregate shards", l)
+	}
+}
+
+func TestShardedSet_batch(t *testing.T) {
+	s := ShardedSet{Shards: 4}
+	s.Init()
+	es := make([]TimedElement, 100)
+	keys := make([]interface{}, 101)
+	for i := range es {
+		es[i] = TimedElement{Element: i, Time: time.Unix(int64(i), 0)}
+		keys[i] = i
+	}
+	keys[100] = 100
+	s.SetMany(es)
+
+	v, ok := s.GetMany(keys)
+	for i := 0; i < 100; i++ {
+		if !ok[i] || !v[i].Equal(time.Unix(int64(i), 0)) {
+			t.Error("GetMany did not return elements in order", i, v[i], ok[i])
+		}
+	}
+	if ok[100] || s.Len() != 100 {
+		t.Error("GetMany found an element which was not set", s.Len())
+	}
+}
+
+func TestShardedSet_concurrent(t *testing.T) {
+	s := ShardedSet{}
+	s.Init()
+	base := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Set(i, base.Add(time.Duration(w)*time.Second))
+				s.Get(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if s.Len() != 1000 {
+		t.Error("len is wrong after concurrent add", s.Len())
+	}
+	if v, _ := s.Get(500); v != base.Add(7*time.Second) {
+		t.Error("concurrent writers did not keep the greatest timestamp", v)
+	}
+}
+
+func BenchmarkShardedSet_add_different(b *testing.B) {
+	s := ShardedSet{}
+	s.Init()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Set(i, time.Now())
+	}
+}
+
+func BenchmarkShardedSet_get(b *testing.B) {
+	s := ShardedSet{}
+	s.Init()
+	for i := 0; i < b.N; i++ {
+		s.Set(i, time.Now())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Get(i)
+	}
+}
@@ -0,0 +1,33 @@
+package sim
+
+import "time"
+
+// event is a function to call at a virtual time. seq orders events of the same time by when they were scheduled.
+type event struct {
+	at  time.Duration
+	seq int
+	f   func()
+}
+
+// events is a heap of events, earliest first.
+type events []event
+
+func (h events) Len() int { return len(h) }
+
+func (h events) Less(i, j int) bool {
+	if h[i].at != h[j].at {
+		return h[i].at < h[j].at
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h events) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *events) Push(x interface{}) { *h = append(*h, x.(event)) }
+
+func (h *events) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
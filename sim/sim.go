@@ -0,0 +1,293 @@
+/*
+Package sim runs many LWW replicas in one process over a simulated network, to test replication.
+
+Time is virtual. Network keeps a queue of events ordered by their virtual time and Run processes them one at a time,
+so a run does not depend on the scheduler and does not sleep. Every random choice comes from one generator
+seeded by Config.Seed, which makes a run with the same seed and the same calls repeat exactly.
+
+Each Add or Remove on a replica is applied locally and sent to every other replica as a message. Messages
+take Latency plus a random Jitter, which reorders them, and can be dropped or duplicated. Partition splits replicas
+into groups which can't reach each other until Heal. As dropped messages are lost, replicas which should converge
+need AntiEntropy: each replica then sends its whole state to a random peer, which merges it with LWW.Merge.
+
+  n := sim.New(sim.Config{Replicas: 3, Seed: 1, Latency: 10 * time.Millisecond, DropRate: 0.2, AntiEntropy: time.Second})
+  n.Partition([]int{0}, []int{1, 2})
+  n.Add(0, "x")
+  n.Remove(1, "x")
+  n.Run(time.Minute)
+  if err := n.Settle(time.Minute); err != nil {
+  	log.Fatal(err)
+  }
+
+Replicas can use any TimedSet through Config.NewSet.
+*/
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/kavehmz/lww"
+)
+
+// Config describes the replicas and the network of a simulation.
+type Config struct {
+	// Replicas is the number of replicas. If it is zero 3 replicas are used.
+	Replicas int
+	// Seed of the random generator which decides delays, drops, duplicates and anti-entropy peers.
+	Seed int64
+	// Latency is the minimum delay of a message.
+	Latency time.Duration
+	// Jitter is the maximum random delay added to Latency. Messages with different delays arrive out of order.
+	Jitter time.Duration
+	// DropRate is the probability that a message is lost.
+	DropRate float64
+	// DuplicateRate is the probability that a message is delivered twice, each time with its own delay.
+	DuplicateRate float64
+	// ClockSkew is the maximum offset of the clock of each replica from the virtual time, in either direction.
+	ClockSkew time.Duration
+	// AntiEntropy is how often each replica sends its whole state to a random peer. If it is zero they never do.
+	AntiEntropy time.Duration
+	// NewSet returns a new set for a replica. Each call must return a set which does not share elements with others.
+	// By default it returns a lww.Set.
+	NewSet func() lww.TimedSet
+	// Start is the wall time of the virtual time zero. By default it is the start of 2020 in UTC.
+	Start time.Time
+}
+
+// Stats counts messages of a simulation.
+type Stats struct {
+	Sent       int
+	Delivered  int
+	Dropped    int
+	Duplicated int
+	// Partitioned is the number of messages lost because their sender and receiver were in different groups.
+	Partitioned int
+}
+
+// Network is a simulation of replicas and the network between them.
+type Network struct {
+	c        Config
+	rand     *rand.Rand
+	now      time.Duration
+	events   events
+	seq      int
+	replicas []*lww.LWW
+	skew     []time.Duration
+	group    []int
+	elements []interface{}
+	seen     map[interface{}]bool
+	stats    Stats
+}
+
+// New returns a network of c.Replicas new replicas. Replica i has ReplicaID "r<i>".
+func New(c Config) *Network {
+	if c.Replicas <= 0 {
+		c.Replicas = 3
+	}
+	if c.NewSet == nil {
+		c.NewSet = func() lww.TimedSet { return &lww.Set{} }
+	}
+	if c.Start.IsZero() {
+		c.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	n := &Network{c: c, rand: rand.New(rand.NewSource(c.Seed)), seen: make(map[interface{}]bool)}
+	n.group = make([]int, c.Replicas)
+	for i := 0; i < c.Replicas; i++ {
+		l := &lww.LWW{AddSet: c.NewSet(), RemoveSet: c.NewSet(), ReplicaID: replicaID(i)}
+		l.Init()
+		n.replicas = append(n.replicas, l)
+		var skew time.Duration
+		if c.ClockSkew > 0 {
+			skew = time.Duration(n.rand.Int63n(2*int64(c.ClockSkew)+1)) - c.ClockSkew
+		}
+		n.skew = append(n.skew, skew)
+	}
+	if c.AntiEntropy > 0 {
+		n.After(c.AntiEntropy, n.antiEntropy)
+	}
+	return n
+}
+
+func replicaID(i int) string {
+	return fmt.Sprintf("r%d", i)
+}
+
+// Replica returns the LWW of replica i. Changes made on it directly are not sent to other replicas.
+func (n *Network) Replica(i int) *lww.LWW {
+	return n.replicas[i]
+}
+
+// Now returns the virtual time since the start of the simulation.
+func (n *Network) Now() time.Duration {
+	return n.now
+}
+
+// Clock returns the wall time on the clock of replica i, which is the timestamp its writes get.
+func (n *Network) Clock(i int) time.Time {
+	return n.c.Start.Add(n.now + n.skew[i])
+}
+
+// Stats returns the counts of messages so far.
+func (n *Network) Stats() Stats {
+	return n.stats
+}
+
+// After calls f when the virtual time is d later than now, for example to write in the middle of a Run.
+func (n *Network) After(d time.Duration, f func()) {
+	n.seq++
+	heap.Push(&n.events, event{at: n.now + d, seq: n.seq, f: f})
+}
+
+// Add adds e on replica i at the time of its clock and sends the add to the other replicas.
+func (n *Network) Add(i int, e interface{}) {
+	n.write(i, e, false)
+}
+
+// Remove removes e on replica i at the time of its clock and sends the remove to the other replicas.
+func (n *Network) Remove(i int, e interface{}) {
+	n.write(i, e, true)
+}
+
+func (n *Network) write(i int, e interface{}, remove bool) {
+	if !n.seen[e] {
+		n.seen[e] = true
+		n.elements = append(n.elements, e)
+	}
+	t := n.Clock(i)
+	apply := func(to int) {
+		// The receiver writes as the sender, so sets which keep provenance keep the original writer.
+		w := *n.replicas[to]
+		w.ReplicaID = replicaID(i)
+		if remove {
+			w.Remove(e, t)
+			return
+		}
+		w.Add(e, t)
+	}
+	apply(i)
+	for to := range n.replicas {
+		if to != i {
+			n.send(i, to, func() { apply(to) })
+		}
+	}
+}
+
+// send schedules deliver as a message from one replica to another, subject to the faults of the network.
+func (n *Network) send(from, to int, deliver func()) {
+	n.stats.Sent++
+	if n.group[from] != n.group[to] {
+		n.stats.Partitioned++
+		return
+	}
+	if n.rand.Float64() < n.c.DropRate {
+		n.stats.Dropped++
+		return
+	}
+	copies := 1
+	if n.rand.Float64() < n.c.DuplicateRate {
+		n.stats.Duplicated++
+		copies = 2
+	}
+	for ; copies > 0; copies-- {
+		d := n.c.Latency
+		if n.c.Jitter > 0 {
+			d += time.Duration(n.rand.Int63n(int64(n.c.Jitter) + 1))
+		}
+		n.After(d, func() {
+			// Messages in flight are lost if a partition starts before they arrive.
+			if n.group[from] != n.group[to] {
+				n.stats.Partitioned++
+				return
+			}
+			n.stats.Delivered++
+			deliver()
+		})
+	}
+}
+
+// antiEntropy sends the state of each replica to a random peer and schedules itself again.
+func (n *Network) antiEntropy() {
+	if len(n.replicas) > 1 {
+		for i := range n.replicas {
+			to := n.rand.Intn(len(n.replicas) - 1)
+			if to >= i {
+				to++
+			}
+			n.sendState(i, to)
+		}
+	}
+	n.After(n.c.AntiEntropy, n.antiEntropy)
+}
+
+// sendState sends a copy of the state of a replica as it is now, which the receiver merges.
+func (n *Network) sendState(from, to int) {
+	state := &lww.LWW{}
+	state.Init()
+	state.Merge(n.replicas[from])
+	n.send(from, to, func() { n.replicas[to].Merge(state) })
+}
+
+// Partition splits replicas into groups. Messages between replicas of different groups are lost,
+// including those already in flight. Replicas which are not in any group form one more group.
+func (n *Network) Partition(groups ...[]int) {
+	for i := range n.group {
+		n.group[i] = 0
+	}
+	for g, l := range groups {
+		for _, i := range l {
+			n.group[i] = g + 1
+		}
+	}
+}
+
+// Heal ends a partition.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Run processes events until the virtual time is d later than now.
+func (n *Network) Run(d time.Duration) {
+	n.runUntil(n.now+d, func() bool { return false })
+}
+
+// runUntil processes events up to the virtual time end or until done returns true after an event.
+func (n *Network) runUntil(end time.Duration, done func() bool) bool {
+	for n.events.Len() > 0 && n.events[0].at <= end {
+		e := heap.Pop(&n.events).(event)
+		n.now = e.at
+		e.f()
+		if done() {
+			return true
+		}
+	}
+	n.now = end
+	return done()
+}
+
+// Settle heals the network and runs it until all replicas converge, for at most d of virtual time.
+// It returns the difference Check finds if they do not converge in time.
+func (n *Network) Settle(d time.Duration) error {
+	n.Heal()
+	if n.runUntil(n.now+d, func() bool { return n.Check() == nil }) {
+		return nil
+	}
+	return n.Check()
+}
+
+// Check returns an error which describes the first difference between replicas, or nil if they all
+// have the same state for every element written so far.
+func (n *Network) Check() error {
+	for _, e := range n.elements {
+		want := n.replicas[0].State(e)
+		for i, l := range n.replicas[1:] {
+			st := l.State(e)
+			if st.Exists != want.Exists || !st.Added.Equal(want.Added) || !st.Removed.Equal(want.Removed) || st.AddedBy != want.AddedBy || st.RemovedBy != want.RemovedBy {
+				return fmt.Errorf("sim: %s and r0 differ on %v: %+v and %+v", replicaID(i+1), e, st, want)
+			}
+		}
+	}
+	return nil
+}
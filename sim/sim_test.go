@@ -0,0 +1,166 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/kavehmz/lww"
+	"github.com/kavehmz/lww/redistest"
+)
+
+// workload writes a few elements on random replicas over a minute of virtual time and then runs the network for it.
+func workload(n *Network, writes int) {
+	for k := 0; k < writes; k++ {
+		i, e, remove := n.rand.Intn(len(n.replicas)), "e"+strconv.Itoa(n.rand.Intn(5)), n.rand.Intn(3) == 0
+		n.After(time.Duration(n.rand.Int63n(int64(time.Minute))), func() {
+			if remove {
+				n.Remove(i, e)
+				return
+			}
+			n.Add(i, e)
+		})
+	}
+	n.Run(time.Minute)
+}
+
+func faulty(seed int64) Config {
+	return Config{
+		Replicas:      5,
+		Seed:          seed,
+		Latency:       5 * time.Millisecond,
+		Jitter:        50 * time.Millisecond,
+		DropRate:      0.3,
+		DuplicateRate: 0.2,
+		ClockSkew:     20 * time.Millisecond,
+		AntiEntropy:   time.Second,
+	}
+}
+
+func TestNetwork_converges(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		n := New(faulty(seed))
+		n.Partition([]int{0, 1}, []int{2, 3})
+		workload(n, 50)
+		if err := n.Settle(time.Minute); err != nil {
+			t.Fatal("Replicas did not converge after the network healed", seed, err, n.Stats())
+		}
+		if st := n.Stats(); st.Dropped == 0 || st.Duplicated == 0 || st.Partitioned == 0 {
+			t.Error("Faults were not simulated", st)
+		}
+	}
+}
+
+func TestNetwork_deterministic(t *testing.T) {
+	run := func(seed int64) string {
+		n := New(faulty(seed))
+		workload(n, 50)
+		r := fmt.Sprint(n.Stats())
+		for _, e := range n.elements {
+			r += fmt.Sprint(n.Replica(0).State(e), n.Replica(4).State(e))
+		}
+		return r
+	}
+	if run(7) != run(7) {
+		t.Error("Two runs with the same seed are different")
+	}
+	if run(7) == run(8) {
+		t.Error("Two runs with different seeds are the same")
+	}
+}
+
+func TestNetwork_partition(t *testing.T) {
+	n := New(Config{Replicas: 3, Latency: time.Millisecond})
+	n.Partition([]int{0}, []int{1, 2})
+	n.Add(0, "x")
+	n.Run(time.Second)
+	n.Add(1, "y")
+	n.Run(time.Second)
+	if !n.Replica(0).Exists("x") || n.Replica(1).Exists("x") || n.Replica(0).Exists("y") || !n.Replica(2).Exists("y") {
+		t.Error("Partition did not split replicas", n.Replica(0).Get(), n.Replica(1).Get(), n.Replica(2).Get())
+	}
+	if n.Check() == nil {
+		t.Error("Check did not find the difference between partitions")
+	}
+
+	// Without anti-entropy writes lost in the partition never arrive.
+	if err := n.Settle(time.Minute); err == nil {
+		t.Error("Replicas converged without anti-entropy")
+	}
+	n.Remove(2, "y")
+	n.Run(time.Second)
+	if n.Replica(0).Exists("y") || n.Replica(1).Exists("y") {
+		t.Error("Writes after Heal were not delivered")
+	}
+}
+
+func TestNetwork_clockSkew(t *testing.T) {
+	n := New(Config{Replicas: 2, Latency: time.Millisecond, ClockSkew: time.Second, AntiEntropy: time.Second})
+	d := n.Clock(1).Sub(n.Clock(0))
+	if d < -2*time.Second || d > 2*time.Second {
+		t.Error("Clocks are skewed more than ClockSkew allows", d)
+	}
+	n.Add(0, "x")
+	n.Remove(1, "x")
+	if err := n.Settle(time.Minute); err != nil {
+		t.Error(err)
+	}
+	// The replica with the later clock wins, whatever the real order of writes.
+	if exists := n.Replica(0).Exists("x"); exists != (d < 0) {
+		t.Error("Skewed clocks did not decide the winner", d, exists)
+	}
+}
+
+func TestNetwork_timedSets(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal("Can't start redis for tests", err)
+	}
+	defer s.Close()
+	pool := &redis.Pool{Dial: s.Dial}
+	keys := 0
+	for name, newSet := range map[string]func() lww.TimedSet{
+		"ShardedSet": func() lww.TimedSet { return &lww.ShardedSet{} },
+		"AtomicSet":  func() lww.TimedSet { return &lww.AtomicSet{} },
+		"RedisSet": func() lww.TimedSet {
+			keys++
+			return &lww.RedisSet{Pool: pool, SetKey: "sim" + strconv.Itoa(keys), Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+		},
+	} {
+		c := faulty(1)
+		c.NewSet = newSet
+		n := New(c)
+		n.Partition([]int{0, 1, 2})
+		workload(n, 30)
+		if err := n.Settle(time.Minute); err != nil {
+			t.Error("Replicas did not converge", name, err)
+		}
+	}
+}
+
+func BenchmarkNetwork(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		n := New(faulty(int64(i)))
+		workload(n, 50)
+		n.Settle(time.Minute)
+	}
+}
+
+func Example() {
+	n := New(Config{Replicas: 3, Seed: 1, Latency: 10 * time.Millisecond, Jitter: 20 * time.Millisecond, DropRate: 0.5, AntiEntropy: time.Second})
+	n.Partition([]int{0}, []int{1, 2})
+	n.Add(0, "x")
+	n.Run(time.Millisecond)
+	n.Remove(1, "x")
+	n.Run(time.Minute)
+	fmt.Println(n.Replica(0).Exists("x"), n.Replica(2).Exists("x"))
+
+	fmt.Println(n.Settle(time.Minute))
+	fmt.Println(n.Replica(0).Exists("x"), n.Replica(2).Exists("x"))
+	// Output:
+	// true false
+	// <nil>
+	// false false
+}
@@ -0,0 +1,244 @@
+package lww
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*SQLSet is a TimedSet kept in a SQL table, so it persists and can be shared by processes using the same database.
+This implementation uses a database/sql table with an element column as primary key and a ts column
+that keeps the timestamp in UnixNano.
+Set is a single upsert that only overwrites the stored timestamp if the new one is greater:
+
+  INSERT INTO t (element, ts) VALUES ($1, $2)
+  ON CONFLICT (element) DO UPDATE SET ts = excluded.ts WHERE excluded.ts > t.ts
+
+This syntax is understood by both PostgreSQL (9.5+) and SQLite (3.24+).
+Table is used verbatim in the queries so it must be a plain trusted identifier.
+*/
+type SQLSet struct {
+	// DB is the database to be used. It can be shared between several SQLSets.
+	DB *sql.DB
+	// Table sets which table will be used for the set. Init creates it if it does not exist.
+	Table string
+	// Marshal function needs to convert the element to string. It will be stored in element column.
+	Marshal func(interface{}) string
+	// UnMarshal function needs to be able to convert a Marshalled string back to a readable structure for consumer of library.
+	UnMarshal func(string) interface{}
+	upsert string
+	lastState
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+//It creates the table and an index on its ts column if they do not exist.
+func (s *SQLSet) Init() {
+	if s.DB == nil {
+		s.checkErr(errors.New("DB must be set"))
+		return
+	}
+	if s.Marshal == nil {
+		s.checkErr(errors.New("Marshal must be set"))
+		return
+	}
+	if s.UnMarshal == nil {
+		s.checkErr(errors.New("UnMarshal must be set"))
+		return
+	}
+	if s.Table == "" {
+		s.checkErr(errors.New("Table must be set"))
+		return
+	}
+
+	s.upsert = fmt.Sprintf("INSERT INTO %[1]s (element, ts) VALUES ($1, $2) ON CONFLICT (element) DO UPDATE SET ts = excluded.ts WHERE excluded.ts > %[1]s.ts", s.Table)
+	_, err := s.DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (element TEXT PRIMARY KEY, ts BIGINT NOT NULL)", s.Table))
+	if err == nil {
+		_, err = s.DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_ts ON %[1]s (ts)", s.Table))
+	}
+	s.checkErr(err)
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *SQLSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted, false if the set already had a timestamp as new as t or it failed.
+//The upsert changes no row if the stored timestamp is not older.
+func (s *SQLSet) Update(e interface{}, t time.Time) bool {
+	r, err := s.DB.Exec(s.upsert, s.Marshal(e), t.UnixNano())
+	var n int64
+	if err == nil {
+		n, err = r.RowsAffected()
+	}
+	s.checkErr(err)
+	return err == nil && n > 0
+}
+
+//SetMany works like calling Set for each element in order, but in a single transaction with a prepared upsert.
+func (s *SQLSet) SetMany(es []TimedElement) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		s.checkErr(err)
+		return
+	}
+	stmt, err := tx.Prepare(s.upsert)
+	if err != nil {
+		tx.Rollback()
+		s.checkErr(err)
+		return
+	}
+	defer stmt.Close()
+	for _, e := range es {
+		if _, err := stmt.Exec(s.Marshal(e.Element), e.Time.UnixNano()); err != nil {
+			tx.Rollback()
+			s.checkErr(err)
+			return
+		}
+	}
+	s.checkErr(tx.Commit())
+}
+
+//Len must return the number of members in the set
+func (s *SQLSet) Len() int {
+	var n int
+	s.checkErr(s.DB.QueryRow("SELECT COUNT(*) FROM " + s.Table).Scan(&n))
+	return n
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *SQLSet) Get(e interface{}) (time.Time, bool) {
+	var n int64
+	err := s.DB.QueryRow("SELECT ts FROM "+s.Table+" WHERE element = $1", s.Marshal(e)).Scan(&n)
+	if err == sql.ErrNoRows {
+		s.checkErr(nil)
+		return time.Time{}, false
+	}
+	s.checkErr(err)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+//GetMany works like calling Get for each element, but with a single prepared query.
+func (s *SQLSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	ts, oks := make([]time.Time, len(es)), make([]bool, len(es))
+	stmt, err := s.DB.Prepare("SELECT ts FROM " + s.Table + " WHERE element = $1")
+	if err != nil {
+		s.checkErr(err)
+		return ts, oks
+	}
+	defer stmt.Close()
+	for i, e := range es {
+		var n int64
+		err := stmt.QueryRow(s.Marshal(e)).Scan(&n)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			s.checkErr(err)
+			return ts, oks
+		}
+		ts[i], oks[i] = time.Unix(0, n), true
+	}
+	s.checkErr(nil)
+	return ts, oks
+}
+
+//List returns list of all elements in the set
+func (s *SQLSet) List() []interface{} {
+	var l []interface{}
+	rows, err := s.DB.Query("SELECT element FROM " + s.Table)
+	if err != nil {
+		s.checkErr(err)
+		return l
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			s.checkErr(err)
+			return l
+		}
+		l = append(l, s.UnMarshal(v))
+	}
+	s.checkErr(rows.Err())
+	return l
+}
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+//It uses the index Init creates on the ts column.
+func (s *SQLSet) RangeByTime(from, to time.Time) []interface{} {
+	q, args := "SELECT element FROM "+s.Table+" WHERE ts >= $1", []interface{}{from.UnixNano()}
+	if !to.IsZero() {
+		q, args = q+" AND ts < $2", append(args, to.UnixNano())
+	}
+	var l []interface{}
+	rows, err := s.DB.Query(q, args...)
+	if err != nil {
+		s.checkErr(err)
+		return l
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			s.checkErr(err)
+			return l
+		}
+		l = append(l, s.UnMarshal(v))
+	}
+	s.checkErr(rows.Err())
+	return l
+}
+
+// sqlRangePage is how many rows SQLSet.Range reads in one query.
+var sqlRangePage = 1000
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//It reads the table in pages ordered by element and calls f after each page is read,
+//so f can use other sets of the same DB even if it has a single connection.
+func (s *SQLSet) Range(f func(interface{}, time.Time) bool) {
+	var after *string
+	for {
+		keys, vals, err := s.rangePage(after)
+		s.checkErr(err)
+		if err != nil || len(keys) == 0 {
+			return
+		}
+		for i := range keys {
+			if !f(s.UnMarshal(keys[i]), time.Unix(0, vals[i])) {
+				return
+			}
+		}
+		after = &keys[len(keys)-1]
+	}
+}
+
+// rangePage reads the next page of elements after the given one, or the first page if after is nil.
+func (s *SQLSet) rangePage(after *string) ([]string, []int64, error) {
+	q, args := "SELECT element, ts FROM "+s.Table, []interface{}{}
+	if after != nil {
+		q, args = q+" WHERE element > $1", append(args, *after)
+	}
+	rows, err := s.DB.Query(q+" ORDER BY element LIMIT "+strconv.Itoa(sqlRangePage), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	var vals []int64
+	for rows.Next() {
+		var k string
+		var v int64
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, k)
+		vals = append(vals, v)
+	}
+	return keys, vals, rows.Err()
+}
@@ -0,0 +1,193 @@
+package lww
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t testing.TB) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lww.db"))
+	if err != nil {
+		t.Fatal("Can't open sqlite for tests", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func setupSQLSet(db *sql.DB, table string) SQLSet {
+	s := SQLSet{DB: db, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }, Table: table}
+	s.Init()
+	return s
+}
+
+func TestSQLSet_init(t *testing.T) {
+	db := openSQLite(t)
+	s := SQLSet{}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	s = SQLSet{DB: db, Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	if s.LastState() == nil {
+		t.Error("No error for missing params")
+	}
+
+	s = setupSQLSet(db, "lww_add")
+	if s.LastState() != nil {
+		t.Error("Error raised when all params are present and correct", s.LastState())
+	}
+	s = setupSQLSet(db, "lww_add")
+	if s.LastState() != nil {
+		t.Error("Init is not idempotent", s.LastState())
+	}
+}
+
+func TestSQLSet(t *testing.T) {
+	s := setupSQLSet(openSQLite(t), "lww_add")
+
+	if s.Len() != 0 {
+		t.Error("New set if not empty")
+	}
+	if _, ok := s.Get("data"); ok || s.LastState() != nil {
+		t.Error("Get is finding elements in an empty set", s.LastState())
+	}
+
+	a := "data"
+	ts := time.Now()
+	s.Set(a, ts)
+	if s.Len() != 1 {
+		t.Error("Adding element to set failed", s.LastState())
+	}
+	if ts0, ok := s.Get(a); !ok || !ts0.Equal(ts) {
+		t.Error("interface{} is not saved corretly", ts0, ok, ts)
+	}
+
+	ts = ts.Add(time.Second * 10)
+	s.Set(a, ts)
+	if ts0, ok := s.Get(a); !ok || !ts0.Equal(ts) {
+		t.Error("interface{} is not updated corretly")
+	}
+
+	s.Set(a, time.Unix(1, 0))
+	if ts0, ok := s.Get(a); !ok || !ts0.Equal(ts) {
+		t.Error("interface{} with older timestamp is not ignored corretly")
+	}
+
+	s.Set("new data", ts)
+	l := s.List()
+	if len(l) != 2 {
+		t.Error("List is not returning all elements of the set correctly")
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].(string) < l[j].(string) })
+	if l[0] != "data" || l[1] != "new data" {
+		t.Error("List elements are not correct", l)
+	}
+}
+
+func TestSQLSet_range(t *testing.T) {
+	defer func(n int) { sqlRangePage = n }(sqlRangePage)
+	sqlRangePage = 3
+	db := openSQLite(t)
+	add := setupSQLSet(db, "lww_add")
+	remove := setupSQLSet(db, "lww_remove")
+	add.Set("", time.Unix(100, 0))
+	for i := 0; i < 10; i++ {
+		add.Set(strconv.Itoa(i), time.Unix(int64(i), 0))
+	}
+	remove.Set("5", time.Unix(6, 0))
+
+	var l []interface{}
+	add.Range(func(e interface{}, t0 time.Time) bool {
+		l = append(l, e)
+		return true
+	})
+	if len(l) != 11 || l[0] != "" || l[10] != "9" || add.LastState() != nil {
+		t.Error("Range did not visit all members across pages", l, add.LastState())
+	}
+
+	lww := LWW{AddSet: &add, RemoveSet: &remove}
+	if g := lww.Get(); len(g) != 10 {
+		t.Error("LWW over SQLSets did not stream existing elements", g)
+	}
+}
+
+func TestSQLSet_rangeByTime(t *testing.T) {
+	s := setupSQLSet(openSQLite(t), "lww_add")
+	for i := 0; i < 10; i++ {
+		s.Set(strconv.Itoa(i), time.Unix(int64(i), 0))
+	}
+
+	l := s.RangeByTime(time.Unix(3, 0), time.Unix(6, 0))
+	sort.Slice(l, func(i, j int) bool { return l[i].(string) < l[j].(string) })
+	if len(l) != 3 || l[0] != "3" || l[2] != "5" {
+		t.Error("RangeByTime did not return correct members", l, s.LastState())
+	}
+	if l := s.RangeByTime(time.Unix(8, 0), time.Time{}); len(l) != 2 {
+		t.Error("RangeByTime without upper bound did not return correct members", l)
+	}
+}
+
+func TestSQLSet_batch(t *testing.T) {
+	s := setupSQLSet(openSQLite(t), "lww_add")
+	ts := time.Now()
+	s.SetMany([]TimedElement{{"a", ts}, {"b", ts}, {"a", ts.Add(-time.Second)}, {"b", ts.Add(time.Second)}})
+
+	v, ok := s.GetMany([]interface{}{"a", "b", "c"})
+	if s.LastState() != nil || !ok[0] || !v[0].Equal(ts) || !ok[1] || !v[1].Equal(ts.Add(time.Second)) || ok[2] {
+		t.Error("SetMany and GetMany did not work like Set and Get", v, ok, s.LastState())
+	}
+}
+
+func TestSQLSet_tables(t *testing.T) {
+	db := openSQLite(t)
+	add := setupSQLSet(db, "lww_add")
+	remove := setupSQLSet(db, "lww_remove")
+
+	l := LWW{AddSet: &add, RemoveSet: &remove}
+	l.Init()
+	l.Add("e", time.Unix(10, 0))
+	l.Remove("e", time.Unix(20, 0))
+	l.Add("f", time.Unix(10, 0))
+	if l.Exists("e") || !l.Exists("f") {
+		t.Error("LWW over SQLSet is not working correctly")
+	}
+	if add.Len() != 2 || remove.Len() != 1 {
+		t.Error("add and remove sets are not stored in separate tables", add.Len(), remove.Len())
+	}
+}
+
+func BenchmarkSQLSet_add_different(b *testing.B) {
+	s := setupSQLSet(openSQLite(b), "lww_add")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		s.Set(strconv.Itoa(i), time.Now())
+	}
+}
+
+func ExampleSQLSet() {
+	db, _ := sql.Open("sqlite", ":memory:")
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	s := SQLSet{DB: db, Table: "lww_add", Marshal: func(e interface{}) string { return e.(string) }, UnMarshal: func(e string) interface{} { return e }}
+	s.Init()
+	s.Set("Data", time.Unix(1451606400, 0))
+	s.Set("Data", time.Unix(1, 0))
+	ts, ok := s.Get("Data")
+	fmt.Println(ok)
+	fmt.Println(ts.Unix())
+	// Output:
+	// true
+	// 1451606400
+}
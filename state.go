@@ -0,0 +1,25 @@
+package lww
+
+import "sync/atomic"
+
+// lastState keeps the error state of the last operation of a set. It is safe for concurrent use.
+type lastState struct {
+	v atomic.Value
+}
+
+type errState struct {
+	err error
+}
+
+func (s *lastState) checkErr(err error) {
+	s.v.Store(errState{err})
+}
+
+// LastState returns the error state of the last executed operation. Nil means it succeeded.
+// As TimedSet methods do not return errors this can be used after each call to know the last state.
+func (s *lastState) LastState() error {
+	if v, ok := s.v.Load().(errState); ok {
+		return v.err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package lww
+
+// timeIndexMaxLevel bounds the levels of timeIndex, which is enough for far more elements than fit in memory.
+const timeIndexMaxLevel = 32
+
+/*timeIndex is the time index of Set. It is a skip list of elements ordered by their timestamp,
+so it is kept up to date on each write in O(log n) and read in order from any timestamp.
+Elements with the same timestamp are ordered by when they were indexed. It is not safe for concurrent use.
+*/
+type timeIndex struct {
+	head  timeNode
+	level int
+	nodes map[interface{}]*timeNode
+	seq   uint64
+	rnd   uint64
+}
+
+type timeNode struct {
+	n    int64
+	seq  uint64
+	e    interface{}
+	next []*timeNode
+}
+
+func newTimeIndex() *timeIndex {
+	return &timeIndex{head: timeNode{next: make([]*timeNode, timeIndexMaxLevel)}, level: 1, nodes: make(map[interface{}]*timeNode), rnd: 0x9E3779B97F4A7C15}
+}
+
+func (x *timeNode) before(n int64, seq uint64) bool {
+	return x.n < n || (x.n == n && x.seq < seq)
+}
+
+// set indexes e at n, moving it if it was indexed before.
+func (x *timeIndex) set(e interface{}, n int64) {
+	if old, ok := x.nodes[e]; ok {
+		if old.n == n {
+			return
+		}
+		x.remove(old)
+	}
+	x.seq++
+	var prev [timeIndexMaxLevel]*timeNode
+	p := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for p.next[i] != nil && p.next[i].before(n, x.seq) {
+			p = p.next[i]
+		}
+		prev[i] = p
+	}
+	level := x.randomLevel()
+	for ; x.level < level; x.level++ {
+		prev[x.level] = &x.head
+	}
+	node := &timeNode{n: n, seq: x.seq, e: e, next: make([]*timeNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	x.nodes[e] = node
+}
+
+// remove unlinks node from all levels.
+func (x *timeIndex) remove(node *timeNode) {
+	p := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for p.next[i] != nil && p.next[i].before(node.n, node.seq) {
+			p = p.next[i]
+		}
+		if p.next[i] == node {
+			p.next[i] = node.next[i]
+		}
+	}
+	delete(x.nodes, node.e)
+}
+
+// ascend calls f for each element with a timestamp at or after from, in order, until f returns false.
+func (x *timeIndex) ascend(from int64, f func(e interface{}, n int64) bool) {
+	p := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for p.next[i] != nil && p.next[i].n < from {
+			p = p.next[i]
+		}
+	}
+	for p = p.next[0]; p != nil && f(p.e, p.n); p = p.next[0] {
+	}
+}
+
+// randomLevel returns a level with probability 1/4 for each one above the first, using xorshift.
+func (x *timeIndex) randomLevel() int {
+	x.rnd ^= x.rnd << 13
+	x.rnd ^= x.rnd >> 7
+	x.rnd ^= x.rnd << 17
+	level := 1
+	for r := x.rnd; level < timeIndexMaxLevel && r&3 == 0; r >>= 2 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,74 @@
+package lww
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTimeIndex(t *testing.T) {
+	x := newTimeIndex()
+	want := make(map[interface{}]int64)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		e, n := rnd.Intn(500), int64(rnd.Intn(100))
+		x.set(e, n)
+		want[e] = n
+		if i%250 != 0 {
+			continue
+		}
+		from := int64(rnd.Intn(100))
+		var got, exp []int64
+		x.ascend(from, func(e interface{}, n int64) bool {
+			if want[e] != n {
+				t.Fatal("Index has a stale timestamp", e, n, want[e])
+			}
+			got = append(got, n)
+			return true
+		})
+		for _, n := range want {
+			if n >= from {
+				exp = append(exp, n)
+			}
+		}
+		sort.Slice(exp, func(i, j int) bool { return exp[i] < exp[j] })
+		if len(got) != len(exp) || !sort.SliceIsSorted(got, func(i, j int) bool { return got[i] < got[j] }) {
+			t.Fatal("Index does not return elements in order", len(got), len(exp))
+		}
+	}
+	if len(x.nodes) != len(want) {
+		t.Error("Index has duplicate elements", len(x.nodes), len(want))
+	}
+}
+
+func TestSet_rangeByTimeAfterWrites(t *testing.T) {
+	s := Set{}
+	s.Init()
+	s.Set("a", time.Unix(1, 0))
+	s.RangeByTime(time.Unix(0, 0), time.Time{})
+	s.Set("a", time.Unix(5, 0))
+	s.SetFrom("b", time.Unix(2, 0), "r")
+	s.SetMany([]TimedElement{{"c", time.Unix(3, 0)}})
+	if l := s.RangeByTime(time.Unix(2, 0), time.Unix(5, 0)); len(l) != 2 || l[0] != "b" || l[1] != "c" {
+		t.Error("Writes after the index was built are not in it", l)
+	}
+	if l := s.RangeByTime(time.Unix(4, 0), time.Time{}); len(l) != 1 || l[0] != "a" {
+		t.Error("An updated element is not at its new timestamp", l)
+	}
+}
+
+func BenchmarkSet_RangeByTimeInterleaved(b *testing.B) {
+	s := Set{}
+	s.Init()
+	ts := time.Now()
+	for i := 0; i < 100000; i++ {
+		s.Set(i, ts.Add(time.Duration(i)))
+	}
+	s.RangeByTime(ts, time.Time{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Set(i%100000, ts.Add(time.Duration(100000+i)))
+		s.RangeByTime(ts.Add(time.Duration(100000+i)), time.Time{})
+	}
+}
@@ -0,0 +1,418 @@
+package lww
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+/*Tracer starts spans around operations of TracedSet and TracedLWW.
+
+It has the shape of the OpenTelemetry tracer, so an adapter is a few lines and this package does not depend on it:
+
+  type otelTracer struct{ t trace.Tracer }
+
+  func (o otelTracer) Start(ctx context.Context, name string) (context.Context, lww.Span) {
+  	ctx, s := o.t.Start(ctx, name)
+  	return ctx, otelSpan{s}
+  }
+
+  type otelSpan struct{ trace.Span }
+
+  func (s otelSpan) SetAttribute(k string, v interface{}) { s.SetAttributes(attribute.String(k, fmt.Sprint(v))) }
+  func (s otelSpan) RecordError(err error)                 { s.Span.RecordError(err) }
+  func (s otelSpan) End()                                  { s.Span.End() }
+*/
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if there is one, and returns a context which holds it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation. Attribute values are strings, ints or bools.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Attributes set on spans.
+const (
+	// AttrSet is the name of the set, like "add" or "remove".
+	AttrSet = "lww.set"
+	// AttrKey is the key of the set in its store, like SetKey of RedisSet or Table of SQLSet.
+	AttrKey = "lww.key"
+	// AttrElementHash is a hash of the element, so spans can be matched without exposing elements.
+	AttrElementHash = "lww.element_hash"
+	// AttrElements is the number of elements of batch operations and the number of elements returned by List.
+	AttrElements = "lww.elements"
+	// AttrOutcome is "found" or "missing" for reads, "accepted", "stale" or "unknown" for writes and "error" for failures.
+	AttrOutcome = "lww.outcome"
+	// AttrStale is true for writes which the set ignored because it already had a timestamp as new.
+	AttrStale = "lww.stale"
+)
+
+/*TracedSet wraps a TimedSet and starts a span for each of its operations, named like "lww.Get".
+
+TimedSet methods do not take a context, so spans are children of the span in Context.
+WithContext returns a copy bound to the context of a request. Without one spans are roots.
+Like InstrumentedSet it implements all optional interfaces and falls back like LWW does if the wrapped set does not.
+
+Errors are taken from the LastState of the wrapped set after each operation. Like for InstrumentedSet, that state is
+shared, so under concurrent use an error can end up on the span of an operation which ran next to the failing one,
+or on none. Spans are only exact for a set used by one goroutine at a time.
+*/
+type TracedSet struct {
+	TimedSet
+	// Name is the name of the set in spans, like "add" or "remove".
+	Name string
+	// Key is the key of the set in spans. If it is empty the key of RedisSet, ShardedRedisSet, BoltSet, SQLSet and CachedSet is used.
+	Key    string
+	Tracer Tracer
+	// Context is the parent of spans. If it is nil context.Background is used.
+	Context context.Context
+}
+
+// Trace wraps AddSet, RemoveSet and ExpireSet in TracedSets named "add", "remove" and "expire".
+// Sets are set to their defaults first, like Init does. ExpireSet is wrapped only if it is set then.
+// It must be called before Init.
+func (lww *LWW) Trace(t Tracer) {
+	lww.setDefaults()
+	for _, s := range []struct {
+		set  *TimedSet
+		name string
+	}{{&lww.AddSet, "add"}, {&lww.RemoveSet, "remove"}, {&lww.ExpireSet, "expire"}} {
+		if *s.set == nil {
+			continue
+		}
+		*s.set = &TracedSet{TimedSet: *s.set, Name: s.name, Tracer: t}
+	}
+}
+
+// WithContext returns a copy of lww whose TracedSets start their spans as children of the span in ctx.
+// The copy shares the sets of lww.
+func (lww *LWW) WithContext(ctx context.Context) *LWW {
+	c := *lww
+	for _, s := range []*TimedSet{&c.AddSet, &c.RemoveSet, &c.ExpireSet} {
+		if t, ok := (*s).(*TracedSet); ok {
+			*s = t.WithContext(ctx)
+		}
+	}
+	return &c
+}
+
+// WithContext returns a copy of s which starts its spans as children of the span in ctx.
+func (s *TracedSet) WithContext(ctx context.Context) *TracedSet {
+	c := *s
+	c.Context = ctx
+	return &c
+}
+
+// key returns Key, or the key of the wrapped set if it is one of the stores of this package.
+func (s *TracedSet) key() string {
+	if s.Key != "" {
+		return s.Key
+	}
+	switch v := s.TimedSet.(type) {
+	case *RedisSet:
+		return v.SetKey
+	case *ShardedRedisSet:
+		return v.SetKey
+	case *CachedSet:
+		return v.Remote.SetKey
+	case *BoltSet:
+		return v.Bucket
+	case *SQLSet:
+		return v.Table
+	}
+	return ""
+}
+
+// start starts a span of op with the attributes of the set.
+func (s *TracedSet) start(op string) Span {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := s.Tracer.Start(ctx, "lww."+op)
+	span.SetAttribute(AttrSet, s.Name)
+	if k := s.key(); k != "" {
+		span.SetAttribute(AttrKey, k)
+	}
+	return span
+}
+
+// end records the error of the wrapped set, if it has one, sets the outcome and ends span.
+func (s *TracedSet) end(span Span, outcome string) {
+	if err := s.LastState(); err != nil {
+		span.RecordError(err)
+		outcome = "error"
+	}
+	if outcome != "" {
+		span.SetAttribute(AttrOutcome, outcome)
+	}
+	span.End()
+}
+
+// elementHash returns a hash of e which tells elements apart, including elements of different types.
+func elementHash(e interface{}) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%#v", e)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+func found(ok bool) string {
+	if ok {
+		return "found"
+	}
+	return "missing"
+}
+
+//LastState returns the error of the last operation of the wrapped set, or nil if it does not report errors.
+func (s *TracedSet) LastState() error {
+	if st, ok := s.TimedSet.(interface{ LastState() error }); ok {
+		return st.LastState()
+	}
+	return nil
+}
+
+//Init will do a one time setup for underlying set. It will be called from WLL.Init
+func (s *TracedSet) Init() {
+	span := s.start("Init")
+	s.TimedSet.Init()
+	s.end(span, "")
+}
+
+//Len must return the number of members in the set
+func (s *TracedSet) Len() int {
+	span := s.start("Len")
+	n := s.TimedSet.Len()
+	span.SetAttribute(AttrElements, n)
+	s.end(span, "")
+	return n
+}
+
+//Get returns timestmap of the element in the set if it exists and true. Otherwise it will return an empty timestamp and false.
+func (s *TracedSet) Get(e interface{}) (time.Time, bool) {
+	span := s.start("Get")
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	t, ok := s.TimedSet.Get(e)
+	s.end(span, found(ok))
+	return t, ok
+}
+
+//Set adds an element to the set if it does not exists. It it exists Set will update the provided timestamp.
+func (s *TracedSet) Set(e interface{}, t time.Time) {
+	s.Update(e, t)
+}
+
+//Update works like Set and returns true if the write was accepted. If the wrapped set does not implement Updater
+//it always returns true and the outcome of the span is "unknown".
+func (s *TracedSet) Update(e interface{}, t time.Time) bool {
+	span := s.start("Set")
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	u, ok := s.TimedSet.(Updater)
+	if !ok {
+		s.TimedSet.Set(e, t)
+		s.end(span, "unknown")
+		return true
+	}
+	accepted := u.Update(e, t)
+	outcome := "accepted"
+	if !accepted && s.LastState() == nil {
+		outcome = "stale"
+		span.SetAttribute(AttrStale, true)
+	}
+	s.end(span, outcome)
+	return accepted
+}
+
+//List returns list of all elements in the set
+func (s *TracedSet) List() []interface{} {
+	span := s.start("List")
+	l := s.TimedSet.List()
+	span.SetAttribute(AttrElements, len(l))
+	s.end(span, "")
+	return l
+}
+
+//Range calls f for each element in the set and its timestamp. If f returns false Range stops.
+//The span includes the time f takes.
+func (s *TracedSet) Range(f func(interface{}, time.Time) bool) {
+	span := s.start("Range")
+	rangeSet(s.TimedSet, f)
+	s.end(span, "")
+}
+
+//RangeByTime returns elements of the set with a timestamp in [from, to). If to is zero there is no upper bound.
+func (s *TracedSet) RangeByTime(from, to time.Time) []interface{} {
+	span := s.start("RangeByTime")
+	l := rangeByTime(s.TimedSet, from, to)
+	span.SetAttribute(AttrElements, len(l))
+	s.end(span, "")
+	return l
+}
+
+//SetMany works like calling Set for each element in order.
+func (s *TracedSet) SetMany(es []TimedElement) {
+	span := s.start("SetMany")
+	span.SetAttribute(AttrElements, len(es))
+	setBatch(s.TimedSet, es)
+	s.end(span, "")
+}
+
+//GetMany works like calling Get for each element. Results are in the same order as the elements.
+func (s *TracedSet) GetMany(es []interface{}) ([]time.Time, []bool) {
+	span := s.start("GetMany")
+	span.SetAttribute(AttrElements, len(es))
+	ts, oks := getMany(s.TimedSet, es)
+	s.end(span, "")
+	return ts, oks
+}
+
+//SetFrom works like Set and keeps replica as the writer of the timestamp if the wrapped set implements ProvenanceSet.
+func (s *TracedSet) SetFrom(e interface{}, t time.Time, replica string) {
+	s.UpdateFrom(e, t, replica)
+}
+
+//UpdateFrom works like SetFrom and returns true if the write was accepted. If the wrapped set implements
+//ProvenanceSet but not ProvenanceUpdater it always returns true and the outcome of the span is "unknown".
+func (s *TracedSet) UpdateFrom(e interface{}, t time.Time, replica string) bool {
+	p, ok := s.TimedSet.(ProvenanceSet)
+	if !ok {
+		return s.Update(e, t)
+	}
+	span := s.start("SetFrom")
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	u, ok := p.(ProvenanceUpdater)
+	if !ok {
+		p.SetFrom(e, t, replica)
+		s.end(span, "unknown")
+		return true
+	}
+	accepted := u.UpdateFrom(e, t, replica)
+	outcome := "accepted"
+	if !accepted && s.LastState() == nil {
+		outcome = "stale"
+		span.SetAttribute(AttrStale, true)
+	}
+	s.end(span, outcome)
+	return accepted
+}
+
+//GetFrom works like Get and also returns the replica which wrote the timestamp, or "" if it is not known.
+func (s *TracedSet) GetFrom(e interface{}) (time.Time, string, bool) {
+	span := s.start("GetFrom")
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	t, r, ok := get(s.TimedSet, e)
+	s.end(span, found(ok))
+	return t, r, ok
+}
+
+//GetAt returns the greatest timestamp of the element which is not after t and true, or false if there is none.
+//If the wrapped set does not implement HistorySet it only knows the current timestamp.
+func (s *TracedSet) GetAt(e interface{}, t time.Time) (time.Time, bool) {
+	span := s.start("GetAt")
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	val, ok := getAt(s.TimedSet, e, t)
+	s.end(span, found(ok))
+	return val, ok
+}
+
+/*TracedLWW starts a span for each operation of LWW, named like "lww.LWW.Exists".
+Its methods take the context of the request, and if the sets of LWW are TracedSets their spans are children of it.
+
+  l := lww.LWW{}
+  l.Trace(tracer)
+  l.Init()
+  t := lww.TracedLWW{LWW: &l, Tracer: tracer}
+  exists := t.Exists(ctx, "x")
+*/
+type TracedLWW struct {
+	LWW    *LWW
+	Tracer Tracer
+}
+
+// start starts a span of op and returns LWW bound to its context.
+func (l TracedLWW) start(ctx context.Context, op string) (*LWW, Span) {
+	ctx, span := l.Tracer.Start(ctx, "lww.LWW."+op)
+	return l.LWW.WithContext(ctx), span
+}
+
+// Add works like LWW.Add.
+func (l TracedLWW) Add(ctx context.Context, e interface{}, t time.Time) {
+	w, span := l.start(ctx, "Add")
+	defer span.End()
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	w.Add(e, t)
+}
+
+// Remove works like LWW.Remove.
+func (l TracedLWW) Remove(ctx context.Context, e interface{}, t time.Time) {
+	w, span := l.start(ctx, "Remove")
+	defer span.End()
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	w.Remove(e, t)
+}
+
+// AddWithTTL works like LWW.AddWithTTL.
+func (l TracedLWW) AddWithTTL(ctx context.Context, e interface{}, t time.Time, ttl time.Duration) {
+	w, span := l.start(ctx, "AddWithTTL")
+	defer span.End()
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	w.AddWithTTL(e, t, ttl)
+}
+
+// Exists works like LWW.Exists.
+func (l TracedLWW) Exists(ctx context.Context, e interface{}) bool {
+	w, span := l.start(ctx, "Exists")
+	defer span.End()
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	ok := w.Exists(e)
+	span.SetAttribute(AttrOutcome, found(ok))
+	return ok
+}
+
+// State works like LWW.State.
+func (l TracedLWW) State(ctx context.Context, e interface{}) ElementState {
+	w, span := l.start(ctx, "State")
+	defer span.End()
+	span.SetAttribute(AttrElementHash, elementHash(e))
+	st := w.State(e)
+	span.SetAttribute(AttrOutcome, found(st.Exists))
+	return st
+}
+
+// Get works like LWW.Get.
+func (l TracedLWW) Get(ctx context.Context) []interface{} {
+	w, span := l.start(ctx, "Get")
+	defer span.End()
+	r := w.Get()
+	span.SetAttribute(AttrElements, len(r))
+	return r
+}
+
+// AddMany works like LWW.AddMany.
+func (l TracedLWW) AddMany(ctx context.Context, es []TimedElement) {
+	w, span := l.start(ctx, "AddMany")
+	defer span.End()
+	span.SetAttribute(AttrElements, len(es))
+	w.AddMany(es)
+}
+
+// RemoveMany works like LWW.RemoveMany.
+func (l TracedLWW) RemoveMany(ctx context.Context, es []TimedElement) {
+	w, span := l.start(ctx, "RemoveMany")
+	defer span.End()
+	span.SetAttribute(AttrElements, len(es))
+	w.RemoveMany(es)
+}
+
+// ExistsMany works like LWW.ExistsMany.
+func (l TracedLWW) ExistsMany(ctx context.Context, es []interface{}) []bool {
+	w, span := l.start(ctx, "ExistsMany")
+	defer span.End()
+	span.SetAttribute(AttrElements, len(es))
+	return w.ExistsMany(es)
+}
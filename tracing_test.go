@@ -0,0 +1,190 @@
+package lww
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedSpan is a span of recordingTracer.
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	errs   []error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttribute(k string, v interface{}) { s.attrs[k] = v }
+func (s *recordedSpan) RecordError(err error)                { s.errs = append(s.errs, err) }
+func (s *recordedSpan) End()                                 { s.ended = true }
+
+type spanKey struct{}
+
+// recordingTracer keeps all spans it starts, with their parent from the context.
+type recordingTracer struct {
+	sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.Lock()
+	defer t.Unlock()
+	s := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	s.parent, _ = ctx.Value(spanKey{}).(*recordedSpan)
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *recordingTracer) find(name string) []*recordedSpan {
+	var l []*recordedSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+func TestTracedSet(t *testing.T) {
+	tr := &recordingTracer{}
+	db := openSQLite(t)
+	q := setupSQLSet(db, "traced")
+	s := TracedSet{TimedSet: &q, Name: "q", Tracer: tr}
+	ts := time.Now()
+	s.Set("x", ts)
+	s.Set("x", ts)
+	s.Get("x")
+	s.Get("y")
+	s.List()
+	s.SetMany([]TimedElement{{"y", ts}, {"z", ts}})
+
+	sets := tr.find("lww.Set")
+	if len(sets) != 2 || sets[0].attrs[AttrOutcome] != "accepted" || sets[1].attrs[AttrOutcome] != "stale" || sets[1].attrs[AttrStale] != true {
+		t.Fatal("Set spans do not tell stale writes", sets)
+	}
+	if sets[0].attrs[AttrSet] != "q" || sets[0].attrs[AttrKey] != "traced" || sets[0].attrs[AttrElementHash] != elementHash("x") || !sets[0].ended {
+		t.Error("Set span does not have the attributes of the set", sets[0].attrs)
+	}
+	if gets := tr.find("lww.Get"); len(gets) != 2 || gets[0].attrs[AttrOutcome] != "found" || gets[1].attrs[AttrOutcome] != "missing" {
+		t.Error("Get spans do not tell if the element was found", gets)
+	}
+	if l := tr.find("lww.List"); len(l) != 1 || l[0].attrs[AttrElements] != 1 {
+		t.Error("List span does not have the number of elements", l)
+	}
+	if l := tr.find("lww.SetMany"); len(l) != 1 || l[0].attrs[AttrElements] != 2 {
+		t.Error("SetMany span does not have the number of elements", l)
+	}
+
+	db.Close()
+	s.Get("x")
+	gets := tr.find("lww.Get")
+	if last := gets[len(gets)-1]; last.attrs[AttrOutcome] != "error" || len(last.errs) != 1 {
+		t.Error("Errors of the wrapped set are not recorded", last.attrs, last.errs)
+	}
+
+	// A missing element of a RedisSet is not an error.
+	r := setupSet(t, "TESTTRACEDMISS")
+	s = TracedSet{TimedSet: &r, Name: "r", Tracer: tr}
+	s.Get("x")
+	gets = tr.find("lww.Get")
+	if last := gets[len(gets)-1]; last.attrs[AttrOutcome] != "missing" || len(last.errs) != 0 {
+		t.Error("A missing element of a RedisSet is recorded as an error", last.attrs, last.errs)
+	}
+
+	if elementHash(1) == elementHash("1") || elementHash("a") != elementHash("a") {
+		t.Error("Element hashes do not tell elements apart")
+	}
+}
+
+func TestLWW_TraceReplica(t *testing.T) {
+	tr := &recordingTracer{}
+	l := LWW{ReplicaID: "a"}
+	l.Trace(tr)
+	l.Init()
+	ts := time.Now()
+	l.Add("x", ts)
+	l.Add("x", ts.Add(-time.Second))
+	sets := tr.find("lww.SetFrom")
+	if len(sets) != 2 || sets[0].attrs[AttrOutcome] != "accepted" || sets[1].attrs[AttrOutcome] != "stale" || sets[1].attrs[AttrStale] != true {
+		t.Error("SetFrom spans do not tell stale writes", sets)
+	}
+}
+
+func TestLWW_TraceHistory(t *testing.T) {
+	tr := &recordingTracer{}
+	l := LWW{AddSet: &Set{History: true}, RemoveSet: &Set{History: true}}
+	l.Trace(tr)
+	l.Init()
+	ts := time.Unix(0, 0)
+	l.Add("a", ts.Add(10))
+	l.Add("a", ts.Add(30))
+	if !l.ExistsAt("a", ts.Add(20)) || l.ExistsAt("a", ts.Add(5)) {
+		t.Error("ExistsAt over TracedSets does not use the history")
+	}
+	if l := tr.find("lww.GetAt"); len(l) != 4 || l[0].attrs[AttrSet] != "add" || l[0].attrs[AttrOutcome] != "found" || l[1].attrs[AttrOutcome] != "missing" {
+		t.Error("GetAt spans are not correct", l)
+	}
+}
+
+func TestTracedLWW(t *testing.T) {
+	tr := &recordingTracer{}
+	l := LWW{}
+	l.Trace(tr)
+	l.Init()
+	tl := TracedLWW{LWW: &l, Tracer: tr}
+	ctx, root := tr.Start(context.Background(), "request")
+	ts := time.Now()
+	tl.Add(ctx, "x", ts)
+	tl.Remove(ctx, "x", ts.Add(time.Second))
+	if tl.Exists(ctx, "x") {
+		t.Error("Traced LWW does not work like LWW")
+	}
+	tl.AddMany(ctx, []TimedElement{{"y", ts}})
+	if r := tl.ExistsMany(ctx, []interface{}{"y"}); !r[0] || len(tl.Get(ctx)) != 1 {
+		t.Error("Traced LWW does not work like LWW", r, tl.Get(ctx))
+	}
+
+	exists := tr.find("lww.LWW.Exists")
+	if len(exists) != 1 || exists[0].parent != root || exists[0].attrs[AttrOutcome] != "missing" || !exists[0].ended {
+		t.Fatal("LWW span is not a child of the request", exists)
+	}
+	gets := tr.find("lww.Get")
+	if len(gets) < 2 || gets[0].parent != exists[0] || gets[0].attrs[AttrSet] != "add" {
+		t.Error("Set spans are not children of the LWW span", gets)
+	}
+	for _, s := range tr.spans {
+		if s.parent == nil && s != root && s.name != "lww.Init" {
+			t.Error("Span has no parent", s.name)
+		}
+	}
+
+	// Without a context set spans are roots.
+	l.Add("z", ts)
+	if sets := tr.find("lww.Set"); sets[len(sets)-1].parent != nil {
+		t.Error("Span of a set without context has a parent")
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+func BenchmarkTracedSet_Set(b *testing.B) {
+	s := TracedSet{TimedSet: &Set{}, Name: "s", Tracer: noopTracer{}}
+	s.Init()
+	ts := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Set(i%1000, ts.Add(time.Duration(i)))
+	}
+}
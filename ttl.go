@@ -0,0 +1,141 @@
+package lww
+
+import (
+	"errors"
+	"time"
+)
+
+// errNoExpireSet is the LastState of AddWithTTL if ExpireSet is nil.
+var errNoExpireSet = errors.New("ExpireSet must be set to use AddWithTTL")
+
+// AddWithTTL works like Add, but the element stops to exist at t+ttl unless it is added again.
+// Expiry is decided at read time from the stored timestamps, so it does not need a background writer.
+// If ExpireSet is nil the element is not added and LastState returns an error.
+//
+// The deadline is kept in ExpireSet, which like other sets only keeps the greatest one. An Add without TTL
+// before the deadline does not cancel it, while an Add after the deadline makes the element exist again without one.
+// The deadline is written first, so a failure before the add leaves a deadline but never an element without one.
+func (lww *LWW) AddWithTTL(e interface{}, t time.Time, ttl time.Duration) {
+	if !lww.canExpire() {
+		return
+	}
+	lww.ExpireSet.Set(e, t.Add(ttl))
+	lww.Add(e, t)
+}
+
+// canExpire reports in LastState if ExpireSet is nil and returns false if it is.
+func (lww *LWW) canExpire() bool {
+	if lww.ExpireSet == nil {
+		lww.checkErr(errNoExpireSet)
+		return false
+	}
+	lww.checkErr(nil)
+	return true
+}
+
+// setDefaults sets AddSet and RemoveSet to a lww.Set if they are nil, and ExpireSet too if they keep
+// their elements in memory.
+func (lww *LWW) setDefaults() {
+	if lww.AddSet == nil {
+		lww.AddSet = &Set{}
+	}
+	if lww.RemoveSet == nil {
+		lww.RemoveSet = &Set{}
+	}
+	if lww.ExpireSet == nil && inMemory(lww.AddSet) && inMemory(lww.RemoveSet) {
+		lww.ExpireSet = &Set{}
+	}
+}
+
+// inMemory reports if s keeps its elements in this process only, so their deadlines can be kept there too.
+func inMemory(s TimedSet) bool {
+	switch s.(type) {
+	case *Set, *ShardedSet, *AtomicSet:
+		return true
+	}
+	return false
+}
+
+// Sweep turns elements which are expired at the time of the call into removes at their deadline.
+// It does not change which elements exist. It is useful to make expiry visible to readers which only look at
+// AddSet and RemoveSet, and it is safe to run on many replicas at once. It returns the number of removes it wrote.
+//
+// The first Sweep reads all deadlines up to now. Later ones only read deadlines from the time of the previous one,
+// so their cost depends on how many elements expired since. A deadline which is already passed when it is written,
+// or which reaches this replica late, is not swept, but the element still does not exist.
+func (lww *LWW) Sweep() int {
+	if lww.ExpireSet == nil {
+		return 0
+	}
+	now := lww.now()
+	from := time.Unix(0, 0)
+	if t, ok := lww.swept.Load().(time.Time); ok && t.Before(now) {
+		from = t
+	}
+	n := 0
+	// Deadlines up to a microsecond later are read too, as RedisSet rounds timestamps to microseconds.
+	// isExpired then decides with the exact values.
+	for _, e := range rangeByTime(lww.ExpireSet, from, now.Add(time.Microsecond)) {
+		d, dok := lww.deadline(e)
+		a, aok := lww.AddSet.Get(e)
+		if !dok || !aok || !isExpired(a, d, now) {
+			continue
+		}
+		if r, ok := lww.RemoveSet.Get(e); ok && r.UnixNano() >= d.UnixNano() {
+			continue
+		}
+		lww.Remove(e, d)
+		n++
+	}
+	lww.swept.Store(now)
+	return n
+}
+
+// StartSweeper calls Sweep every interval in a new goroutine until the returned stop function is called.
+// Sweeping is optional, as expired elements do not exist anyway.
+func (lww *LWW) StartSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				lww.Sweep()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (lww *LWW) now() time.Time {
+	if lww.Now != nil {
+		return lww.Now()
+	}
+	return time.Now()
+}
+
+// expired returns true if the deadline of e in ExpireSet has passed and a, the timestamp of its latest add, is before it.
+func (lww *LWW) expired(e interface{}, a time.Time) bool {
+	d, ok := lww.deadline(e)
+	return ok && isExpired(a, d, lww.now())
+}
+
+// deadline returns the deadline of e in ExpireSet. It returns false if there is none or ExpireSet is nil.
+func (lww *LWW) deadline(e interface{}) (time.Time, bool) {
+	if lww.ExpireSet == nil {
+		return time.Time{}, false
+	}
+	return lww.ExpireSet.Get(e)
+}
+
+func isExpired(a, d, now time.Time) bool {
+	return a.UnixNano() < d.UnixNano() && d.UnixNano() <= now.UnixNano()
+}
@@ -0,0 +1,144 @@
+package lww
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLWW_AddWithTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := LWW{Now: func() time.Time { return now }}
+	l.Init()
+
+	l.AddWithTTL("a", now, time.Minute)
+	l.Add("b", now)
+	if !l.Exists("a") || len(l.Get()) != 2 {
+		t.Error("Element with TTL does not exist before its deadline")
+	}
+	if st := l.State("a"); !st.Expires.Equal(now.Add(time.Minute)) || !st.Exists {
+		t.Error("State does not return the deadline", st)
+	}
+
+	now = now.Add(time.Minute)
+	if l.Exists("a") || !l.Exists("b") || len(l.Get()) != 1 {
+		t.Error("Element with TTL exists after its deadline")
+	}
+	if ex := l.ExistsMany([]interface{}{"a", "b"}); ex[0] || !ex[1] {
+		t.Error("ExistsMany does not check deadlines", ex)
+	}
+	if st := l.State("a"); st.Exists {
+		t.Error("State of an expired element is not correct", st)
+	}
+
+	l.AddWithTTL("a", now, time.Minute)
+	if !l.Exists("a") {
+		t.Error("Element with a refreshed TTL does not exist")
+	}
+	l.Add("a", now.Add(time.Second))
+	now = now.Add(2 * time.Minute)
+	if l.Exists("a") {
+		t.Error("Add before the deadline cancelled the TTL")
+	}
+	l.Add("a", now)
+	now = now.Add(time.Hour)
+	if !l.Exists("a") || !l.State("a").Expires.IsZero() {
+		t.Error("Add after the deadline did not make the element exist again")
+	}
+}
+
+func TestLWW_Sweep(t *testing.T) {
+	now := time.Now().Round(time.Microsecond)
+	add, remove, expire := setupSet(t, "TESTTTLADD"), setupSet(t, "TESTTTLREMOVE"), setupSet(t, "TESTTTLEXPIRE")
+	for _, l := range []LWW{{}, {AddSet: &add, RemoveSet: &remove, ExpireSet: &expire}} {
+		l.Now = func() time.Time { return now }
+		l.Init()
+		l.AddWithTTL("a", now, time.Minute)
+		l.AddWithTTL("b", now, time.Hour)
+		l.Add("c", now)
+
+		if n := l.Sweep(); n != 0 {
+			t.Error("Sweep removed elements before their deadline", n)
+		}
+		now = now.Add(time.Minute)
+		if n := l.Sweep(); n != 1 || l.Sweep() != 0 {
+			t.Error("Sweep did not remove the expired element once", n)
+		}
+		if r, ok := l.RemoveSet.Get("a"); !ok || !r.Equal(now) {
+			t.Error("Sweep did not remove at the deadline", r, ok)
+		}
+		if l.Exists("a") || !l.Exists("b") || !l.Exists("c") {
+			t.Error("Sweep changed which elements exist")
+		}
+		now = now.Add(-time.Minute)
+	}
+}
+
+func TestLWW_StartSweeper(t *testing.T) {
+	l := LWW{}
+	l.Init()
+	l.AddWithTTL("a", time.Now(), time.Millisecond)
+	stop := l.StartSweeper(time.Millisecond)
+	defer stop()
+	eventually(t, "Sweeper did not remove the expired element", func() bool {
+		_, ok := l.RemoveSet.Get("a")
+		return ok
+	})
+}
+
+func TestLWW_withoutExpireSet(t *testing.T) {
+	l := LWW{}
+	l.Init()
+	if _, ok := l.ExpireSet.(*Set); !ok {
+		t.Error("Init did not set ExpireSet for sets in memory")
+	}
+	// A set which is not known to be in memory may be shared with other replicas.
+	l = LWW{AddSet: listOnly{&Set{}}}
+	l.Init()
+	if l.ExpireSet != nil {
+		t.Fatal("Init set ExpireSet for a set which may be remote")
+	}
+	l.Add("a", time.Now())
+	if !l.Exists("a") || !l.State("a").Expires.IsZero() || l.Sweep() != 0 {
+		t.Error("LWW without ExpireSet does not work")
+	}
+	l.AddWithTTL("b", time.Now(), time.Minute)
+	if _, ok := l.AddSet.Get("b"); ok || l.LastState() == nil {
+		t.Error("AddWithTTL without ExpireSet did not fail", l.LastState())
+	}
+	l.ExpireSet = &Set{}
+	l.ExpireSet.Init()
+	l.AddWithTTL("b", time.Now(), time.Minute)
+	if !l.Exists("b") || l.LastState() != nil {
+		t.Error("AddWithTTL did not clear its error", l.LastState())
+	}
+}
+
+func TestLWW_sweepFromLast(t *testing.T) {
+	now := time.Unix(1000, 0)
+	expire := &countingRanges{TimedSet: &Set{}}
+	l := LWW{ExpireSet: expire, Now: func() time.Time { return now }}
+	l.Init()
+	for i := 0; i < 100; i++ {
+		l.AddWithTTL(i, now, time.Duration(i)*time.Second)
+	}
+	now = now.Add(50 * time.Second)
+	if n := l.Sweep(); n != 50 {
+		t.Error("Sweep did not remove expired elements", n)
+	}
+	now = now.Add(10 * time.Second)
+	if n := l.Sweep(); n != 10 || expire.visited != 51+11 {
+		t.Error("Sweep read deadlines it swept before", n, expire.visited)
+	}
+}
+
+// countingRanges counts the elements RangeByTime returns.
+type countingRanges struct {
+	TimedSet
+	visited int
+}
+
+func (s *countingRanges) RangeByTime(from, to time.Time) []interface{} {
+	l := rangeByTime(s.TimedSet, from, to)
+	s.visited += len(l)
+	return l
+}